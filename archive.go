@@ -0,0 +1,207 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sbdtu5498/github-tree/pkg/ghtree"
+)
+
+// archiveKind tells the archive format from the file name: "tar.gz",
+// "tar" or "zip", or empty for names it doesn't recognize
+func archiveKind(name string) string {
+	lower := strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		return "tar.gz"
+	case strings.HasSuffix(lower, ".tar"):
+		return "tar"
+	case strings.HasSuffix(lower, ".zip"):
+		return "zip"
+	}
+	return ""
+}
+
+// archiveWriter adds directories and files to a tar or zip archive
+type archiveWriter interface {
+	addDir(name string) error
+	addFile(name string, data []byte, executable bool) error
+	Close() error
+}
+
+// writeArchive downloads the files of roots into the --archive file, laid
+// out below each root as --download would lay them out. Files are fetched
+// one at a time, since the archive is written in order; a failure removes
+// the partial archive.
+func writeArchive(ctx context.Context, client *ghtree.Client, roots []*ghtree.Node) (err error) {
+	f, err := os.Create(archiveFlag)
+	if err != nil {
+		return fmt.Errorf("failed to create archive: %w", err)
+	}
+	defer func() {
+		if closeErr := f.Close(); err == nil && closeErr != nil {
+			err = fmt.Errorf("failed to write archive: %w", closeErr)
+		}
+		if err != nil {
+			os.Remove(archiveFlag)
+		}
+	}()
+
+	var w archiveWriter
+	switch archiveKind(archiveFlag) {
+	case "zip":
+		w = &zipArchive{w: zip.NewWriter(f)}
+	case "tar":
+		w = &tarArchive{w: tar.NewWriter(f)}
+	default:
+		gz := gzip.NewWriter(f)
+		w = &tarArchive{w: tar.NewWriter(gz), gz: gz}
+	}
+
+	progress := newDownloadProgress(countFiles(roots))
+	var files int
+	var written int64
+	var add func(nodes []*ghtree.Node, rootPath string) error
+	add = func(nodes []*ghtree.Node, rootPath string) error {
+		for _, node := range nodes {
+			// A lone file root lands at the top of the archive
+			rel := strings.TrimPrefix(node.Path, rootPath+"/")
+			if rootPath == "" {
+				rel = node.Path
+			} else if node.Path == rootPath {
+				rel = node.Name
+			}
+
+			// A followed submodule holds files like a directory
+			kind := node.Type
+			if kind == "submodule" && node.Children != nil {
+				kind = "dir"
+			}
+			switch kind {
+			case "dir":
+				err := w.addDir(rel + "/")
+				if err == nil {
+					err = add(node.Children, rootPath)
+				}
+				if err != nil {
+					return err
+				}
+			case "file":
+				var data bytes.Buffer
+				n, err := downloadVerified(ctx, client, node, &data, func() error {
+					data.Reset()
+					return nil
+				})
+				if err != nil {
+					return err
+				}
+				err = w.addFile(rel, data.Bytes(), node.Executable)
+				if err != nil {
+					return fmt.Errorf("failed to write archive: %w", err)
+				}
+				files++
+				written += n
+				progress.update(files, written)
+			}
+		}
+		return nil
+	}
+	for _, root := range roots {
+		err = add(root.TopLevel(), root.Path)
+		if err != nil {
+			progress.done()
+			return err
+		}
+	}
+	progress.done()
+
+	err = w.Close()
+	if err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	infof("archived %s (%s) in %s", plural(files, "file", "files"), ghtree.HumanizeBytes(written), archiveFlag)
+	return nil
+}
+
+// countFiles counts the files below roots
+func countFiles(roots []*ghtree.Node) int {
+	count := 0
+	var walk func(nodes []*ghtree.Node)
+	walk = func(nodes []*ghtree.Node) {
+		for _, node := range nodes {
+			if node.Type == "file" {
+				count++
+			}
+			walk(node.Children)
+		}
+	}
+	for _, root := range roots {
+		walk(root.TopLevel())
+	}
+	return count
+}
+
+// tarArchive writes a tarball, gzipped when gz is set
+type tarArchive struct {
+	w  *tar.Writer
+	gz *gzip.Writer
+}
+
+func (a *tarArchive) addDir(name string) error {
+	return a.w.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: name, Mode: 0755, ModTime: time.Now()})
+}
+
+func (a *tarArchive) addFile(name string, data []byte, executable bool) error {
+	mode := int64(0644)
+	if executable {
+		mode = 0755
+	}
+	err := a.w.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: mode, Size: int64(len(data)), ModTime: time.Now()})
+	if err == nil {
+		_, err = a.w.Write(data)
+	}
+	return err
+}
+
+func (a *tarArchive) Close() error {
+	err := a.w.Close()
+	if a.gz != nil && err == nil {
+		err = a.gz.Close()
+	}
+	return err
+}
+
+// zipArchive writes a zip archive
+type zipArchive struct {
+	w *zip.Writer
+}
+
+func (a *zipArchive) addDir(name string) error {
+	_, err := a.w.CreateHeader(&zip.FileHeader{Name: name, Modified: time.Now()})
+	return err
+}
+
+func (a *zipArchive) addFile(name string, data []byte, executable bool) error {
+	header := &zip.FileHeader{Name: name, Method: zip.Deflate, Modified: time.Now()}
+	mode := os.FileMode(0644)
+	if executable {
+		mode = 0755
+	}
+	header.SetMode(mode)
+	w, err := a.w.CreateHeader(header)
+	if err == nil {
+		_, err = w.Write(data)
+	}
+	return err
+}
+
+func (a *zipArchive) Close() error {
+	return a.w.Close()
+}
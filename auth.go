@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sbdtu5498/github-tree/pkg/ghtree"
+)
+
+// tokenKindNames describe the kinds of ghtree.TokenInfo for people
+var tokenKindNames = map[string]string{
+	"classic":      "classic personal access token",
+	"fine-grained": "fine-grained personal access token",
+	"oauth":        "OAuth app token",
+	"app-user":     "GitHub App user token",
+	"app":          "GitHub App installation token",
+	"unknown":      "token of unknown kind",
+}
+
+// authStatus prints what GitHub says of the token: its kind and where it
+// came from, the user it acts for, its scopes, its expiry and the rate
+// limit left. Given a repository, it then checks the token can read it,
+// failing with the likely reason if not.
+func authStatus(ctx context.Context, client *ghtree.Client, tokenSource, owner, repo string) error {
+	info, err := client.TokenInfo(ctx)
+	if err != nil {
+		return err
+	}
+
+	if info.Kind == "" {
+		fmt.Fprintf(out, "token: none found (checked %s)\n", tokenSearchOrder(providerFlag))
+	} else {
+		fmt.Fprintf(out, "token: %s, from %s\n", tokenKindNames[info.Kind], tokenSource)
+	}
+	if info.Login != "" {
+		fmt.Fprintf(out, "user: %s\n", info.Login)
+	}
+	switch {
+	case info.Scopes != nil && len(info.Scopes) == 0:
+		fmt.Fprintln(out, "scopes: none, so only public repositories can be read")
+	case info.Scopes != nil:
+		fmt.Fprintf(out, "scopes: %s\n", strings.Join(info.Scopes, ", "))
+	case info.Kind == "fine-grained" || info.Kind == "app" || info.Kind == "app-user":
+		fmt.Fprintln(out, "scopes: none; access is granted per repository")
+	}
+	if !info.Expires.IsZero() {
+		fmt.Fprintf(out, "expires: %s (%s)\n", info.Expires.Format("2006-01-02 15:04 MST"), untilExpiry(info.Expires))
+	}
+	if stats := client.Stats(); stats.RateLimit > 0 {
+		fmt.Fprintf(out, "rate limit: %d of %d remaining, resetting at %s\n", stats.RateLimitRemaining, stats.RateLimit, stats.RateLimitReset.Format(time.Kitchen))
+	}
+
+	if owner == "" || repo == "" {
+		return nil
+	}
+	repository, err := client.Repository(ctx)
+	if err != nil {
+		return explainAccess(err, info, owner, repo)
+	}
+	visibility := "public"
+	if repository.Private {
+		visibility = "private"
+	}
+	if !repository.CanPull {
+		return fmt.Errorf("repository %s/%s: the token can see it, but not read it", owner, repo)
+	}
+	fmt.Fprintf(out, "repository %s/%s: readable (%s)\n", owner, repo, visibility)
+	return nil
+}
+
+// untilExpiry says how long is left before expires
+func untilExpiry(expires time.Time) string {
+	left := time.Until(expires)
+	switch {
+	case left <= 0:
+		return "expired"
+	case left < 24*time.Hour:
+		return "in less than a day"
+	}
+	return "in " + plural(int(left/(24*time.Hour)), "day", "days")
+}
+
+// accessError explains why a repository couldn't be read, keeping the API
+// error behind it for the exit status
+type accessError struct {
+	msg string
+	err error
+}
+
+func (e *accessError) Error() string {
+	return e.msg
+}
+
+func (e *accessError) Unwrap() error {
+	return e.err
+}
+
+// explainAccess explains err, from looking up owner/repo with the token
+// info describes. GitHub hides repositories a token can't see behind a
+// 404, so for a classic token that is most often a missing repo scope.
+func explainAccess(err error, info *ghtree.TokenInfo, owner, repo string) error {
+	var apiErr *ghtree.APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound && apiErr.StatusCode != http.StatusForbidden {
+		return err
+	}
+
+	reason := "it doesn't exist, or the token can't see it"
+	switch {
+	case info == nil:
+	case info.Kind == "":
+		reason = "it doesn't exist, or it is private and needs a token"
+	case info.Scopes != nil && !info.HasScope("repo"):
+		reason = "it doesn't exist, or it is private and the token lacks the repo scope private repositories need (GitHub answers 404 rather than 403 for them); add the repo scope to the token"
+	case info.Kind == "fine-grained":
+		reason = "it doesn't exist, or it isn't among the repositories the fine-grained token was granted; make sure it is selected and has read access to contents"
+	case info.Kind == "app":
+		reason = "it doesn't exist, or the GitHub App isn't installed on it"
+	}
+	return &accessError{msg: fmt.Sprintf("cannot access %s/%s: %s", owner, repo, reason), err: err}
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// command is a subcommand. All of them share the one flag set; a command
+// turns its positional arguments into flag settings and shows only the
+// flag groups that matter to it in its help.
+type command struct {
+	name    string
+	args    string
+	summary string
+
+	// groups names the flag groups of its help; nil shows them all
+	groups []string
+
+	// apply takes the positional arguments left after flag parsing
+	apply func(args []string) error
+}
+
+// commands lists the subcommands; the first is the default
+var commands = []command{
+	{
+		name:    "show",
+		args:    "[owner/repo | URL]",
+		summary: "Draw the tree of a repository (the default command)",
+		apply:   applyRepositoryArgs,
+	},
+	{
+		name:    "diff",
+		args:    "BASE [HEAD]",
+		summary: "Draw what changed between two refs; HEAD defaults to --ref or the default branch",
+		groups:  []string{"Repository", "Selection", "Output", "Network", "Diagnostics"},
+		apply: func(args []string) error {
+			if len(args) == 0 || len(args) > 2 {
+				return &usageError{msg: "usage: github-tree diff [flags] BASE [HEAD]"}
+			}
+			baseFlag = args[0]
+			if len(args) == 2 {
+				headFlag = args[1]
+			}
+			return nil
+		},
+	},
+	{
+		name:    "diff-repos",
+		args:    "owner/repo owner/repo",
+		summary: "Draw what differs between the same paths of two repositories: entries only in one, and files whose contents differ",
+		groups:  []string{"Repository", "Selection", "Output", "Network", "Diagnostics"},
+		apply: func(args []string) error {
+			if len(args) != 2 {
+				return &usageError{msg: "usage: github-tree diff-repos [flags] owner/repo owner/repo"}
+			}
+			diffRepoFlag = args[1]
+			return applyRepositoryArgs(args[:1])
+		},
+	},
+	{
+		name:    "download",
+		args:    "DIR [owner/repo | URL]",
+		summary: "Copy the files of the tree into DIR",
+		groups:  []string{"Repository", "Selection", "Actions", "Network", "Configuration", "Diagnostics"},
+		apply: func(args []string) error {
+			if len(args) == 0 {
+				return &usageError{msg: "usage: github-tree download [flags] DIR [owner/repo | URL]"}
+			}
+			downloadFlag = args[0]
+			return applyRepositoryArgs(args[1:])
+		},
+	},
+	{
+		name:    "stats",
+		args:    "[owner/repo | URL]",
+		summary: "Count files and bytes by extension or language, over the whole tree unless --maxDepth is given",
+		groups:  []string{"Repository", "Selection", "Output", "Network", "Configuration", "Diagnostics"},
+		apply: func(args []string) error {
+			statsFlag = true
+			if !isFlagSet("maxDepth") {
+				maxDepthFlag = -1
+				explicitFlags["maxDepth"] = true
+			}
+			return applyRepositoryArgs(args)
+		},
+	},
+	{
+		name:    "du",
+		args:    "[owner/repo | URL]",
+		summary: "Print the largest files and the directories holding the most bytes, over the whole tree unless --maxDepth is given",
+		groups:  []string{"Repository", "Selection", "Output", "Network", "Configuration", "Diagnostics"},
+		apply: func(args []string) error {
+			duFlag = true
+			if !isFlagSet("maxDepth") {
+				maxDepthFlag = -1
+				explicitFlags["maxDepth"] = true
+			}
+			return applyRepositoryArgs(args)
+		},
+	},
+	{
+		name:    "search",
+		args:    "PATTERN [owner/repo | URL]",
+		summary: "Print the paths matching a glob, or a regular expression with --regex, over the whole tree unless --maxDepth is given",
+		groups:  []string{"Repository", "Selection", "Network", "Configuration", "Diagnostics"},
+		apply: func(args []string) error {
+			if len(args) == 0 {
+				return &usageError{msg: "usage: github-tree search [flags] PATTERN [owner/repo | URL]"}
+			}
+			searchFlag = args[0]
+			if !isFlagSet("maxDepth") {
+				maxDepthFlag = -1
+				explicitFlags["maxDepth"] = true
+			}
+			return applyRepositoryArgs(args[1:])
+		},
+	},
+	{
+		name:    "verify",
+		args:    "[owner/repo | URL]",
+		summary: "Compare the tree with the local checkout given by --local, failing if they differ",
+		groups:  []string{"Repository", "Selection", "Output", "Actions", "Network", "Configuration", "Diagnostics"},
+		apply: func(args []string) error {
+			verifyFlag = true
+			return applyRepositoryArgs(args)
+		},
+	},
+	{
+		name:    "snapshot",
+		args:    "save [owner/repo | URL] | diff FILE [owner/repo | URL]",
+		summary: "Print a JSON snapshot of the tree, or draw what changed since the one in FILE",
+		groups:  []string{"Repository", "Selection", "Output", "Network", "Configuration", "Diagnostics"},
+		apply: func(args []string) error {
+			switch {
+			case len(args) > 0 && args[0] == "save":
+				snapshotFlag = true
+				return applyRepositoryArgs(args[1:])
+			case len(args) > 1 && args[0] == "diff":
+				sinceSnapshotFlag = args[1]
+				return applyRepositoryArgs(args[2:])
+			}
+			return &usageError{msg: "usage: github-tree snapshot [flags] save [owner/repo | URL]\n       github-tree snapshot [flags] diff FILE [owner/repo | URL]"}
+		},
+	},
+	{
+		name:    "config",
+		summary: "Print where settings are read from, and what they hold",
+		groups:  []string{"Configuration"},
+		apply: func(args []string) error {
+			if len(args) > 0 {
+				return &usageError{msg: "usage: github-tree config [--config FILE]"}
+			}
+			return nil
+		},
+	},
+	{
+		name:    "serve",
+		summary: "Answer GET /tree/{owner}/{repo}[/{path}]?ref=&depth=&format=json|text over HTTP on --listen",
+		groups:  []string{"Repository", "Selection", "Network", "Configuration", "Diagnostics"},
+		apply: func(args []string) error {
+			if len(args) > 0 {
+				return &usageError{msg: "usage: github-tree serve [--listen ADDRESS] [flags]"}
+			}
+			return nil
+		},
+	},
+	{
+		name:    "auth",
+		args:    "status [owner/repo | URL]",
+		summary: "Check the token: its kind, scopes and rate limit, and whether it can read the repository if one is given",
+		groups:  []string{"Repository", "Network", "Configuration", "Diagnostics"},
+		apply: func(args []string) error {
+			if len(args) == 0 || args[0] != "status" {
+				return &usageError{msg: "usage: github-tree auth [flags] status [owner/repo | URL]"}
+			}
+			return applyRepositoryArgs(args[1:])
+		},
+	},
+	{
+		name:    "completion",
+		args:    "bash|zsh|fish|powershell",
+		summary: "Print a shell completion script",
+		groups:  []string{},
+	},
+}
+
+// lookupCommand finds a subcommand by name
+func lookupCommand(name string) (command, bool) {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd, true
+		}
+	}
+	return command{}, false
+}
+
+func commandNames() []string {
+	names := make([]string, len(commands))
+	for i, cmd := range commands {
+		names[i] = cmd.name
+	}
+	return names
+}
+
+// applyRepositoryArgs lets a repository argument stand for --url
+func applyRepositoryArgs(args []string) error {
+	switch {
+	case len(args) > 1:
+		return &usageError{msg: fmt.Sprintf("expected one repository argument, got %d: %s", len(args), strings.Join(args, " "))}
+	case len(args) == 1 && urlFlag != "":
+		return &usageError{msg: "give the repository either as an argument or with --url, not both"}
+	case len(args) == 1:
+		urlFlag = repositoryURL(args[0])
+	}
+	return nil
+}
+
+// commandUsage prints the help of one subcommand
+func commandUsage(cmd command) {
+	w := flag.CommandLine.Output()
+	spec := "github-tree " + cmd.name + " [flags]"
+	if cmd.args != "" {
+		spec += " " + cmd.args
+	}
+	fmt.Fprintf(w, "Usage: %s\n\n%s\n", spec, cmd.summary)
+
+	for _, group := range flagGroups {
+		for _, name := range cmd.groups {
+			if name == group.title {
+				printFlagGroup(group.title, group.names)
+			}
+		}
+	}
+}
+
+// printConfig shows the files settings come from and their contents
+func printConfig(inputsPath string) error {
+	files := []struct {
+		label, path string
+	}{
+		{"profile", getProfilePath()},
+		{"inputs", inputsPath},
+	}
+
+	for i, file := range files {
+		if i > 0 {
+			fmt.Fprintln(out)
+		}
+		data, err := os.ReadFile(file.path)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			fmt.Fprintf(out, "%s: %s (not found)\n", file.label, file.path)
+		case err != nil:
+			return fmt.Errorf("failed to read %s: %w", file.path, err)
+		default:
+			fmt.Fprintf(out, "%s: %s\n%s\n", file.label, file.path, strings.TrimSpace(string(data)))
+		}
+	}
+
+	if settings := environmentSettings(); len(settings) > 0 {
+		fmt.Fprintf(out, "\nenvironment:\n%s\n", strings.Join(settings, "\n"))
+	}
+
+	cacheDir, err := getCacheDir()
+	if err == nil {
+		fmt.Fprintf(out, "\ncache: %s\n", cacheDir)
+	}
+
+	// Name the token's source, never the token
+	app, err := resolveApp()
+	if err != nil {
+		return err
+	}
+	if app != nil {
+		fmt.Fprintf(out, "token: minted for GitHub App %d, installation %d\n", app.ID, app.InstallationID)
+		return nil
+	}
+	apiBase := os.Getenv("GITHUB_API_URL")
+	if apiBase == "" || isFlagSet("api-url") {
+		apiBase = apiURLFlag
+	}
+	apiBase, err = normalizeAPIURL(apiBase)
+	if err != nil {
+		return err
+	}
+	_, source, err := resolveToken(providerFlag, apiBase)
+	switch {
+	case err != nil:
+		return err
+	case source == "":
+		fmt.Fprintf(out, "token: none found (checked %s)\n", tokenSearchOrder(providerFlag))
+	default:
+		fmt.Fprintf(out, "token: from %s\n", source)
+	}
+	return nil
+}
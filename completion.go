@@ -0,0 +1,290 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// completionShells are the shells printCompletion writes scripts for
+var completionShells = []string{"bash", "zsh", "fish", "powershell"}
+
+// repoContextFlags pick whose repositories are offered for --repo, and
+// refContextFlags which repository's refs are offered for --ref. The
+// scripts pass those already on the command line to --list-repos and
+// --list-refs.
+var (
+	repoContextFlags = []string{"owner", "api-url", "token-file", "profile", "config"}
+	refContextFlags  = append([]string{"repo", "url", "provider"}, repoContextFlags...)
+)
+
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// spellings returns every way of writing the named flags on the command
+// line, aliases included
+func spellings(names ...string) []string {
+	var words []string
+	for _, name := range names {
+		words = append(words, dashes(name)+name)
+		for _, alias := range aliasesOf(name) {
+			words = append(words, dashes(alias)+alias)
+		}
+	}
+	return words
+}
+
+// canonicalName resolves a flag alias to the flag it stands for
+func canonicalName(name string) string {
+	if target, ok := flagAliases[name]; ok {
+		return target
+	}
+	return name
+}
+
+func printCompletion(args []string) error {
+	usage := "usage: github-tree completion " + strings.Join(completionShells, "|")
+	if len(args) != 1 {
+		return errors.New(usage)
+	}
+
+	switch args[0] {
+	case "bash":
+		printBashCompletion()
+	case "zsh":
+		printZshCompletion()
+	case "fish":
+		printFishCompletion()
+	case "powershell":
+		printPowerShellCompletion()
+	default:
+		return fmt.Errorf("unsupported shell %q; %s", args[0], usage)
+	}
+	return nil
+}
+
+func printBashCompletion() {
+	var words []string
+	flag.VisitAll(func(f *flag.Flag) {
+		words = append(words, dashes(f.Name)+f.Name)
+	})
+
+	w := os.Stdout
+	fmt.Fprintln(w, "# bash completion for github-tree")
+
+	// The flags before the one being completed that pick a repository
+	for _, context := range []struct {
+		name  string
+		flags []string
+	}{{"repo", repoContextFlags}, {"ref", refContextFlags}} {
+		fmt.Fprintf(w, "_github_tree_%s_context() {\n", context.name)
+		fmt.Fprintln(w, "  local i")
+		fmt.Fprintln(w, "  for ((i = 1; i < COMP_CWORD - 1; i++)); do")
+		fmt.Fprintln(w, `    case "${COMP_WORDS[i]}" in`)
+		fmt.Fprintf(w, "      %s) printf '%%s\\n' \"${COMP_WORDS[i]}\" \"${COMP_WORDS[i+1]}\" ;;\n", strings.Join(spellings(context.flags...), "|"))
+		fmt.Fprintln(w, "    esac")
+		fmt.Fprintln(w, "  done")
+		fmt.Fprintln(w, "}")
+	}
+
+	fmt.Fprintln(w, "_github_tree() {")
+	fmt.Fprintln(w, `  local cur="${COMP_WORDS[COMP_CWORD]}" prev="${COMP_WORDS[COMP_CWORD-1]}"`)
+	fmt.Fprintln(w, `  if [ "$COMP_CWORD" -eq 1 ] && [[ "$cur" != -* ]]; then`)
+	fmt.Fprintf(w, "    COMPREPLY=($(compgen -W %q -- \"$cur\")); return\n", strings.Join(commandNames(), " "))
+	fmt.Fprintln(w, "  fi")
+	fmt.Fprintln(w, `  if [ "$prev" = completion ]; then`)
+	fmt.Fprintf(w, "    COMPREPLY=($(compgen -W %q -- \"$cur\")); return\n", strings.Join(completionShells, " "))
+	fmt.Fprintln(w, "  fi")
+	fmt.Fprintln(w, `  case "$prev" in`)
+	fmt.Fprintf(w, "    %s) COMPREPLY=($(compgen -W \"$(github-tree --list-repos --no-save $(_github_tree_repo_context) 2>/dev/null)\" -- \"$cur\")); return ;;\n", strings.Join(spellings("repo"), "|"))
+	fmt.Fprintf(w, "    %s) COMPREPLY=($(compgen -W \"$(github-tree --list-refs --no-save $(_github_tree_ref_context) 2>/dev/null)\" -- \"$cur\")); return ;;\n", strings.Join(spellings("ref"), "|"))
+	for _, name := range sortedKeys(flagChoices) {
+		fmt.Fprintf(w, "    --%s) COMPREPLY=($(compgen -W %q -- \"$cur\")); return ;;\n", name, strings.Join(flagChoices[name], " "))
+	}
+	for _, name := range sortedKeys(fileFlags) {
+		fmt.Fprintf(w, "    --%s) COMPREPLY=($(compgen -f -- \"$cur\")); return ;;\n", name)
+	}
+	fmt.Fprintln(w, "  esac")
+	fmt.Fprintf(w, "  COMPREPLY=($(compgen -W %q -- \"$cur\"))\n", strings.Join(words, " "))
+	fmt.Fprintln(w, "}")
+	fmt.Fprintln(w, "complete -F _github_tree github-tree")
+}
+
+func printZshCompletion() {
+	// _arguments descriptions must not contain its own delimiters
+	escape := strings.NewReplacer("[", `\[`, "]", `\]`, ":", `\:`, "'", `'\''`)
+
+	w := os.Stdout
+	fmt.Fprintln(w, "#compdef github-tree")
+	fmt.Fprintln(w, "# zsh completion for github-tree")
+
+	// The flags before the one being completed that pick a repository, and
+	// the repositories and refs they lead to
+	for _, context := range []struct {
+		name, option string
+		flags        []string
+	}{{"repo", "--list-repos", repoContextFlags}, {"ref", "--list-refs", refContextFlags}} {
+		fmt.Fprintf(w, "_github_tree_%ss() {\n", context.name)
+		fmt.Fprintln(w, "  local i")
+		fmt.Fprintln(w, "  local -a args values")
+		fmt.Fprintln(w, "  for ((i = 2; i < CURRENT - 1; i++)); do")
+		fmt.Fprintln(w, "    case $words[i] in")
+		fmt.Fprintf(w, "      (%s) args+=($words[i] $words[i+1]) ;;\n", strings.Join(spellings(context.flags...), "|"))
+		fmt.Fprintln(w, "    esac")
+		fmt.Fprintln(w, "  done")
+		fmt.Fprintf(w, "  values=(${(f)\"$(github-tree %s --no-save $args 2>/dev/null)\"})\n", context.option)
+		fmt.Fprintln(w, "  compadd -a values")
+		fmt.Fprintln(w, "}")
+	}
+
+	fmt.Fprintln(w, "_github_tree() {")
+	fmt.Fprintln(w, "  _arguments \\")
+	flag.VisitAll(func(f *flag.Flag) {
+		prefix := dashes(f.Name)
+		_, description := flag.UnquoteUsage(f)
+		spec := prefix + f.Name + "[" + escape.Replace(description) + "]"
+
+		// Completion of the flag's value, if it takes one
+		name := canonicalName(f.Name)
+		switch {
+		case isBoolFlag(f):
+		case name == "repo":
+			spec += ":repo:_github_tree_repos"
+		case name == "ref":
+			spec += ":ref:_github_tree_refs"
+		case flagChoices[name] != nil:
+			spec += ":" + name + ":(" + strings.Join(flagChoices[name], " ") + ")"
+		case fileFlags[name]:
+			spec += ":file:_files"
+		default:
+			spec += ":" + name + ": "
+		}
+		fmt.Fprintf(w, "    '%s' \\\n", spec)
+	})
+	fmt.Fprintf(w, "    '1:command:(%s)' \\\n", strings.Join(commandNames(), " "))
+	fmt.Fprintf(w, "    '2:shell:(%s)'\n", strings.Join(completionShells, " "))
+	fmt.Fprintln(w, "}")
+	fmt.Fprintln(w, `_github_tree "$@"`)
+}
+
+func printFishCompletion() {
+	quote := strings.NewReplacer(`\`, `\\`, "'", `\'`)
+
+	w := os.Stdout
+	fmt.Fprintln(w, "# fish completion for github-tree")
+
+	// The flags before the one being completed that pick a repository
+	for _, context := range []struct {
+		name  string
+		flags []string
+	}{{"repo", repoContextFlags}, {"ref", refContextFlags}} {
+		fmt.Fprintf(w, "function __github_tree_%s_context\n", context.name)
+		fmt.Fprintln(w, "    set -l tokens (commandline -opc)")
+		fmt.Fprintln(w, "    for i in (seq 2 (math (count $tokens) - 1))")
+		fmt.Fprintln(w, "        switch $tokens[$i]")
+		fmt.Fprintf(w, "            case %s\n", strings.Join(spellings(context.flags...), " "))
+		fmt.Fprintln(w, "                echo $tokens[$i]")
+		fmt.Fprintln(w, "                echo $tokens[(math $i + 1)]")
+		fmt.Fprintln(w, "        end")
+		fmt.Fprintln(w, "    end")
+		fmt.Fprintln(w, "end")
+	}
+
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "complete -c github-tree -n __fish_use_subcommand -a %s -d '%s'\n", cmd.name, quote.Replace(cmd.summary))
+	}
+	fmt.Fprintf(w, "complete -c github-tree -n '__fish_seen_subcommand_from completion' -a '%s'\n", strings.Join(completionShells, " "))
+	flag.VisitAll(func(f *flag.Flag) {
+		spec := "-l " + f.Name
+		switch {
+		case len(f.Name) == 1:
+			spec = "-s " + f.Name
+		case dashes(f.Name) == "-":
+			spec = "-o " + f.Name
+		}
+		_, description := flag.UnquoteUsage(f)
+		spec += " -d '" + quote.Replace(description) + "'"
+
+		// Completion of the flag's value, if it takes one
+		name := canonicalName(f.Name)
+		switch {
+		case isBoolFlag(f):
+		case name == "repo":
+			spec += " -x -a '(github-tree --list-repos --no-save (__github_tree_repo_context) 2>/dev/null)'"
+		case name == "ref":
+			spec += " -x -a '(github-tree --list-refs --no-save (__github_tree_ref_context) 2>/dev/null)'"
+		case flagChoices[name] != nil:
+			spec += " -x -a '" + strings.Join(flagChoices[name], " ") + "'"
+		case fileFlags[name]:
+			spec += " -r -F"
+		default:
+			spec += " -x"
+		}
+		fmt.Fprintf(w, "complete -c github-tree %s\n", spec)
+	})
+}
+
+func printPowerShellCompletion() {
+	// Single-quoted PowerShell strings only escape their quote, by doubling it
+	list := func(words []string) string {
+		quoted := make([]string, len(words))
+		for i, word := range words {
+			quoted[i] = "'" + strings.ReplaceAll(word, "'", "''") + "'"
+		}
+		return "@(" + strings.Join(quoted, ", ") + ")"
+	}
+
+	var words, files []string
+	flag.VisitAll(func(f *flag.Flag) {
+		words = append(words, dashes(f.Name)+f.Name)
+		if fileFlags[canonicalName(f.Name)] {
+			files = append(files, dashes(f.Name)+f.Name)
+		}
+	})
+
+	w := os.Stdout
+	fmt.Fprintln(w, "# PowerShell completion for github-tree")
+	fmt.Fprintln(w, "Register-ArgumentCompleter -Native -CommandName github-tree -ScriptBlock {")
+	fmt.Fprintln(w, "    param($wordToComplete, $commandAst, $cursorPosition)")
+	fmt.Fprintln(w, "    $words = @($commandAst.CommandElements | Where-Object { $_.Extent.EndOffset -lt $cursorPosition } | ForEach-Object { $_.ToString() })")
+	fmt.Fprintln(w, "    $prev = $words[-1]")
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "    # The flags before the one being completed that pick a repository")
+	fmt.Fprintln(w, "    $context = {")
+	fmt.Fprintln(w, "        param($names)")
+	fmt.Fprintln(w, "        for ($i = 1; $i -lt $words.Count - 1; $i++) {")
+	fmt.Fprintln(w, "            if ($names -ccontains $words[$i]) { $words[$i]; $words[$i + 1] }")
+	fmt.Fprintln(w, "        }")
+	fmt.Fprintln(w, "    }")
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "    $choices = @{")
+	for _, name := range sortedKeys(flagChoices) {
+		fmt.Fprintf(w, "        '--%s' = %s\n", name, list(flagChoices[name]))
+	}
+	fmt.Fprintln(w, "    }")
+	fmt.Fprintf(w, "    if (%s -ccontains $prev) {\n", list(spellings("repo")))
+	fmt.Fprintf(w, "        $ctx = @(& $context %s)\n", list(spellings(repoContextFlags...)))
+	fmt.Fprintln(w, "        $values = @(github-tree --list-repos --no-save $ctx 2>$null)")
+	fmt.Fprintf(w, "    } elseif (%s -ccontains $prev) {\n", list(spellings("ref")))
+	fmt.Fprintf(w, "        $ctx = @(& $context %s)\n", list(spellings(refContextFlags...)))
+	fmt.Fprintln(w, "        $values = @(github-tree --list-refs --no-save $ctx 2>$null)")
+	fmt.Fprintln(w, "    } elseif ($choices.ContainsKey($prev)) {")
+	fmt.Fprintln(w, "        $values = $choices[$prev]")
+	fmt.Fprintf(w, "    } elseif (%s -ccontains $prev) {\n", list(files))
+	fmt.Fprintln(w, "        return")
+	fmt.Fprintln(w, "    } elseif ($prev -eq 'completion') {")
+	fmt.Fprintf(w, "        $values = %s\n", list(completionShells))
+	fmt.Fprintln(w, "    } elseif ($words.Count -eq 1 -and -not $wordToComplete.StartsWith('-')) {")
+	fmt.Fprintf(w, "        $values = %s\n", list(commandNames()))
+	fmt.Fprintln(w, "    } else {")
+	fmt.Fprintf(w, "        $values = %s\n", list(words))
+	fmt.Fprintln(w, "    }")
+	fmt.Fprintln(w, "    $values | Where-Object { $_ -like \"$wordToComplete*\" } | ForEach-Object {")
+	fmt.Fprintln(w, "        [System.Management.Automation.CompletionResult]::new($_, $_, 'ParameterValue', $_)")
+	fmt.Fprintln(w, "    }")
+	fmt.Fprintln(w, "}")
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/sbdtu5498/github-tree/pkg/ghtree"
+)
+
+// duEntry is a file or directory of the --du report
+type duEntry struct {
+	Path  string `json:"path"`
+	Files int    `json:"files,omitempty"`
+	Bytes int64  `json:"bytes"`
+}
+
+// printDU prints the top largest files below roots and the top directories
+// holding the most bytes, counting everything below them, largest first,
+// as tables or as JSON. A top of zero prints them all. Directories the walk
+// didn't list are left out, since their weight is unknown.
+func printDU(roots []*ghtree.Node, top int, asJSON bool) error {
+	var files, dirs []duEntry
+	var totalFiles int
+	var totalBytes int64
+
+	// weigh returns the files and bytes below nodes, and whether all of
+	// them were listed
+	var weigh func(nodes []*ghtree.Node) (int, int64, bool)
+	weigh = func(nodes []*ghtree.Node) (int, int64, bool) {
+		var count int
+		var size int64
+		complete := true
+		for _, node := range nodes {
+			if node.Omitted > 0 {
+				complete = false
+			}
+			switch {
+			case node.Type == "dir" || node.Children != nil:
+				if node.Children == nil {
+					complete = false
+					continue
+				}
+				n, bytes, listed := weigh(node.Children)
+				if listed && node.Omitted == 0 {
+					dirs = append(dirs, duEntry{Path: node.Path, Files: n, Bytes: bytes})
+				}
+				count += n
+				size += bytes
+				complete = complete && listed
+			case node.Type == "file":
+				files = append(files, duEntry{Path: node.Path, Bytes: node.Size})
+				count++
+				size += node.Size
+			}
+		}
+		return count, size, complete
+	}
+	for _, root := range roots {
+		n, bytes, _ := weigh(root.TopLevel())
+		totalFiles += n
+		totalBytes += bytes
+	}
+
+	heaviest := func(entries []duEntry) []duEntry {
+		sort.SliceStable(entries, func(i, j int) bool {
+			if entries[i].Bytes != entries[j].Bytes {
+				return entries[i].Bytes > entries[j].Bytes
+			}
+			return entries[i].Path < entries[j].Path
+		})
+		if top > 0 && len(entries) > top {
+			entries = entries[:top]
+		}
+		return entries
+	}
+	files, dirs = heaviest(files), heaviest(dirs)
+
+	if asJSON {
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(struct {
+			Files       []duEntry `json:"files"`
+			Directories []duEntry `json:"directories"`
+			TotalFiles  int       `json:"totalFiles"`
+			TotalBytes  int64     `json:"totalBytes"`
+		}{files, dirs, totalFiles, totalBytes})
+	}
+
+	share := func(bytes int64) float64 {
+		if totalBytes == 0 {
+			return 0
+		}
+		return float64(bytes) / float64(totalBytes) * 100
+	}
+	w := tabwriter.NewWriter(out, 0, 0, 3, ' ', 0)
+	fmt.Fprintf(w, "FILE\tSIZE\tSHARE\n")
+	for _, file := range files {
+		fmt.Fprintf(w, "%s\t%s\t%.1f%%\n", file.Path, ghtree.HumanizeBytes(file.Bytes), share(file.Bytes))
+	}
+	err := w.Flush()
+	if err != nil {
+		return err
+	}
+
+	if len(dirs) > 0 {
+		fmt.Fprintln(out)
+		w = tabwriter.NewWriter(out, 0, 0, 3, ' ', 0)
+		fmt.Fprintf(w, "DIRECTORY\tFILES\tSIZE\tSHARE\n")
+		for _, dir := range dirs {
+			fmt.Fprintf(w, "%s/\t%d\t%s\t%.1f%%\n", dir.Path, dir.Files, ghtree.HumanizeBytes(dir.Bytes), share(dir.Bytes))
+		}
+		err = w.Flush()
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(out, "\ntotal: %s, %s\n", plural(totalFiles, "file", "files"), ghtree.HumanizeBytes(totalBytes))
+	return nil
+}
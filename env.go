@@ -0,0 +1,75 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// envPrefix starts the environment variable of each flag
+const envPrefix = "GHTREE_"
+
+// envName returns the environment variable setting a flag: GHTREE_ and its
+// name in upper case, with words split by underscores, so --maxDepth is
+// GHTREE_MAX_DEPTH and --no-summary is GHTREE_NO_SUMMARY
+func envName(name string) string {
+	var b strings.Builder
+	b.WriteString(envPrefix)
+	for i, r := range name {
+		switch {
+		case r == '-':
+			b.WriteByte('_')
+		case unicode.IsUpper(r) && i > 0:
+			b.WriteByte('_')
+			b.WriteRune(r)
+		default:
+			b.WriteRune(unicode.ToUpper(r))
+		}
+	}
+	return b.String()
+}
+
+// applyEnvironment sets the flags not given on the command line from their
+// environment variables. They count as given, so they win over the profile
+// and the inputs file in turn. Repeatable flags take comma-separated lists.
+func applyEnvironment() error {
+	for _, name := range longFlagNames() {
+		value, ok := os.LookupEnv(envName(name))
+		if !ok || isFlagSet(name) {
+			continue
+		}
+
+		values := []string{value}
+		switch flag.Lookup(name).Value.(type) {
+		case *stringList, *repoList:
+			values = strings.Split(value, ",")
+		}
+		for _, v := range values {
+			err := flag.Set(name, strings.TrimSpace(v))
+			if err != nil {
+				return fmt.Errorf("invalid value for %s: %w", envName(name), err)
+			}
+		}
+		explicitFlags[name] = true
+	}
+	return nil
+}
+
+// environmentSettings lists the GHTREE_ variables in effect as NAME=value,
+// hiding the token's value
+func environmentSettings() []string {
+	var settings []string
+	for _, name := range longFlagNames() {
+		value, ok := os.LookupEnv(envName(name))
+		if !ok {
+			continue
+		}
+		if name == "token" {
+			value = "(hidden)"
+		}
+		settings = append(settings, envName(name)+"="+value)
+	}
+	return settings
+}
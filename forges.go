@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/sbdtu5498/github-tree/pkg/ghtree"
+)
+
+// forge describes a code forge other than GitHub that --provider selects
+type forge struct {
+	provider func() ghtree.Provider
+	apiURL   string
+	host     string
+	tokenEnv string
+}
+
+// forges are the providers besides GitHub, keyed by their --provider name
+var forges = map[string]forge{
+	"gitlab":    {ghtree.GitLabProvider, "https://gitlab.com/api/v4", "gitlab.com", "GITLAB_TOKEN"},
+	"bitbucket": {ghtree.BitbucketProvider, "https://api.bitbucket.org/2.0", "bitbucket.org", "BITBUCKET_TOKEN"},
+}
+
+// checkProvider rejects provider names --provider doesn't know
+func checkProvider(provider string) error {
+	if _, ok := forges[provider]; ok || provider == "github" {
+		return nil
+	}
+	return fmt.Errorf("unknown provider %q; use github, gitlab or bitbucket", provider)
+}
+
+// parseRepositoryURL splits a repository URL on any supported forge. The
+// URL is read as one of provider's, or when provider is empty, as one of
+// the forge its host belongs to, with GitHub for any other host. It also
+// returns the provider it settled on.
+func parseRepositoryURL(raw, provider string) (forgeName, owner, repo, ref, path string, err error) {
+	// Treat SSH clone URLs like their HTTPS equivalent
+	if strings.HasPrefix(raw, "git@") {
+		raw = "https://" + strings.Replace(strings.TrimPrefix(raw, "git@"), ":", "/", 1)
+	}
+
+	if provider == "" {
+		provider = "github"
+		if u, err := url.Parse(raw); err == nil {
+			host := strings.TrimPrefix(u.Host, "www.")
+			for name, f := range forges {
+				if host == f.host {
+					provider = name
+				}
+			}
+		}
+	}
+
+	switch provider {
+	case "gitlab":
+		owner, repo, ref, path, err = parseGitLabURL(raw)
+	case "bitbucket":
+		owner, repo, ref, path, err = parseBitbucketURL(raw)
+	default:
+		owner, repo, ref, path, err = parseGitHubURL(raw)
+	}
+	return provider, owner, repo, ref, path, err
+}
+
+// parseGitLabURL splits a GitLab URL. Projects can sit in nested groups,
+// so the owner is every segment before the project's; browser URLs carry
+// the ref and path after /-/tree/ or /-/blob/.
+func parseGitLabURL(raw string) (owner, repo, ref, path string, err error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", "", "", "", fmt.Errorf("invalid URL %q: %w", raw, err)
+	}
+	if u.Host == "" {
+		return "", "", "", "", fmt.Errorf("invalid URL %q: expected https://gitlab.com/group/project", raw)
+	}
+
+	segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+	project := segments
+	for i, segment := range segments {
+		if segment == "-" {
+			project = segments[:i]
+			rest := segments[i+1:]
+			if len(rest) > 1 && (rest[0] == "tree" || rest[0] == "blob") {
+				ref = rest[1]
+				path = strings.Join(rest[2:], "/")
+			}
+			break
+		}
+	}
+	if len(project) < 2 || project[0] == "" {
+		return "", "", "", "", fmt.Errorf("URL %q does not name a project", raw)
+	}
+	owner = strings.Join(project[:len(project)-1], "/")
+	repo = strings.TrimSuffix(project[len(project)-1], ".git")
+	return owner, repo, ref, path, nil
+}
+
+// parseBitbucketURL splits a Bitbucket URL into workspace and repository;
+// browser URLs carry the ref and path after /src/
+func parseBitbucketURL(raw string) (owner, repo, ref, path string, err error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", "", "", "", fmt.Errorf("invalid URL %q: %w", raw, err)
+	}
+	if u.Host == "" {
+		return "", "", "", "", fmt.Errorf("invalid URL %q: expected https://bitbucket.org/workspace/repo", raw)
+	}
+
+	segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(segments) < 2 || segments[0] == "" || segments[1] == "" {
+		return "", "", "", "", fmt.Errorf("URL %q does not name a repository", raw)
+	}
+	owner = segments[0]
+	repo = strings.TrimSuffix(segments[1], ".git")
+	if len(segments) > 3 && segments[2] == "src" {
+		ref = segments[3]
+		path = strings.Join(segments[4:], "/")
+	}
+	return owner, repo, ref, path, nil
+}
+
+// githubOnly names the options that need GitHub's own APIs, if any of them
+// is in use
+func githubOnly() string {
+	for _, option := range []struct {
+		name string
+		set  bool
+	}{
+		{"--gist", gistFlag != ""},
+		{"--all-repos", allReposFlag},
+		{"--graphql", graphqlFlag},
+		{"--recursive-api", recursiveAPIFlag},
+		{"--base", baseFlag != ""},
+		{"--diff-repo", diffRepoFlag != ""},
+		{"--verify", verifyFlag},
+		{"--snapshot", snapshotFlag},
+		{"--since-snapshot", sinceSnapshotFlag != ""},
+		{"--list-refs", listRefsFlag},
+		{"--list-repos", listReposFlag},
+		{"--preflight", preflightFlag},
+		{"--dry-run", dryRunFlag},
+		{"--sort commit-date", sortFlag == "commit-date"},
+		{"--last-commit", lastCommitFlag},
+		{"--count-collapsed", collapseCountFlag},
+		{"--follow-submodules", followSubsFlag},
+		{"--offline", offlineFlag},
+		{"--format raw-urls", formatFlag == "raw-urls"},
+		{"--verify-urls", verifyURLsFlag},
+		{"--display-name", displayNameFlag != ""},
+	} {
+		if option.set {
+			return option.name
+		}
+	}
+	return ""
+}
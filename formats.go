@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/sbdtu5498/github-tree/pkg/ghtree"
+)
+
+// registerFormats registers the command line's formats with the library,
+// replacing its renderers where the names are shared, so that render finds
+// every format in one place. They draw what this run listed with client,
+// so they are registered once it has.
+func registerFormats(ctx context.Context, client *ghtree.Client, current Inputs, v flagValues) {
+	formats := map[string]func(roots []*ghtree.Node) error{
+		"text":     printTextTree,
+		"json":     printTreeJSON,
+		"jsonl":    printJSONLRoots,
+		"paths":    printPathList,
+		"markdown": printMarkdown,
+		"csv": func(roots []*ghtree.Node) error {
+			return printTable(roots, ',')
+		},
+		"tsv": func(roots []*ghtree.Node) error {
+			return printTable(roots, '\t')
+		},
+		"raw-urls": func(roots []*ghtree.Node) error {
+			return printRawURLs(ctx, client, current.Ref, roots)
+		},
+
+		// --stats, --du, --template and --search replace the format
+		"stats": func(roots []*ghtree.Node) error {
+			return printStats(roots, groupByFlag, formatFlag == "json")
+		},
+		"du": func(roots []*ghtree.Node) error {
+			return printDU(roots, topFlag, formatFlag == "json")
+		},
+		"template": func(roots []*ghtree.Node) error {
+			return printTemplate(roots, v.entryTemplate)
+		},
+		"search": func(roots []*ghtree.Node) error {
+			printSearch(roots, v.searchMatch)
+			return nil
+		},
+	}
+	for name, printTrees := range formats {
+		ghtree.RegisterRenderer(name, printer(printTrees))
+	}
+}
+
+// printer adapts a function printing the listed trees to out, together, to
+// the library's renderers; render hands them out as w
+func printer(printTrees func(roots []*ghtree.Node) error) ghtree.Renderer {
+	return ghtree.TreesRendererFunc(func(w io.Writer, roots []*ghtree.Node, opts ghtree.RenderOptions) error {
+		return printTrees(roots)
+	})
+}
+
+// printTextTree draws the text tree, unless it was drawn as it was listed,
+// and the summary below it
+func printTextTree(roots []*ghtree.Node) error {
+	switch {
+	case streamedTree:
+		// An empty tree still gets its sentinel
+		if len(roots[0].TopLevel()) == 0 && roots[0].Omitted == 0 && emptyMarkerFlag {
+			fmt.Fprintln(out, "(empty)")
+		}
+	case !streamPaths:
+		err := printText(roots)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !noSummaryFlag && verbosity >= levelNormal {
+		printSummary(roots)
+	}
+	return nil
+}
+
+// printJSONLRoots ends the JSON Lines output: entries below the roots were
+// written as they were found, and a root that is a single file is the whole
+// tree
+func printJSONLRoots(roots []*ghtree.Node) error {
+	for _, root := range roots {
+		if root.Type != "dir" {
+			err := writeJSONLEntry(root, 0)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// printPathList prints the paths, unless they were printed as they were found
+func printPathList(roots []*ghtree.Node) error {
+	if streamPaths {
+		return nil
+	}
+	return printPaths(roots)
+}
@@ -1,258 +1,3788 @@
 package main
 
 import (
+	"archive/zip"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"math"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/exec"
+	"os/signal"
+	pathpkg "path"
 	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"text/template"
+	"time"
+
+	"github.com/sbdtu5498/github-tree/pkg/ghtree"
+)
+
+var (
+	ownerFlag         string
+	repoFlag          string
+	pathFlag          pathList
+	refFlag           string
+	excludeFlag       stringList
+	depthOverrideFlag stringList
+	urlFlag           string
+	formatFlag        string
+	waitFlag          bool
+	timeoutFlag       time.Duration
+	deadlineFlag      time.Duration
+	concurrencyFlag   int
+	parallelUntilFlag int
+	sizesFlag         bool
+	apiURLFlag        string
+	asciiFlag         bool
+	charsetFlag       string
+	maxDepthFlag      int
+	emptyMarkerFlag   bool
+	rpsFlag           float64
+	sortFlag          string
+	reverseFlag       bool
+	dirsFirstFlag     bool
+	widthFlag         int
+	widthModeFlag     string
+	listRefsFlag      bool
+	listReposFlag     bool
+	maxPathDepthFlag  int
+	zipFlag           string
+	histogramFlag     bool
+	jsonStatsFlag     bool
+	showRateLimitFlag bool
+	interactiveFlag   bool
+	baseFlag          string
+	headFlag          string
+	diffRepoFlag      string
+	noTrailingNLFlag  bool
+	nullFlag          bool
+	preflightFlag     bool
+	printCurlFlag     bool
+	printCurlUnsafe   bool
+	outputFileFlag    string
+	dirsOnlyFlag      bool
+	noSummaryFlag     bool
+	retriesFlag       int
+	retryMaxWaitFlag  time.Duration
+	maxWaitFlag       time.Duration
+	listenFlag        string
+	shareTokenFlag    bool
+	quietFlag         bool
+	verboseFlag       bool
+	vFlag             bool
+	vvFlag            bool
+	logFormatFlag     string
+	noProgressFlag    bool
+	cacheTTLFlag      time.Duration
+	noCacheFlag       bool
+	offlineFlag       bool
+	colorFlag         string
+	noColorFlag       bool
+	configFlag        string
+	saveFlag          bool
+	noSaveFlag        bool
+	noPersistFlag     bool
+	profileFlag       string
+	recursiveAPIFlag  bool
+	graphqlFlag       bool
+	includeExtFlag    stringList
+	minSizeFlag       string
+	maxSizeFlag       string
+	includeFlag       stringList
+	versionFlag       bool
+	downloadFlag      string
+	archiveFlag       string
+	previewFlag       string
+	searchFlag        string
+	regexFlag         bool
+	typeFlag          string
+	ignoreFileFlag    string
+	noIgnoreFlag      bool
+	ownersFlag        bool
+	lastCommitFlag    bool
+	relativeTimeFlag  bool
+	absoluteTimeFlag  bool
+	showSHAFlag       bool
+	traversalFlag     string
+	collapseCountFlag bool
+	followSubsFlag    bool
+	hyperlinksFlag    bool
+	iconsFlag         string
+	lfsFlag           bool
+	overwriteFlag     bool
+	tokenFlag         string
+	tokenFileFlag     string
+	appIDFlag         int64
+	appInstallFlag    int64
+	appKeyFileFlag    string
+	caCertFlag        string
+	insecureFlag      bool
+	dryRunFlag        bool
+	statsFlag         bool
+	groupByFlag       string
+	duFlag            bool
+	topFlag           int
+	markdownStyleFlag string
+	verifyURLsFlag    bool
+	displayNameFlag   string
+	maxEntriesFlag    int
+	templateFlag      string
+	gistFlag          string
+	providerFlag      string
+	localFlag         string
+	verifyFlag        bool
+	contentsFlag      bool
+	watchFlag         bool
+	snapshotFlag      bool
+	sinceSnapshotFlag string
+	intervalFlag      time.Duration
+	repoArgs          repoList
+	reposFileFlag     string
+	parallelFlag      int
+	allReposFlag      bool
+	repoFilterFlag    string
+	visibilityFlag    string
+	archivedFlag      string
+)
+
+// Build information, set by release builds with
+// -ldflags "-X main.version=... -X main.commit=... -X main.date=..."
+var (
+	version = "dev"
+	commit  = ""
+	date    = ""
+)
+
+// out receives all rendered output
+var out io.Writer = os.Stdout
+
+// flagAliases maps each alias to the flag it stands for
+var flagAliases = map[string]string{
+	"O":      "owner",
+	"R":      "repo",
+	"P":      "path",
+	"M":      "maxDepth",
+	"b":      "ref",
+	"r":      "ref",
+	"branch": "ref",
+	"org":    "owner",
+	"o":      "output-file",
+	"output": "output-file",
+
+	"no-report": "no-summary",
+	"ext":       "include-ext",
+	"0":         "null",
+}
+
+// explicitFlags records the flags given on the command line
+var explicitFlags = map[string]bool{}
+
+// zipWriter receives one listing per fetched directory when --zip is set
+var zipWriter *zip.Writer
+
+// zipMu serializes writes to zipWriter from concurrent listings
+var zipMu sync.Mutex
+
+// jsonlMu serializes the lines --format jsonl writes from concurrent listings
+var jsonlMu sync.Mutex
+
+// pathList holds one or more repository paths. It is a repeatable flag and
+// is stored in the inputs file as a string when it holds a single path.
+type pathList []string
+
+func (p *pathList) String() string {
+	if p == nil {
+		return ""
+	}
+	return strings.Join(*p, ",")
+}
+
+func (p *pathList) Set(value string) error {
+	// Accept comma-separated paths as well as repeated flags
+	*p = append(*p, strings.Split(value, ",")...)
+	return nil
+}
+
+func (p pathList) MarshalJSON() ([]byte, error) {
+	if len(p) == 0 {
+		return json.Marshal("")
+	}
+	if len(p) == 1 {
+		return json.Marshal(p[0])
+	}
+	return json.Marshal([]string(p))
+}
+
+func (p *pathList) UnmarshalJSON(data []byte) error {
+	// Accept the original single-string form
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*p = pathList{single}
+		return nil
+	}
+
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*p = list
+	return nil
+}
+
+// Inputs holds the values persisted in the inputs file
+type Inputs struct {
+	Owner    string   `json:"owner"`
+	Repo     string   `json:"repo"`
+	Path     pathList `json:"path"`
+	Ref      string   `json:"ref,omitempty"`
+	MaxDepth int      `json:"maxDepth"`
+	Exclude  []string `json:"exclude,omitempty"`
+	APIURL   string   `json:"apiUrl,omitempty"`
+	Provider string   `json:"provider,omitempty"`
+}
+
+// stringList is a repeatable string flag
+type stringList []string
+
+func (l *stringList) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+func init() {
+	flag.StringVar(&ownerFlag, "O", "", "Repository owner")
+	flag.StringVar(&ownerFlag, "owner", "", "Repository owner")
+	flag.StringVar(&ownerFlag, "org", "", "Alias for --owner")
+
+	flag.Var(&repoArgs, "R", "Repository name, or owner/name (repeatable to draw several)")
+	flag.Var(&repoArgs, "repo", "Repository name, or owner/name (repeatable to draw several)")
+	flag.StringVar(&reposFileFlag, "repos-file", "", "Draw the repositories listed in this file, one owner/name per line, as with repeated --repo")
+	flag.IntVar(&parallelFlag, "parallel", 1, "Number of repositories to list at a time when drawing several")
+	flag.BoolVar(&allReposFlag, "all-repos", false, "Draw every repository of the --owner organization or user, one level deep unless --maxDepth is given")
+	flag.StringVar(&repoFilterFlag, "repo-filter", "", "With --all-repos, only draw repositories whose names match this glob")
+	flag.StringVar(&visibilityFlag, "visibility", "all", "With --all-repos, only draw repositories of this visibility: all, public, private or internal")
+	flag.StringVar(&archivedFlag, "archived", "exclude", "With --all-repos, whether to exclude, include or only draw archived repositories")
+
+	flag.Var(&pathFlag, "P", "Path within the repository (repeatable or comma-separated)")
+	flag.Var(&pathFlag, "path", "Path within the repository (repeatable or comma-separated)")
+
+	flag.StringVar(&refFlag, "b", "", "Branch, tag, or commit to list (default branch if empty)")
+	flag.StringVar(&refFlag, "r", "", "Branch, tag, or commit to list (default branch if empty)")
+	flag.StringVar(&refFlag, "ref", "", "Branch, tag, or commit to list (default branch if empty)")
+	flag.StringVar(&refFlag, "branch", "", "Alias for --ref")
+
+	flag.StringVar(&apiURLFlag, "api-url", ghtree.DefaultAPIURL, "API base URL, e.g. https://github.example.com/api/v3 (or set GITHUB_API_URL), or a self-hosted GitLab's https://gitlab.example.com/api/v4")
+
+	flag.StringVar(&configFlag, "config", "", "Inputs file to read and update (default inputs.json in the user config directory)")
+
+	flag.BoolVar(&saveFlag, "save", false, "Save this run's repository, path, ref, depth and excludes to the inputs file")
+	flag.BoolVar(&noSaveFlag, "no-save", false, "Never write the inputs file, even if the profile sets save")
+	flag.BoolVar(&noPersistFlag, "no-persist", false, "Neither read nor write the inputs file (the default when --owner and --repo are both given)")
+	flag.StringVar(&profileFlag, "profile", "", "Named profile from the profile file to apply over its defaults")
+
+	flag.StringVar(&localFlag, "local", "", "Draw this local directory instead of a repository, with the same filters and formats")
+	flag.StringVar(&gistFlag, "gist", "", "List the files of this gist instead of a repository; --ref picks a revision")
+	flag.StringVar(&urlFlag, "url", "", "URL of a repository or directory on GitHub, GitLab or Bitbucket; overrides --owner and --repo, and --path and --ref if it names a ref")
+	flag.StringVar(&providerFlag, "provider", "github", "Forge hosting the repository (github, gitlab, bitbucket); inferred from --url")
+
+	flag.BoolVar(&asciiFlag, "ascii", false, "Draw the tree with plain ASCII instead of box-drawing characters (same as --charset ascii)")
+	flag.StringVar(&charsetFlag, "charset", "auto", "Characters to draw the tree with (auto, unicode, ascii)")
+
+	flag.BoolVar(&sizesFlag, "sizes", false, "Show file sizes next to their names, and directory totals when fully listed")
+
+	flag.StringVar(&colorFlag, "color", "auto", "Color entries by type (auto, always, never); LS_COLORS adjusts the colors")
+	flag.BoolVar(&noColorFlag, "no-color", false, "Same as --color never")
+
+	flag.BoolVar(&dirsOnlyFlag, "dirs-only", false, "List only directories")
+
+	flag.StringVar(&formatFlag, "format", "text", "Output format (text, json, jsonl, yaml, csv, tsv, paths, markdown, html, dot, mermaid, raw-urls)")
+	flag.StringVar(&markdownStyleFlag, "markdown-style", "list", "How --format markdown draws the tree (list, code)")
+	flag.BoolVar(&verifyURLsFlag, "verify-urls", false, "With --format markdown or raw-urls, check that every file's download URL resolves without a token, and mark broken ones")
+	flag.StringVar(&templateFlag, "template", "", `Print each entry through this Go text/template instead of drawing the tree, e.g. '{{.Path}}\t{{.Size}}'`)
+	flag.StringVar(&outputFileFlag, "o", "", "Write the output to this file instead of stdout")
+	flag.StringVar(&outputFileFlag, "output", "", "Write the output to this file instead of stdout")
+	flag.StringVar(&outputFileFlag, "output-file", "", "Write the output to this file instead of stdout")
+
+	flag.Var(&includeExtFlag, "include-ext", "Show only files with this extension, e.g. go or .md (repeatable, comma-separated)")
+	flag.Var(&includeExtFlag, "ext", "Alias for --include-ext")
+	flag.StringVar(&minSizeFlag, "min-size", "", "Show only files of at least this size, e.g. 10k or 5M, pruning directories left empty")
+	flag.StringVar(&maxSizeFlag, "max-size", "", "Show only files of at most this size, e.g. 10k or 5M, pruning directories left empty")
+
+	flag.Var(&excludeFlag, "exclude", "Glob pattern of entries to skip, matched against names and paths (repeatable)")
+	flag.Var(&includeFlag, "include", "Glob pattern of files to show, matched against names and paths (repeatable)")
+
+	flag.IntVar(&maxDepthFlag, "M", 1, "Maximum depth for fetching content (0 or -1 for no limit)")
+	flag.IntVar(&maxDepthFlag, "maxDepth", 1, "Maximum depth for fetching content (0 or -1 for no limit)")
+	flag.Var(&depthOverrideFlag, "depth-override", "PATTERN=DEPTH: use this --maxDepth for the directories matching the glob, e.g. 'src/**=4' (repeatable)")
+
+	flag.BoolVar(&emptyMarkerFlag, "empty-marker", false, "Print (empty) when the path renders no entries")
+
+	flag.Float64Var(&rpsFlag, "rps", 0, "Maximum API requests per second (0 means unlimited)")
+
+	flag.StringVar(&sortFlag, "sort", "type", "Sort entries within each directory (type, name, size, ext, commit-date, none)")
+	flag.BoolVar(&collapseCountFlag, "count-collapsed", false, "Count the entries of directories beyond --maxDepth with one extra request, instead of marking them with an ellipsis")
+	flag.BoolVar(&followSubsFlag, "follow-submodules", false, "List submodules on the same GitHub host too, as of their pinned commits, within the depth limit")
+	flag.StringVar(&traversalFlag, "traversal", "dfs", "Walk depth first (dfs), or level by level (bfs), printing paths as each level is listed instead of the text tree")
+	flag.BoolVar(&dirsFirstFlag, "dirs-first", false, "List directories before files under any --sort")
+	flag.BoolVar(&reverseFlag, "reverse", false, "Reverse the sort order")
+
+	flag.IntVar(&widthFlag, "width", 0, "Maximum output width in columns (0 means no limit)")
+	flag.StringVar(&widthModeFlag, "width-mode", "wrap", "How to fit names into --width (wrap, truncate)")
+
+	flag.BoolVar(&listRefsFlag, "list-refs", false, "List the repository's branches and tags and exit")
+	flag.BoolVar(&listReposFlag, "list-repos", false, "List the repositories of --owner, or those the token's user can see, and exit")
+
+	flag.IntVar(&maxEntriesFlag, "max-entries", 0, "Show at most this many entries of each directory, then how many more there are (0 means no limit)")
+
+	flag.IntVar(&maxPathDepthFlag, "max-path-depth", 100, "Hard recursion limit guarding against runaway traversal")
+
+	flag.StringVar(&downloadFlag, "download", "", "Download the listed files into this directory, mirroring the tree")
+	flag.BoolVar(&overwriteFlag, "overwrite", false, "Replace files that --download finds already present")
+
+	flag.StringVar(&ignoreFileFlag, "ignore-file", "", "Leave out what this .gitignore-style file names (default .treeignore in the current directory, if any)")
+	flag.BoolVar(&noIgnoreFlag, "no-ignore", false, "Don't apply the .treeignore files of the repository or the current directory")
+	flag.StringVar(&searchFlag, "search", "", "Print only the paths whose name matches this glob, or whose path does if it holds a slash")
+	flag.BoolVar(&regexFlag, "regex", false, "Match --search as a regular expression against the whole path")
+	flag.StringVar(&typeFlag, "type", "", "With --search or --format paths, print only entries of this type (file, dir, symlink or submodule)")
+	flag.BoolVar(&lfsFlag, "lfs", false, "Mark files stored in Git LFS and show the size of their objects instead of their pointers")
+	flag.BoolVar(&lastCommitFlag, "last-commit", false, "Label entries with the date and author of their last commit")
+	flag.BoolVar(&relativeTimeFlag, "relative-time", false, "With --last-commit, show how long ago each commit was, such as 3 days ago")
+	flag.BoolVar(&absoluteTimeFlag, "absolute-time", false, "With --last-commit, show commit dates (the default; overrides --relative-time)")
+	flag.BoolVar(&showSHAFlag, "show-sha", false, "Label entries with their abbreviated blob or tree SHA; json, jsonl, yaml, csv and tsv carry it in full")
+	flag.StringVar(&iconsFlag, "icons", "none", "Prefix entries with icons by file type (nerd, emoji, none); nerd needs a Nerd Font")
+	flag.BoolVar(&hyperlinksFlag, "hyperlinks", false, "Make entries links to their pages on GitHub, which terminals supporting OSC 8 let you click")
+	flag.StringVar(&displayNameFlag, "display-name", "", "Show this owner/name instead of the listed repository in page titles, links and raw URLs")
+	flag.BoolVar(&ownersFlag, "owners", false, "Label entries with their owners from the CODEOWNERS file where ownership changes")
+	flag.StringVar(&previewFlag, "preview", "", "Show the first N lines of each text file below its entry, or N bytes with a b suffix (e.g. 5 or 200b)")
+	flag.StringVar(&archiveFlag, "archive", "", "Download the listed files into this .tar.gz, .tar or .zip archive")
+	flag.StringVar(&zipFlag, "zip", "", "Also write each directory's listing into this zip archive")
+
+	flag.BoolVar(&jsonStatsFlag, "json-stats", false, "Add the directory and file counts and total size beside the tree, as stats, with --format json")
+	flag.BoolVar(&histogramFlag, "depth-histogram", false, "Print directory and file counts per depth level, as depthHistogram beside the tree with --format json")
+
+	flag.BoolVar(&noSummaryFlag, "no-summary", false, "Omit the directory, file and size totals after the tree")
+	flag.BoolVar(&noSummaryFlag, "no-report", false, "Alias for --no-summary")
+
+	flag.BoolVar(&noTrailingNLFlag, "no-trailing-newline", false, "Omit the newline after the last line of output")
+	flag.BoolVar(&nullFlag, "null", false, "End each path of --format paths and --search with a NUL instead of a newline, as xargs -0 expects")
+	flag.BoolVar(&nullFlag, "0", false, "Alias for --null")
+
+	flag.StringVar(&tokenFlag, "token", "", "Access token to authenticate with; other users can see it in the process list, so prefer GITHUB_TOKEN")
+	flag.StringVar(&tokenFileFlag, "token-file", "", "Read the access token from this file instead of the environment")
+	flag.Int64Var(&appIDFlag, "app-id", 0, "Authenticate as this GitHub App instead of with a token (or set GITHUB_APP_ID)")
+	flag.Int64Var(&appInstallFlag, "app-installation-id", 0, "Installation of the GitHub App to act as (or set GITHUB_APP_INSTALLATION_ID)")
+	flag.StringVar(&appKeyFileFlag, "app-key-file", "", "PEM private key of the GitHub App (or set GITHUB_APP_PRIVATE_KEY to the key itself)")
+
+	flag.BoolVar(&statsFlag, "stats", false, "Print file counts and sizes by extension instead of the tree")
+	flag.StringVar(&groupByFlag, "group-by", "ext", "What --stats groups files by (ext, language)")
+	flag.BoolVar(&duFlag, "du", false, "Print the largest files and the heaviest directories instead of the tree")
+	flag.IntVar(&topFlag, "top", 10, "How many files and directories --du prints (0 for all)")
+
+	flag.BoolVar(&dryRunFlag, "dry-run", false, "Print the API requests the walk would send, and how many the rate limit has left, without walking")
+
+	flag.BoolVar(&preflightFlag, "preflight", false, "Check that the token can read the repository before walking it")
+
+	flag.BoolVar(&graphqlFlag, "graphql", false, "List directories through the GraphQL API, three levels per request (needs a token)")
+	flag.BoolVar(&recursiveAPIFlag, "recursive-api", false, "Fetch the whole tree with one git trees API request instead of one request per directory (default for unlimited depth; =false to opt out)")
+
+	flag.IntVar(&concurrencyFlag, "concurrency", 4, "Maximum number of simultaneous API requests")
+	flag.IntVar(&parallelUntilFlag, "parallel-until", 0, "Walk directories concurrently only down to this depth, and one at a time below it (0 means at every depth)")
+
+	flag.DurationVar(&cacheTTLFlag, "cache-ttl", 0, "Reuse directory listings cached on disk for this long (0 disables the cache)")
+	flag.BoolVar(&noCacheFlag, "no-cache", false, "Bypass the listing cache for this run")
+	flag.BoolVar(&offlineFlag, "offline", false, "Draw the tree stored by the last walk of the whole repository at --ref, without any request")
+
+	flag.StringVar(&caCertFlag, "ca-cert", "", "Also trust the CA certificates in this PEM file, e.g. a corporate proxy's")
+	flag.BoolVar(&insecureFlag, "insecure", false, "Skip TLS certificate verification (unsafe; prefer --ca-cert)")
+
+	flag.DurationVar(&timeoutFlag, "timeout", 30*time.Second, "Timeout for each API request")
+	flag.DurationVar(&deadlineFlag, "deadline", 0, "Stop the whole run after this long and show what was listed (0 means no limit)")
+
+	flag.IntVar(&retriesFlag, "retries", 3, "Times to retry a request after a network or server error")
+	flag.DurationVar(&retryMaxWaitFlag, "retry-max-wait", 30*time.Second, "Longest wait between retries, which otherwise doubles each time (0 means no limit)")
+
+	flag.StringVar(&listenFlag, "listen", "127.0.0.1:8080", "Address the serve command listens on")
+	flag.BoolVar(&shareTokenFlag, "share-token", false, "Let serve answer requests without a token of their own with its token")
+
+	flag.BoolVar(&waitFlag, "wait-on-rate-limit", false, "Sleep until the rate limit resets instead of aborting")
+	flag.DurationVar(&maxWaitFlag, "max-wait", time.Hour, "Fail instead of sleeping when GitHub asks to wait longer than this, with Retry-After or a rate limit reset (0 means no limit)")
+	flag.StringVar(&baseFlag, "base", "", "Show what changed since this ref instead of the whole tree")
+	flag.BoolVar(&verifyFlag, "verify", false, "Compare the tree with the --local checkout instead of drawing it")
+	flag.BoolVar(&contentsFlag, "contents", false, "With --verify, also compare file contents by git blob SHA")
+	flag.BoolVar(&snapshotFlag, "snapshot", false, "Print a JSON snapshot of the tree to compare with later instead of drawing it")
+	flag.StringVar(&sinceSnapshotFlag, "since-snapshot", "", "Show what changed since the snapshot in this file instead of the whole tree")
+	flag.BoolVar(&watchFlag, "watch", false, "Keep fetching the tree and print the entries added, removed or renamed")
+	flag.DurationVar(&intervalFlag, "interval", 5*time.Minute, "How often --watch fetches the tree")
+	flag.StringVar(&headFlag, "head", "", "Ref to compare with --base (default --ref, or the default branch)")
+	flag.StringVar(&diffRepoFlag, "diff-repo", "", "Compare the tree with the same path of this other GitHub repository (owner/repo or URL, at the ref it names or its default branch)")
+	flag.BoolVar(&interactiveFlag, "interactive", false, "Browse the tree interactively, listing directories as they are expanded")
+	flag.BoolVar(&showRateLimitFlag, "show-rate-limit", false, "Print the remaining API quota to stderr when done")
+
+	flag.BoolVar(&versionFlag, "version", false, "Print version information and exit")
+
+	flag.BoolVar(&quietFlag, "quiet", false, "Suppress the summary and warnings; only errors reach stderr")
+	flag.BoolVar(&verboseFlag, "verbose", false, "Log each API request and directory listing to stderr (same as -vv)")
+	flag.BoolVar(&vFlag, "v", false, "Log each directory listing and cache hit to stderr")
+	flag.BoolVar(&vvFlag, "vv", false, "Also log each API request and its response to stderr")
+	flag.StringVar(&logFormatFlag, "log-format", "text", "Format of the diagnostics on stderr (text, json)")
+	flag.BoolVar(&noProgressFlag, "no-progress", false, "Don't show the live progress line on stderr while walking")
+
+	flag.BoolVar(&printCurlFlag, "print-curl", false, "Print an equivalent curl command for each request to stderr")
+	flag.BoolVar(&printCurlUnsafe, "print-curl-unsafe", false, "Like --print-curl, but include the access token")
+}
+
+// Exit statuses by failure class; the flag package exits with exitUsage on
+// its own for unknown flags and bad values
+const (
+	exitFailure     = 1
+	exitUsage       = 2
+	exitNotFound    = 3
+	exitAuth        = 4
+	exitRateLimited = 5
+	exitNetwork     = 6
+	exitStopped     = 130
 )
 
+// errorCodes name the exit statuses in the error objects of JSON output
+var errorCodes = map[int]string{
+	exitFailure:     "failed",
+	exitUsage:       "usage",
+	exitNotFound:    "not_found",
+	exitAuth:        "unauthorized",
+	exitRateLimited: "rate_limited",
+	exitNetwork:     "network",
+	exitStopped:     "stopped",
+}
+
+func main() {
+	err := run()
+	if err != nil {
+		code := exitCode(err)
+		if formatFlag == "json" || formatFlag == "jsonl" {
+			writeErrorJSON(err, code)
+		} else {
+			writeLog("error", err.Error(), map[string]interface{}{"exit": code})
+		}
+		os.Exit(code)
+	}
+}
+
+// errorReport is the JSON object describing a failure, for the JSON output
+// formats and for serve
+type errorReport struct {
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+	Exit       int    `json:"exit"`
+	Status     int    `json:"status,omitempty"`
+	RetryAfter int    `json:"retry_after,omitempty"`
+}
+
+// newErrorReport describes err, which exits with code, so that scripts can
+// branch on its code instead of parsing the message
+func newErrorReport(err error, code int) errorReport {
+	report := errorReport{Code: errorCodes[code], Message: err.Error(), Exit: code}
+
+	var apiErr *ghtree.APIError
+	if errors.As(err, &apiErr) {
+		report.Status = apiErr.StatusCode
+	}
+	var rateErr *ghtree.RateLimitError
+	if errors.As(err, &rateErr) {
+		report.RetryAfter = int(math.Ceil(time.Until(rateErr.Reset).Seconds()))
+		if report.RetryAfter < 1 {
+			report.RetryAfter = 1
+		}
+	}
+	return report
+}
+
+// writeErrorJSON reports a failed run as a JSON object on stderr
+func writeErrorJSON(err error, code int) {
+	data, _ := json.Marshal(map[string]interface{}{"error": newErrorReport(err, code)})
+	progressMu.Lock()
+	defer progressMu.Unlock()
+	clearProgressLine()
+	fmt.Fprintln(os.Stderr, string(data))
+}
+
+// usageError reports a malformed command line, such as missing arguments
+type usageError struct {
+	msg string
+}
+
+func (e *usageError) Error() string {
+	return e.msg
+}
+
+// exitCode picks the exit status for an error, so scripts can tell a
+// missing repository from a bad token or a network failure
+func exitCode(err error) int {
+	var rateErr *ghtree.RateLimitError
+	var apiErr *ghtree.APIError
+	var netErr net.Error
+	var usageErr *usageError
+	var incomplete *ghtree.IncompleteError
+	switch {
+	case errors.As(err, &incomplete):
+		return exitStopped
+	case errors.As(err, &usageErr):
+		return exitUsage
+	case errors.As(err, &rateErr):
+		return exitRateLimited
+	case errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound:
+		return exitNotFound
+	case errors.As(err, &apiErr) && (apiErr.StatusCode == http.StatusUnauthorized || apiErr.StatusCode == http.StatusForbidden):
+		return exitAuth
+	case errors.As(err, &netErr):
+		return exitNetwork
+	}
+	return exitFailure
+}
+
+func run() (err error) {
+	// Subcommands come before any flags; without one the tree is shown
+	cmd, args := commands[0], os.Args[1:]
+	flag.Usage = usage
+	if len(args) > 0 {
+		if named, ok := lookupCommand(args[0]); ok {
+			cmd, args = named, args[1:]
+			flag.Usage = func() { commandUsage(cmd) }
+		}
+	}
+	if cmd.name == "completion" {
+		return printCompletion(args)
+	}
+
+	// Parse command-line flags
+	err = flag.CommandLine.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	// Take a repository argument, letting flags follow it as well
+	var positional []string
+	for flag.NArg() > 0 {
+		positional = append(positional, flag.Arg(0))
+		err := flag.CommandLine.Parse(flag.Args()[1:])
+		if err != nil {
+			return err
+		}
+	}
+	flag.Visit(func(f *flag.Flag) {
+		explicitFlags[f.Name] = true
+	})
+
+	// Report the version before reading any configuration
+	if versionFlag {
+		fmt.Fprintln(out, versionString())
+		return nil
+	}
+
+	// Apply the environment, then the user's profile, to any flags not
+	// given on the command line
+	err = applyEnvironment()
+	if err != nil {
+		return err
+	}
+	err = applyProfile(getProfilePath())
+	if err != nil {
+		return err
+	}
+
+	// Let the command turn its arguments into flag settings
+	err = cmd.apply(positional)
+	if err != nil {
+		return err
+	}
+
+	// A URL replaces the individual repository flags
+	if urlFlag != "" {
+		provider := ""
+		if isFlagSet("provider") {
+			provider = providerFlag
+		}
+		provider, owner, repo, ref, path, err := parseRepositoryURL(urlFlag, provider)
+		if err != nil {
+			return err
+		}
+		ownerFlag, repoFlag, providerFlag = owner, repo, provider
+		explicitFlags["owner"], explicitFlags["repo"], explicitFlags["provider"] = true, true, true
+
+		// A URL into the repository replaces --ref and --path; otherwise
+		// they still apply, but saved ones from another repository don't
+		if ref != "" || !isFlagSet("ref") {
+			refFlag = ref
+			explicitFlags["ref"] = true
+		}
+		if ref != "" || !isFlagSet("path") {
+			pathFlag = pathList{path}
+			explicitFlags["path"] = true
+		}
+	}
+
+	// Verifying compares with the --local checkout rather than drawing it
+	verifyDir := ""
+	if verifyFlag {
+		if localFlag == "" {
+			return &usageError{msg: "--verify needs the local checkout as --local DIR"}
+		}
+		verifyDir, localFlag = localFlag, ""
+	}
+	if noIgnoreFlag && ignoreFileFlag != "" {
+		return errors.New("--ignore-file and --no-ignore cannot be used together")
+	}
+	if contentsFlag && !verifyFlag {
+		return &usageError{msg: "--contents only applies to --verify"}
+	}
+
+	// A snapshot names the tree it was taken of, which is compared again
+	// unless flags pick another
+	var snapshot *ghtree.Snapshot
+	if sinceSnapshotFlag != "" {
+		snapshot, err = readSnapshot(sinceSnapshotFlag)
+		if err != nil {
+			return err
+		}
+		if !isFlagSet("owner") && !isFlagSet("repo") {
+			ownerFlag, repoFlag = snapshot.Owner, snapshot.Repo
+			explicitFlags["owner"], explicitFlags["repo"] = true, true
+		}
+		if !isFlagSet("path") {
+			pathFlag = pathList{snapshot.Path}
+			explicitFlags["path"] = true
+		}
+		if !isFlagSet("ref") && !isFlagSet("branch") {
+			refFlag = snapshot.Ref
+			explicitFlags["ref"] = true
+		}
+	}
+
+	// Several repositories are drawn side by side, each under a root of its
+	// own; the first stands in for the rest wherever one is needed
+	repositories, err := readRepositories()
+	if err != nil {
+		return err
+	}
+	severalRepos := len(repositories) > 1 || allReposFlag
+	if allReposFlag && (len(repositories) > 0 || urlFlag != "") {
+		return errors.New("--all-repos cannot be used with --repo, --repos-file or --url")
+	}
+	if severalRepos {
+		if option := singleRepositoryOnly(); option != "" {
+			return fmt.Errorf("%s cannot be used with several repositories", option)
+		}
+		if parallelFlag < 1 {
+			return errors.New("--parallel must be at least 1")
+		}
+	} else if isFlagSet("parallel") {
+		return &usageError{msg: "--parallel only applies to several repositories"}
+	}
+	// The server takes the repository, path and format from each request
+	if cmd.name == "serve" {
+		if option := singleRepositoryOnly(); option != "" {
+			return fmt.Errorf("%s cannot be used with serve", option)
+		}
+		if len(repositories) > 0 || allReposFlag || isFlagSet("path") || isFlagSet("format") {
+			return &usageError{msg: "serve takes the repository, path and format from each request, not from --repo, --all-repos, --path or --format"}
+		}
+	} else if isFlagSet("listen") || shareTokenFlag {
+		return &usageError{msg: "--listen and --share-token only apply to serve"}
+	}
+	// Checking the token draws nothing, and saves nothing
+	if cmd.name == "auth" {
+		if gistFlag != "" || localFlag != "" || len(repositories) > 1 || allReposFlag {
+			return &usageError{msg: "auth status checks one repository at most, not --gist, --local, --all-repos or several"}
+		}
+		noSaveFlag = true
+	}
+	if allReposFlag {
+		err = checkRepositoryFilters()
+		if err != nil {
+			return err
+		}
+	} else if isFlagSet("repo-filter") || isFlagSet("visibility") || isFlagSet("archived") {
+		return &usageError{msg: "--repo-filter, --visibility and --archived only apply to --all-repos"}
+	}
+	if len(repositories) > 0 && urlFlag == "" {
+		// Bare names belong to --owner, or else to the first repository's
+		if isFlagSet("owner") {
+			for i, repo := range repositories {
+				owner, name := splitRepository(repo, ownerFlag)
+				repositories[i] = owner + "/" + name
+			}
+		}
+		owner, name := splitRepository(repositories[0], ownerFlag)
+		if owner != ownerFlag {
+			ownerFlag = owner
+			explicitFlags["owner"] = true
+		}
+		repoFlag = name
+		explicitFlags["repo"] = true
+	}
+
+	// Pick how much to report on stderr, and how
+	switch {
+	case quietFlag && (verboseFlag || vFlag || vvFlag):
+		return errors.New("--quiet and --verbose cannot be used together")
+	case quietFlag:
+		verbosity = levelQuiet
+	case verboseFlag || vvFlag:
+		verbosity = levelTrace
+	case vFlag:
+		verbosity = levelVerbose
+	}
+	if logFormatFlag != "text" && logFormatFlag != "json" {
+		return fmt.Errorf("unknown --log-format %q; use text or json", logFormatFlag)
+	}
+
+	// Cancel in-flight requests when the user presses Ctrl-C
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	// Bound the whole run as well as each request
+	if deadlineFlag > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, deadlineFlag)
+		defer cancel()
+	}
+
+	// Check the flags against each other before making any requests
+	var parsed flagValues
+	err = validateFlags(&parsed)
+	if err != nil {
+		return err
+	}
+
+	// Locate the inputs file
+	inputsFilePath, err := getInputsPath()
+	if err != nil {
+		return err
+	}
+	if cmd.name == "config" {
+		return printConfig(inputsFilePath)
+	}
+
+	// Runs naming their repository in full start afresh, so saved values
+	// from another one can't leak in; --no-persist always does
+	if noPersistFlag && isFlagSet("save") && saveFlag {
+		return errors.New("--save and --no-persist cannot be used together")
+	}
+	persist := !noPersistFlag && !(isFlagSet("owner") && isFlagSet("repo"))
+	if noPersistFlag {
+		noSaveFlag = true
+	}
+
+	// Check if the inputs file exists
+	_, err = os.Stat(inputsFilePath)
+	if err == nil && !persist {
+		if noPersistFlag {
+			debugf("not reading %s with --no-persist", inputsFilePath)
+		} else {
+			debugf("not reading %s: --owner and --repo name the repository", inputsFilePath)
+		}
+		err = os.ErrNotExist
+	}
+
+	var current Inputs
+	needsRepository := gistFlag == "" && localFlag == "" && cmd.name != "serve" && cmd.name != "auth"
+	if err == nil {
+		// The file exists, so read existing inputs from the file
+		current, err = readInputsFromFile(inputsFilePath)
+		if err != nil {
+			return err
+		}
+
+		// Check if the owner and repo fields are empty
+		if needsRepository && (current.Owner == "" || current.Repo == "") {
+			return fmt.Errorf("the 'owner' and 'repo' fields in %s cannot be empty", inputsFilePath)
+		}
+
+		// Fall back to the profile or default maxDepth if not available
+		if current.MaxDepth == 0 {
+			current.MaxDepth = maxDepthFlag
+		}
+
+		// Update inputs if flags were provided
+		if isFlagSet("owner") {
+			current.Owner = ownerFlag
+		}
+		if isFlagSet("repo") {
+			current.Repo = repoFlag
+		}
+		if isFlagSet("path") {
+			current.Path = pathFlag
+		}
+		if isFlagSet("ref") || isFlagSet("branch") || current.Ref == "" {
+			current.Ref = refFlag
+		}
+		if isFlagSet("maxDepth") {
+			current.MaxDepth = maxDepthFlag
+		}
+		if isFlagSet("exclude") || len(current.Exclude) == 0 {
+			current.Exclude = excludeFlag
+		}
+		if isFlagSet("api-url") {
+			current.APIURL = apiURLFlag
+		}
+		if isFlagSet("provider") {
+			current.Provider = providerFlag
+		}
+	} else {
+		// The inputs file doesn't exist, so create it from the flags
+		current = Inputs{
+			Owner:    ownerFlag,
+			Repo:     repoFlag,
+			Path:     pathFlag,
+			Ref:      refFlag,
+			MaxDepth: maxDepthFlag,
+			Exclude:  excludeFlag,
+		}
+		if isFlagSet("api-url") {
+			current.APIURL = apiURLFlag
+		}
+		if isFlagSet("provider") {
+			current.Provider = providerFlag
+		}
+	}
+
+	// Other forges list directories, but lack GitHub's other APIs
+	provider := current.Provider
+	if provider == "" {
+		provider = "github"
+	}
+	err = checkProvider(provider)
+	if err != nil {
+		return err
+	}
+	providerFlag = provider
+	if option := githubOnly(); option != "" && provider != "github" {
+		return fmt.Errorf("%s is only available on GitHub", option)
+	}
+	if cmd.name == "auth" && provider != "github" {
+		return errors.New("auth status is only available on GitHub")
+	}
+
+	// Offline, only the walk itself can be answered from the stored tree
+	if offlineFlag {
+		if option := needsNetwork(); option != "" {
+			return fmt.Errorf("%s needs the network, so it cannot be used with --offline", option)
+		}
+		if noCacheFlag || cmd.name == "serve" || cmd.name == "auth" {
+			return errors.New("--offline cannot be used with --no-cache, serve or auth")
+		}
+	}
+	if (ownersFlag || lfsFlag || hyperlinksFlag) && provider != "github" && localFlag == "" {
+		return errors.New("--owners, --lfs and --hyperlinks are only available on GitHub")
+	}
+
+	// An owner's repositories are found once there is a client to ask, and
+	// drawn shallow unless asked otherwise
+	if allReposFlag {
+		if current.Owner == "" {
+			return errors.New("--all-repos needs the organization or user as --org")
+		}
+		current.Repo = ""
+		if !isFlagSet("maxDepth") {
+			current.MaxDepth = 1
+		}
+	}
+
+	// Listing repositories needs none, and an owner only if one is given
+	if listReposFlag {
+		if allReposFlag {
+			return errors.New("--list-repos and --all-repos cannot be used together")
+		}
+		if !isFlagSet("owner") {
+			current.Owner = ""
+		}
+		current.Repo = ""
+	}
+
+	if needsRepository && !listReposFlag && (current.Owner == "" || current.Repo == "" && !allReposFlag) {
+		return errors.New("a repository is required; pass --owner and --repo, --gist or --local")
+	}
+
+	// Catch malformed names and paths before they turn into confusing 404s
+	if needsRepository && provider == "github" && !listReposFlag {
+		if allReposFlag {
+			err = ghtree.CheckOwner(current.Owner)
+		} else {
+			err = ghtree.CheckRepository(current.Owner, current.Repo)
+		}
+		if err != nil {
+			return err
+		}
+		for _, repo := range repositories {
+			err = ghtree.CheckRepository(splitRepository(repo, current.Owner))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	for i, path := range current.Path {
+		current.Path[i], err = ghtree.CleanPath(path)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Resolve the API base: flag or inputs file, then environment, then
+	// default. Other forges default to their public instance.
+	apiBase := current.APIURL
+	if f, ok := forges[provider]; ok {
+		if apiBase == "" {
+			apiBase = f.apiURL
+		}
+		u, err := url.Parse(apiBase)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid API URL %q: expected something like %s", apiBase, f.apiURL)
+		}
+	} else {
+		if apiBase == "" {
+			apiBase = os.Getenv("GITHUB_API_URL")
+		}
+		if apiBase == "" {
+			apiBase = apiURLFlag
+		}
+		apiBase, err = normalizeAPIURL(apiBase)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Reject malformed exclude patterns before any request is made
+	for _, pattern := range current.Exclude {
+		if _, err := pathpkg.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid exclude pattern %q: %w", pattern, err)
+		}
+	}
+	depthOverrides, err := parseDepthOverrides(depthOverrideFlag)
+	if err != nil {
+		return err
+	}
+
+	// Zero and negative depths both mean no limit. Store that as -1 so it
+	// can't be mistaken for a missing maxDepth in the inputs file.
+	if current.MaxDepth < 1 {
+		current.MaxDepth = -1
+	}
+
+	// Update the inputs in the file only when asked to
+	if saveFlag && !noSaveFlag {
+		err = updateInputsInFile(inputsFilePath, current)
+		if err != nil {
+			return err
+		}
+	}
+
+	// No path means the repository root
+	if len(current.Path) == 0 {
+		current.Path = pathList{""}
+	}
+
+	// Unlimited walks take one trees request instead of one per directory,
+	// unless the output needs details only the contents API reports
+	recursiveAPI := recursiveAPIFlag
+	if !isFlagSet("recursive-api") && !graphqlFlag && needsRepository && provider == "github" && current.MaxDepth == -1 && downloadFlag == "" && archiveFlag == "" && previewFlag == "" && formatFlag != "markdown" && formatFlag != "html" {
+		debugf("using the git trees API for an unlimited-depth walk")
+		recursiveAPI = true
+	}
+
+	// Find an access token; public repositories work without one. GitHub
+	// Apps and GitHub's token sources only apply to GitHub.
+	var app *ghtree.App
+	if provider == "github" && localFlag == "" {
+		app, err = resolveApp()
+		if err != nil {
+			return err
+		}
+	}
+	var accessToken, tokenSource string
+	if app != nil {
+		tokenSource = fmt.Sprintf("GitHub App %d", app.ID)
+	} else if localFlag == "" {
+		accessToken, tokenSource, err = resolveToken(provider, apiBase)
+		if err != nil {
+			return err
+		}
+	}
+	if tokenSource != "" {
+		debugf("using the token from %s", tokenSource)
+	}
+
+	transport, err := newTransport(caCertFlag, insecureFlag)
+	if err != nil {
+		return err
+	}
+
+	// The .treeignore rules are read once the client can fetch them
+	var ignoreRules *ghtree.IgnoreRules
+	if !noIgnoreFlag {
+		ignoreRules = &ghtree.IgnoreRules{}
+	}
+
+	// Configure the client shared by every request of this run
+	opts := ghtree.Options{
+		Owner:            current.Owner,
+		Repo:             current.Repo,
+		DisplayName:      displayNameFlag,
+		Gist:             gistFlag,
+		Ref:              current.Ref,
+		MaxDepth:         current.MaxDepth,
+		DepthOverrides:   depthOverrides,
+		MaxPathDepth:     maxPathDepthFlag,
+		MaxEntries:       maxEntriesFlag,
+		Exclude:          current.Exclude,
+		Ignore:           ignoreRules,
+		Include:          includeFlag,
+		IncludeExt:       splitList(includeExtFlag),
+		MinSize:          parsed.minSize,
+		MaxSize:          parsed.maxSize,
+		DirsOnly:         dirsOnlyFlag,
+		RecursiveAPI:     recursiveAPI,
+		GraphQL:          graphqlFlag,
+		Sort:             sortFlag,
+		Reverse:          reverseFlag,
+		DirsFirst:        dirsFirstFlag,
+		Traversal:        traversalFlag,
+		CountCollapsed:   collapseCountFlag,
+		FollowSubmodules: followSubsFlag,
+		Token:            accessToken,
+		App:              app,
+		APIURL:           apiBase,
+		Concurrency:      concurrencyFlag,
+		ParallelUntil:    parallelUntilFlag,
+		RPS:              rpsFlag,
+		Timeout:          timeoutFlag,
+		Transport:        transport,
+		Retries:          retriesFlag,
+		RetryMaxWait:     retryMaxWaitFlag,
+		WaitOnRateLimit:  waitFlag,
+		MaxWait:          maxWaitFlag,
+		Log:              logEntry,
+	}
+	if printCurlFlag || printCurlUnsafe {
+		opts.Trace = func(req *http.Request) {
+			fmt.Fprintln(os.Stderr, curlCommand(req, printCurlUnsafe))
+		}
+	}
+	if f, ok := forges[provider]; ok {
+		opts.Provider = f.provider()
+	}
+	if localFlag != "" {
+		opts.Provider = ghtree.LocalProvider(localFlag)
+	}
+	if zipFlag != "" {
+		opts.Listing = writeZipListing
+	}
+	if formatFlag == "jsonl" && !interactiveFlag {
+		opts.Entry = writeJSONLEntry
+	}
+	if streamPaths {
+		opts.Entry = pathWriter(current.Path)
+	}
+	var treeStream *ghtree.TreeStream
+	if canStreamTree(cmd.name, current.Path, severalRepos, parsed) {
+		debugf("drawing the tree as it is listed")
+		treeStream = ghtree.NewTreeStream(progressClearingWriter{}, renderOptions(), current.Path[0])
+		opts.Listed = treeStream.Listed
+	}
+	streamedTree = treeStream != nil
+
+	// Count listed directories for the progress line
+	progress := newWalkProgress()
+	if progress.enabled && !interactiveFlag {
+		listing := opts.Listing
+		opts.Listing = func(path string, files []ghtree.File) error {
+			progress.dirs.Add(1)
+			if listing != nil {
+				return listing(path, files)
+			}
+			return nil
+		}
+	}
+	if cacheTTLFlag > 0 && !noCacheFlag {
+		opts.CacheDir, err = getCacheDir()
+		if err != nil {
+			return err
+		}
+		opts.CacheTTL = cacheTTLFlag
+	} else if (watchFlag || cmd.name == "serve") && !noCacheFlag {
+		// Revalidating every listing makes unchanged directories cost a
+		// conditional request that doesn't count against the rate limit
+		opts.CacheDir, err = getCacheDir()
+		if err != nil {
+			return err
+		}
+		opts.CacheTTL = time.Nanosecond
+	}
+
+	// Whole trees are kept for --offline runs to draw
+	if provider == "github" && !noCacheFlag {
+		cacheDir, err := getCacheDir()
+		if err != nil && offlineFlag {
+			return err
+		}
+		if err == nil {
+			opts.TreeDir = filepath.Join(cacheDir, "trees")
+		}
+		opts.Offline = offlineFlag
+	}
+
+	// Browsing lists one level at a time, on demand
+	if interactiveFlag {
+		if len(current.Path) > 1 {
+			return errors.New("--interactive browses one path at a time")
+		}
+		opts.MaxDepth = 1
+		opts.DepthOverrides = nil
+		opts.RecursiveAPI = false
+	}
+	if cmd.name == "serve" {
+		return serve(ctx, opts)
+	}
+	client := ghtree.NewClient(opts)
+	if cmd.name == "auth" {
+		return describeError(authStatus(ctx, client, tokenSource, current.Owner, current.Repo), tokenSource)
+	}
+
+	// Report the quota left however the run ends
+	if showRateLimitFlag {
+		defer func() { printRateLimit(client.Stats()) }()
+	}
+
+	// Send the output to a file instead of stdout when asked. It is written
+	// beside the target and renamed into place once complete, so a failed
+	// run never leaves a truncated file behind.
+	if outputFileFlag != "" {
+		var outputFile *os.File
+		outputFile, err = os.CreateTemp(filepath.Dir(outputFileFlag), "."+filepath.Base(outputFileFlag)+".*")
+		if err != nil {
+			return fmt.Errorf("failed to create output file: %w", err)
+		}
+		out = outputFile
+		defer func() {
+			var incomplete *ghtree.IncompleteError
+			if err != nil && !errors.As(err, &incomplete) {
+				outputFile.Close()
+				os.Remove(outputFile.Name())
+				return
+			}
+			closeErr := commitOutputFile(outputFile, outputFileFlag)
+			if err == nil {
+				err = closeErr
+			}
+		}()
+	}
+
+	// Hold back the final newline when asked
+	if noTrailingNLFlag {
+		out = &newlineTrimmer{w: out}
+	}
+
+	if listReposFlag {
+		return describeError(listRepos(ctx, client, current.Owner), tokenSource)
+	}
+
+	// Check repository access up front instead of failing mid-walk
+	if preflightFlag {
+		err = preflight(ctx, client, current.Owner, current.Repo)
+		if err != nil {
+			return describeError(err, tokenSource)
+		}
+	}
+
+	// List the available refs instead of the tree when asked
+	if listRefsFlag {
+		return describeError(listRefs(ctx, client), tokenSource)
+	}
+
+	// Find the repositories to draw, then draw the first with this client
+	if allReposFlag {
+		repositories, err = ownerRepositories(ctx, client, current.Owner)
+		if err != nil {
+			return describeError(err, tokenSource)
+		}
+		_, current.Repo = splitRepository(repositories[0], current.Owner)
+		opts.Repo = current.Repo
+		client = ghtree.NewClient(opts)
+	}
+
+	// Leave out what the .treeignore files name
+	if ignoreRules != nil {
+		err = loadIgnoreRules(ctx, client, ignoreRules)
+		if err != nil {
+			return describeError(err, tokenSource)
+		}
+	}
+
+	if interactiveFlag {
+		return describeError(browse(ctx, client, current.Path[0]), tokenSource)
+	}
+
+	// Compare two refs instead of listing one
+	if baseFlag != "" {
+		head := headFlag
+		if head == "" {
+			head = current.Ref
+		}
+		return describeError(printDiff(ctx, client, current.Path, baseFlag, head), tokenSource)
+	}
+
+	// Compare with another repository instead of listing this one
+	if diffRepoFlag != "" {
+		opts.Owner, opts.Repo, opts.Ref = parsed.against.Owner, parsed.against.Repo, parsed.against.Ref
+		other := ghtree.NewClient(opts)
+		return describeError(printRepositoryDiff(ctx, client, other, current, parsed.against), tokenSource)
+	}
+
+	// Record the tree, or compare it with a record, instead of listing
+	if snapshotFlag || snapshot != nil {
+		if len(current.Path) > 1 {
+			return errors.New("a snapshot covers one path at a time")
+		}
+		if snapshotFlag {
+			return describeError(printSnapshot(ctx, client, current.Path[0]), tokenSource)
+		}
+		return describeError(printSnapshotDiff(ctx, client, snapshot), tokenSource)
+	}
+
+	// Compare with a local checkout instead of listing
+	if verifyDir != "" {
+		return describeError(printVerify(ctx, client, current.Path, current.Ref, verifyDir), tokenSource)
+	}
+
+	// Plan the walk instead of taking it
+	if dryRunFlag {
+		return describeError(printDryRun(ctx, client, current.Path), tokenSource)
+	}
+
+	// Open the zip archive that collects per-directory listings
+	var zipFile *os.File
+	if zipFlag != "" {
+		zipFile, err = os.Create(zipFlag)
+		if err != nil {
+			return fmt.Errorf("failed to create zip archive: %w", err)
+		}
+		defer zipFile.Close()
+		zipWriter = zip.NewWriter(zipFile)
+	}
+
+	// Fetch files and folders for each requested path. With several paths,
+	// a failing one is reported and the rest are still shown; so is a
+	// failing repository among several.
+	var roots []*ghtree.Node
+	var incomplete *ghtree.IncompleteError
+	failed := 0
+	progress.start(client)
+	defer progress.stop()
+	if severalRepos {
+		roots, failed, err = fetchRepositories(ctx, client, opts, repositories, current.Path, tokenSource)
+		if err != nil && !errors.As(err, &incomplete) {
+			return describeError(err, tokenSource)
+		}
+		if failed == len(repositories) {
+			return errors.New("none of the repositories could be listed")
+		}
+	} else {
+		for _, path := range current.Path {
+			root, err := client.Fetch(ctx, path)
+			if treeStream != nil {
+				streamErr := treeStream.Close(root)
+				if err == nil && streamErr != nil {
+					return streamErr
+				}
+			}
+
+			// An interrupted walk still shows what it listed, then stops
+			if errors.As(err, &incomplete) {
+				roots = append(roots, root)
+				break
+			}
+			if err != nil {
+				err = describeError(err, tokenSource)
+				if len(current.Path) == 1 || ctx.Err() != nil {
+					return err
+				}
+				writeLog("error", fmt.Sprintf("%s: %v", displayPath(path), err), map[string]interface{}{"path": path})
+				failed++
+				continue
+			}
+			if streamPaths && root.Type != "dir" {
+				writePath(root.Path)
+			}
+			roots = append(roots, root)
+		}
+		if failed == len(current.Path) {
+			return errors.New("none of the paths could be listed")
+		}
+		if len(current.Path) > 1 {
+			roots = []*ghtree.Node{joinPaths(roots)}
+		}
+	}
+	progress.stop()
+
+	// Draw what was listed
+	err = render(ctx, client, roots, current, parsed, tokenSource)
+	if err != nil {
+		return err
+	}
+
+	// Mirror the listed files locally when asked, unless the walk was cut short
+	if downloadFlag != "" && incomplete == nil {
+		err = downloadTrees(ctx, client, roots)
+		if err != nil {
+			return describeError(err, tokenSource)
+		}
+	}
+
+	// Package the listed files when asked, unless the walk was cut short
+	if archiveFlag != "" && incomplete == nil {
+		err = writeArchive(ctx, client, roots)
+		if err != nil {
+			return describeError(err, tokenSource)
+		}
+	}
+
+	// Flush the zip archive
+	if zipWriter != nil {
+		err = zipWriter.Close()
+		if err == nil {
+			err = zipFile.Close()
+		}
+		if err != nil {
+			return fmt.Errorf("failed to write zip archive: %w", err)
+		}
+	}
+
+	// Keep reporting changes to what was listed until stopped
+	if watchFlag && incomplete == nil {
+		return describeError(watch(ctx, client, roots, current.Path), tokenSource)
+	}
+
+	if incomplete != nil {
+		return incomplete
+	}
+	if failed > 0 && severalRepos {
+		return fmt.Errorf("%d of %d repositories could not be listed", failed, len(repositories))
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d paths could not be listed", failed, len(current.Path))
+	}
+	return nil
+}
+
+// flagValues holds what validateFlags parsed out of the flags
+type flagValues struct {
+	minSize, maxSize int64
+	against          Inputs
+	entryTemplate    *template.Template
+	preview          previewLimit
+	searchMatch      pathMatcher
+}
+
+// validateFlags checks that the flags make sense, alone and together,
+// storing the values it parses in v
+func validateFlags(v *flagValues) error {
+	var err error
+
+	// Bound the number of simultaneous requests
+	if concurrencyFlag < 1 {
+		return errors.New("--concurrency must be at least 1")
+	}
+	if parallelUntilFlag < 0 {
+		return errors.New("--parallel-until cannot be negative")
+	}
+
+	if retriesFlag < 0 {
+		return errors.New("--retries cannot be negative")
+	}
+	if retryMaxWaitFlag < 0 {
+		return errors.New("--retry-max-wait cannot be negative")
+	}
+	if maxWaitFlag < 0 {
+		return errors.New("--max-wait cannot be negative")
+	}
+
+	// A walk needs room for at least the top level
+	if maxPathDepthFlag < 1 {
+		return errors.New("--max-path-depth must be at least 1")
+	}
+
+	// Validate the sort mode before making any requests
+	switch sortFlag {
+	case "type", "name", "size", "ext", "none":
+	case "commit-date":
+		warnf("--sort commit-date makes one extra API request per entry; consider --rps")
+	default:
+		return fmt.Errorf("unknown sort mode %q", sortFlag)
+	}
+
+	// Parse the size bounds
+	if minSizeFlag != "" {
+		v.minSize, err = parseSize(minSizeFlag)
+		if err != nil {
+			return fmt.Errorf("invalid --min-size: %w", err)
+		}
+	}
+	if maxSizeFlag != "" {
+		v.maxSize, err = parseSize(maxSizeFlag)
+		if err != nil {
+			return fmt.Errorf("invalid --max-size: %w", err)
+		}
+	}
+	if v.maxSize > 0 && v.minSize > v.maxSize {
+		return errors.New("--min-size is larger than --max-size")
+	}
+	if (v.minSize > 0 || v.maxSize > 0) && dirsOnlyFlag {
+		return errors.New("--min-size and --max-size select files, so they can't be used with --dirs-only")
+	}
+
+	// Validate the traversal. Breadth first, the text tree gives way to
+	// paths printed as each level is listed, so nothing can label them.
+	switch traversalFlag {
+	case "dfs":
+	case "bfs":
+		streamPaths = (formatFlag == "text" || formatFlag == "paths") && templateFlag == "" && !statsFlag && !duFlag && searchFlag == "" && !interactiveFlag
+		if streamPaths && (ownersFlag || lastCommitFlag || showSHAFlag || hyperlinksFlag || iconsFlag != "none" || previewFlag != "") {
+			return errors.New("--traversal bfs prints paths as they are listed, so it can't be used with --owners, --last-commit, --show-sha, --hyperlinks, --icons or --preview")
+		}
+	default:
+		return fmt.Errorf("unknown traversal %q; use dfs or bfs", traversalFlag)
+	}
+	if followSubsFlag && (traversalFlag == "bfs" || lastCommitFlag) {
+		return errors.New("--follow-submodules lists submodules once the walk is done, so it can't be used with --traversal bfs or --last-commit")
+	}
+
+	// Validate the output format
+	switch formatFlag {
+	case "text":
+	case "json":
+		if (histogramFlag || jsonStatsFlag) && (statsFlag || templateFlag != "") {
+			return errors.New("--depth-histogram and --json-stats can't be used with --stats or --template under --format json")
+		}
+	case "jsonl", "yaml", "csv", "tsv", "paths", "markdown", "html", "dot", "mermaid", "raw-urls":
+		if histogramFlag {
+			return errors.New("--depth-histogram is only supported with --format text or json")
+		}
+	default:
+		return fmt.Errorf("unknown output format %q", formatFlag)
+	}
+	if jsonStatsFlag && formatFlag != "json" {
+		return &usageError{msg: "--json-stats only applies to --format json"}
+	}
+
+	// A comparison is drawn as a tree or emitted as JSON
+	if headFlag != "" && baseFlag == "" {
+		return errors.New("--head needs --base")
+	}
+	if baseFlag != "" && formatFlag != "text" && formatFlag != "json" {
+		return errors.New("--base is only supported with --format text or json")
+	}
+	if verifyFlag && (baseFlag != "" || formatFlag != "text" && formatFlag != "json") {
+		return errors.New("--verify is only supported with --format text or json, and not with --base")
+	}
+
+	// Another repository is compared with this one at the same paths
+	if diffRepoFlag != "" {
+		if baseFlag != "" || verifyFlag || snapshotFlag || sinceSnapshotFlag != "" || formatFlag != "text" && formatFlag != "json" {
+			return errors.New("--diff-repo is only supported with --format text or json, and not with --base, --verify or snapshots")
+		}
+		provider, owner, repo, ref, _, err := parseRepositoryURL(repositoryURL(diffRepoFlag), "")
+		if err != nil {
+			return err
+		}
+		if provider != "github" {
+			return errors.New("--diff-repo only compares GitHub repositories")
+		}
+		v.against = Inputs{Owner: owner, Repo: repo, Ref: ref}
+	}
+
+	// A snapshot is always JSON, and comparing with one draws changes only
+	if snapshotFlag && sinceSnapshotFlag != "" {
+		return errors.New("--snapshot and --since-snapshot cannot be used together")
+	}
+	if snapshotFlag && (isFlagSet("format") || templateFlag != "" || statsFlag) {
+		return errors.New("--snapshot cannot be used with --format, --template or --stats")
+	}
+	if sinceSnapshotFlag != "" && (formatFlag != "text" && formatFlag != "json" || templateFlag != "" || statsFlag) {
+		return errors.New("--since-snapshot is only supported with --format text or json")
+	}
+	if (snapshotFlag || sinceSnapshotFlag != "") && (baseFlag != "" || verifyFlag || watchFlag || interactiveFlag || downloadFlag != "" || archiveFlag != "" || zipFlag != "" || dryRunFlag) {
+		return errors.New("snapshots cannot be used with --base, --verify, --watch, --interactive, --download, --archive, --zip or --dry-run")
+	}
+
+	// Watching redraws nothing, so it needs output that stays on screen
+	if watchFlag {
+		if intervalFlag <= 0 {
+			return errors.New("--interval must be positive")
+		}
+		if outputFileFlag != "" || downloadFlag != "" || archiveFlag != "" || zipFlag != "" || interactiveFlag || baseFlag != "" || verifyFlag || dryRunFlag {
+			return errors.New("--watch cannot be used with --output-file, --download, --archive, --zip, --interactive, --base, --verify or --dry-run")
+		}
+	} else if isFlagSet("interval") {
+		return &usageError{msg: "--interval only applies to --watch"}
+	}
+
+	// A dry run only measures the walk
+	if dryRunFlag && (zipFlag != "" || downloadFlag != "") {
+		return errors.New("--dry-run cannot be used with --zip or --download")
+	}
+
+	// Downloads need the per-file URLs that only the contents API reports
+	if downloadFlag != "" && (recursiveAPIFlag || graphqlFlag) {
+		return errors.New("--download cannot be used with --recursive-api or --graphql")
+	}
+	if archiveFlag != "" {
+		if archiveKind(archiveFlag) == "" {
+			return fmt.Errorf("cannot tell the archive format of %q; name it .tar.gz, .tgz, .tar or .zip", archiveFlag)
+		}
+		if recursiveAPIFlag || graphqlFlag || dryRunFlag {
+			return errors.New("--archive cannot be used with --recursive-api, --graphql or --dry-run")
+		}
+	}
+	if graphqlFlag && recursiveAPIFlag {
+		return errors.New("--graphql cannot be used with --recursive-api")
+	}
+
+	// Totals come as a table or as JSON
+	if statsFlag && formatFlag != "text" && formatFlag != "json" {
+		return errors.New("--stats is only supported with --format text or json")
+	}
+	if groupByFlag != "ext" && groupByFlag != "language" {
+		return fmt.Errorf("unknown --group-by %q; use ext or language", groupByFlag)
+	}
+
+	// A weight report replaces the tree, like the totals of --stats
+	if duFlag {
+		switch {
+		case formatFlag != "text" && formatFlag != "json":
+			return errors.New("--du is only supported with --format text or json")
+		case statsFlag || templateFlag != "" || searchFlag != "" || histogramFlag:
+			return errors.New("--du cannot be used with --stats, --template, --search or --depth-histogram")
+		case ownersFlag || lastCommitFlag || showSHAFlag || hyperlinksFlag || previewFlag != "":
+			return errors.New("--du prints no tree to label, so it cannot be used with --owners, --last-commit, --show-sha, --hyperlinks or --preview")
+		case baseFlag != "" || verifyFlag || snapshotFlag || sinceSnapshotFlag != "" || watchFlag || interactiveFlag:
+			return errors.New("--du cannot be used with --base, --verify, snapshots, --watch or --interactive")
+		}
+	}
+	if topFlag < 0 {
+		return errors.New("--top must be 0 or more")
+	}
+	if isFlagSet("top") && !duFlag {
+		return &usageError{msg: "--top only applies to --du"}
+	}
+
+	// A template replaces the output format
+	if templateFlag != "" {
+		if formatFlag != "text" || statsFlag {
+			return errors.New("--template cannot be used with --format or --stats")
+		}
+		v.entryTemplate, err = parseEntryTemplate(templateFlag)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Previews are drawn into the text tree, from the files' contents
+	if previewFlag != "" {
+		v.preview, err = parsePreview(previewFlag)
+		if err != nil {
+			return err
+		}
+		if formatFlag != "text" && !(formatFlag == "markdown" && markdownStyleFlag == "code") || templateFlag != "" || statsFlag {
+			return errors.New("--preview is only supported with the text tree")
+		}
+		if recursiveAPIFlag || graphqlFlag || baseFlag != "" || verifyFlag || snapshotFlag || sinceSnapshotFlag != "" || interactiveFlag {
+			return errors.New("--preview cannot be used with --recursive-api, --graphql, --base, --verify, snapshots or --interactive")
+		}
+	}
+
+	// Owners are drawn into the text tree
+	if ownersFlag && (formatFlag != "text" && !(formatFlag == "markdown" && markdownStyleFlag == "code") || templateFlag != "" || statsFlag || searchFlag != "" || gistFlag != "") {
+		return errors.New("--owners is only supported with the text tree of a repository")
+	}
+
+	if lfsFlag && (formatFlag == "jsonl" || gistFlag != "" || baseFlag != "" || verifyFlag || snapshotFlag || sinceSnapshotFlag != "" || interactiveFlag) {
+		return errors.New("--lfs cannot be used with --format jsonl, --gist, --base, --verify, snapshots or --interactive")
+	}
+	if lastCommitFlag {
+		if formatFlag != "text" && !(formatFlag == "markdown" && markdownStyleFlag == "code") || templateFlag != "" || statsFlag || searchFlag != "" || gistFlag != "" {
+			return errors.New("--last-commit is only supported with the text tree of a repository")
+		}
+		warnf("--last-commit makes one extra API request per entry; consider --cache-ttl")
+	}
+	if (relativeTimeFlag || absoluteTimeFlag) && !lastCommitFlag {
+		return &usageError{msg: "--relative-time and --absolute-time only apply to --last-commit"}
+	}
+	if verifyURLsFlag {
+		if formatFlag != "markdown" && formatFlag != "raw-urls" || templateFlag != "" || statsFlag || duFlag || searchFlag != "" || gistFlag != "" {
+			return errors.New("--verify-urls is only supported with --format markdown or raw-urls of a repository")
+		}
+		warnf("--verify-urls makes one extra request per file")
+	}
+	if displayNameFlag != "" {
+		owner, repo, ok := strings.Cut(displayNameFlag, "/")
+		if !ok {
+			return fmt.Errorf("--display-name %q is not of the form owner/name", displayNameFlag)
+		}
+		err := ghtree.CheckRepository(owner, repo)
+		if err != nil {
+			return fmt.Errorf("invalid --display-name: %w", err)
+		}
+		if diffRepoFlag != "" || gistFlag != "" {
+			return errors.New("--display-name cannot be used with --diff-repo or --gist")
+		}
+	}
+	if showSHAFlag {
+		switch formatFlag {
+		case "json", "jsonl", "yaml", "csv", "tsv":
+			// These carry every entry's full SHA already
+		default:
+			if formatFlag != "text" && !(formatFlag == "markdown" && markdownStyleFlag == "code") || templateFlag != "" || statsFlag || searchFlag != "" {
+				return errors.New("--show-sha is only supported with the text tree, and with json, jsonl, yaml, csv and tsv, which always carry full SHAs")
+			}
+		}
+	}
+	switch iconsFlag {
+	case "none":
+	case "nerd", "emoji":
+		if formatFlag != "text" && !(formatFlag == "markdown" && markdownStyleFlag == "code") || templateFlag != "" || statsFlag || searchFlag != "" {
+			return errors.New("--icons is only supported with the text tree")
+		}
+	default:
+		return fmt.Errorf("unknown --icons %q; use nerd, emoji or none", iconsFlag)
+	}
+	if hyperlinksFlag && (formatFlag != "text" || templateFlag != "" || statsFlag || searchFlag != "" || baseFlag != "" || verifyFlag || interactiveFlag) {
+		return errors.New("--hyperlinks is only supported with the text tree, and not with --base, --verify or --interactive")
+	}
+
+	// A search prints matching paths instead of the tree
+	if searchFlag != "" {
+		v.searchMatch, err = parseSearch(searchFlag, regexFlag)
+		if err != nil {
+			return err
+		}
+		if isFlagSet("format") || templateFlag != "" || statsFlag || previewFlag != "" || histogramFlag {
+			return errors.New("--search cannot be used with --format, --template, --stats, --preview or --depth-histogram")
+		}
+		if baseFlag != "" || verifyFlag || snapshotFlag || sinceSnapshotFlag != "" || watchFlag || interactiveFlag || downloadFlag != "" || archiveFlag != "" || zipFlag != "" {
+			return errors.New("--search cannot be used with --base, --verify, snapshots, --watch, --interactive, --download, --archive or --zip")
+		}
+	} else if regexFlag {
+		return &usageError{msg: "--regex only applies to --search"}
+	}
+	if typeFlag != "" || nullFlag {
+		if searchFlag == "" && (formatFlag != "paths" || templateFlag != "" || statsFlag) {
+			return &usageError{msg: "--type and --null only apply to --search and --format paths"}
+		}
+		err = checkEntryType(typeFlag)
+		if err != nil {
+			return err
+		}
+	}
+
+	// A gist is a flat list of files outside any repository
+	if gistFlag != "" && (len(pathFlag) > 0 || baseFlag != "" || verifyFlag || snapshotFlag || sinceSnapshotFlag != "" || listRefsFlag || preflightFlag || recursiveAPIFlag || graphqlFlag || sortFlag == "commit-date" || formatFlag == "raw-urls") {
+		return errors.New("--gist cannot be used with --path, --base, --verify, snapshots, --list-refs, --preflight, --recursive-api, --graphql, --sort commit-date or --format raw-urls")
+	}
+
+	// A local directory needs no repository, token or API
+	if localFlag != "" {
+		if option := githubOnly(); option != "" {
+			return fmt.Errorf("%s cannot be used with --local", option)
+		}
+		if urlFlag != "" || isFlagSet("provider") || downloadFlag != "" || archiveFlag != "" {
+			return errors.New("--local cannot be used with a repository URL, --provider, --download or --archive")
+		}
+	}
+
+	// Validate the Markdown style
+	if markdownStyleFlag != "list" && markdownStyleFlag != "code" {
+		return fmt.Errorf("unknown markdown style %q", markdownStyleFlag)
+	}
+
+	// Validate the width mode
+	if widthModeFlag != "wrap" && widthModeFlag != "truncate" {
+		return fmt.Errorf("unknown width mode %q", widthModeFlag)
+	}
+
+	// Validate the color mode
+	if colorFlag != "auto" && colorFlag != "always" && colorFlag != "never" {
+		return fmt.Errorf("unknown color mode %q", colorFlag)
+	}
+
+	// Validate the charset
+	if charsetFlag != "auto" && charsetFlag != "unicode" && charsetFlag != "ascii" {
+		return fmt.Errorf("unknown charset %q", charsetFlag)
+	}
+	return nil
+}
+
+// render draws the trees listed for current, with the labels and previews
+// the flags ask for, in the output format they pick
+func render(ctx context.Context, client *ghtree.Client, roots []*ghtree.Node, current Inputs, v flagValues, tokenSource string) error {
+	var err error
+
+	// Find the files stored in Git LFS before anything reports sizes
+	if lfsFlag {
+		patterns, err := loadLFSPatterns(ctx, client)
+		if err == nil && patterns != nil {
+			err = markLFS(ctx, client, roots, patterns)
+		}
+		if err != nil {
+			return describeError(fmt.Errorf("failed to detect LFS files: %w", err), tokenSource)
+		}
+	}
+
+	// Point the entries' pages at the repository shown instead
+	if displayNameFlag != "" {
+		relinkEntries(client, current.Ref, roots)
+	}
+
+	// Read the start of each file to draw below it
+	if previewFlag != "" {
+		previews = fetchPreviews(ctx, client, roots, v.preview)
+	}
+
+	// Link entries to their pages
+	if hyperlinksFlag {
+		links, err = entryLinks(client, current.Ref)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Label entries with their object IDs
+	if showSHAFlag {
+		annotations = append(annotations, shaAnnotation)
+	}
+
+	// Label entries with their last change
+	if lastCommitFlag {
+		commits, err := fetchLastCommits(ctx, client, roots)
+		if err != nil {
+			return describeError(err, tokenSource)
+		}
+		annotations = append(annotations, lastCommitAnnotation(commits))
+	}
+
+	// Mark the files whose download URLs don't resolve
+	if verifyURLsFlag {
+		broken, err := checkDownloadURLs(ctx, client, current.Ref, roots)
+		if err != nil {
+			return describeError(err, tokenSource)
+		}
+		annotations = append(annotations, brokenURLAnnotation(broken))
+	}
+
+	// Label entries with what CODEOWNERS says of them
+	if ownersFlag {
+		owners, err := loadCodeOwners(ctx, client)
+		if err != nil {
+			return describeError(fmt.Errorf("failed to read CODEOWNERS: %w", err), tokenSource)
+		}
+		if owners == nil {
+			warnf("no CODEOWNERS file found in %s", strings.Join(ghtree.CodeOwnersPaths, ", "))
+		} else {
+			annotations = append(annotations, ownersAnnotation(owners, roots))
+		}
+	}
+
+	// Render the collected trees in the requested format, or their totals
+	format := formatFlag
+	if statsFlag {
+		format = "stats"
+	}
+	if duFlag {
+		format = "du"
+	}
+	if v.entryTemplate != nil {
+		format = "template"
+	}
+	if v.searchMatch != nil {
+		format = "search"
+	}
+	registerFormats(ctx, client, current, v)
+	renderer, ok := ghtree.LookupRenderer(format)
+	if !ok {
+		return fmt.Errorf("no renderer is registered for format %q", format)
+	}
+	renderOpts := renderOptions()
+	renderOpts.Title = pageTitle(current)
+	err = ghtree.RenderTrees(out, renderer, roots, renderOpts)
+	if err != nil {
+		return describeError(err, tokenSource)
+	}
+
+	if histogramFlag && format != "json" {
+		printDepthHistogram(roots)
+	}
+	return nil
+}
+
+// commitOutputFile closes the temporary output file and renames it to
+// target, surfacing write errors that only show up on close
+func commitOutputFile(f *os.File, target string) error {
+	err := f.Chmod(0644)
+	if err == nil {
+		err = f.Close()
+	} else {
+		f.Close()
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), target)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("failed to write output file: %w", err)
+	}
+	return nil
+}
+
+// joinPaths gathers the trees of several paths of one repository under a
+// root for the whole of it, side by side and named by their full paths, so
+// they are drawn as one document
+func joinPaths(trees []*ghtree.Node) *ghtree.Node {
+	root := &ghtree.Node{Name: ".", Type: "dir", Children: []*ghtree.Node{}}
+	for _, tree := range trees {
+		if tree.Path != "" {
+			tree.Name = tree.Path
+		}
+		root.Children = append(root.Children, tree)
+	}
+	return root
+}
+
+// displayPath names a repository path in messages
+func displayPath(path string) string {
+	if path == "" {
+		return "."
+	}
+	return path
+}
+
+func readInputsFromFile(filePath string) (Inputs, error) {
+	// Read the contents of the file
+	fileData, err := os.ReadFile(filePath)
+	if err != nil {
+		return Inputs{}, fmt.Errorf("failed to read inputs from file: %w", err)
+	}
+
+	// Unmarshal the JSON data into a struct
+	var inputs Inputs
+	err = json.Unmarshal(fileData, &inputs)
+	if err != nil {
+		return Inputs{}, fmt.Errorf("failed to parse inputs from %s: %w", filePath, err)
+	}
+
+	return inputs, nil
+}
+
+// parseGitHubURL splits a github.com URL into its repository coordinates. It
+// accepts plain repository URLs, clone URLs ending in .git, and browser URLs
+// of the form /owner/repo/tree/<ref>/<path> or /owner/repo/blob/<ref>/<path>.
+func parseGitHubURL(raw string) (owner, repo, ref, path string, err error) {
+	// Treat SSH clone URLs like their HTTPS equivalent
+	if strings.HasPrefix(raw, "git@") {
+		raw = "https://" + strings.Replace(strings.TrimPrefix(raw, "git@"), ":", "/", 1)
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", "", "", "", fmt.Errorf("invalid URL %q: %w", raw, err)
+	}
+	if u.Host == "" {
+		return "", "", "", "", fmt.Errorf("invalid URL %q: expected https://github.com/owner/repo", raw)
+	}
+
+	segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(segments) < 2 || segments[0] == "" || segments[1] == "" {
+		return "", "", "", "", fmt.Errorf("URL %q does not name a repository", raw)
+	}
+	owner = segments[0]
+	repo = strings.TrimSuffix(segments[1], ".git")
+
+	// Browser URLs carry the ref and the path after /tree/ or /blob/
+	if len(segments) > 3 && (segments[2] == "tree" || segments[2] == "blob") {
+		ref = segments[3]
+		path = strings.Join(segments[4:], "/")
+	}
+	return owner, repo, ref, path, nil
+}
+
+// repositoryURL expands a repository argument to a URL parseRepositoryURL
+// understands: owner/repo shorthand and URLs without a scheme gain one
+func repositoryURL(arg string) string {
+	if strings.Contains(arg, "://") || strings.HasPrefix(arg, "git@") {
+		return arg
+	}
+	for _, host := range []string{"github.com", "gitlab.com", "bitbucket.org"} {
+		if strings.HasPrefix(arg, host+"/") || strings.HasPrefix(arg, "www."+host+"/") {
+			return "https://" + arg
+		}
+	}
+	return "https://github.com/" + strings.Trim(arg, "/")
+}
+
+func isFlagSet(name string) bool {
+	if explicitFlags[name] {
+		return true
+	}
+
+	// Check the short alias of a long flag as well
+	for short, long := range flagAliases {
+		if long == name && explicitFlags[short] {
+			return true
+		}
+	}
+	return false
+}
+
+func getProfilePath() string {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(configDir, "github-tree", "config.json")
+}
+
+// getCacheDir returns the directory that holds cached listings
+func getCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to locate the cache directory: %w", err)
+	}
+	return filepath.Join(dir, "github-tree"), nil
+}
+
+func applyProfile(filePath string) error {
+	if filePath == "" {
+		return nil
+	}
+
+	// A missing profile simply means no user defaults
+	fileData, err := os.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read profile: %w", err)
+	}
+
+	// The profile maps long flag names to their default values, with named
+	// sets of them under "profiles"
+	var profile map[string]interface{}
+	err = json.Unmarshal(fileData, &profile)
+	if err != nil {
+		return fmt.Errorf("failed to parse profile %s: %w", filePath, err)
+	}
+	named, err := namedProfiles(profile, filePath)
+	if err != nil {
+		return err
+	}
+	delete(profile, "profiles")
+
+	// The defaults may pick a named profile when --profile doesn't
+	name := profileFlag
+	if !isFlagSet("profile") {
+		if value, ok := profile["profile"].(string); ok {
+			name = value
+		}
+	}
+
+	// A named profile's values win over the defaults around it
+	applied := map[string]bool{}
+	if name != "" {
+		values, ok := named[name]
+		if !ok {
+			return fmt.Errorf("no profile named %q in %s (have: %s)", name, filePath, strings.Join(sortedKeys(named), ", "))
+		}
+		err = applyProfileValues(values, filePath, applied)
+		if err != nil {
+			return err
+		}
+	}
+	return applyProfileValues(profile, filePath, applied)
+}
+
+// namedProfiles returns the "profiles" object of a profile file
+func namedProfiles(profile map[string]interface{}, filePath string) (map[string]map[string]interface{}, error) {
+	named := map[string]map[string]interface{}{}
+	raw, ok := profile["profiles"]
+	if !ok {
+		return named, nil
+	}
+
+	entries, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf(`"profiles" in %s must be an object of named profiles`, filePath)
+	}
+	for name, entry := range entries {
+		values, ok := entry.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("profile %q in %s must be an object", name, filePath)
+		}
+		named[name] = values
+	}
+	return named, nil
+}
+
+// applyProfileValues sets each flag of values that neither the command
+// line nor an earlier profile level set, recording the ones it sets
+func applyProfileValues(values map[string]interface{}, filePath string, applied map[string]bool) error {
+	for name, value := range values {
+		if flag.Lookup(name) == nil {
+			return fmt.Errorf("unknown option %q in profile %s", name, filePath)
+		}
+
+		// Flags given on the command line always win
+		if isFlagSet(name) || applied[name] {
+			continue
+		}
+		applied[name] = true
+
+		// Lists set repeatable flags once per element
+		list := []interface{}{value}
+		if l, ok := value.([]interface{}); ok {
+			list = l
+		}
+		for _, v := range list {
+			// JSON numbers decode as float64, which fmt would write in
+			// exponent form from a million on
+			text := fmt.Sprint(v)
+			if f, ok := v.(float64); ok {
+				text = strconv.FormatFloat(f, 'f', -1, 64)
+			}
+			err := flag.Set(name, text)
+			if err != nil {
+				return fmt.Errorf("invalid value for %q in profile %s: %w", name, filePath, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// versionString describes this build, filling in what -ldflags left unset
+// from the build information Go embeds in the binary
+func versionString() string {
+	v, rev, built := version, commit, date
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if v == "dev" && info.Main.Version != "" && info.Main.Version != "(devel)" {
+			v = info.Main.Version
+		}
+		for _, setting := range info.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && rev == "":
+				rev = setting.Value
+			case setting.Key == "vcs.time" && built == "":
+				built = setting.Value
+			}
+		}
+	}
+
+	if rev == "" {
+		rev = "unknown"
+	}
+	if built == "" {
+		built = "unknown"
+	}
+	return fmt.Sprintf("github-tree %s (commit %s, built %s)", v, rev, built)
+}
+
+// parseDepthOverrides reads --depth-override values, PATTERN=DEPTH each;
+// zero and negative depths mean no limit, as with --maxDepth
+func parseDepthOverrides(values []string) ([]ghtree.DepthOverride, error) {
+	var overrides []ghtree.DepthOverride
+	for _, value := range values {
+		i := strings.LastIndex(value, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid --depth-override %q; use PATTERN=DEPTH, as in 'src/**=4'", value)
+		}
+		pattern := strings.Trim(value[:i], "/")
+		depth, err := strconv.Atoi(value[i+1:])
+		if err != nil || pattern == "" {
+			return nil, fmt.Errorf("invalid --depth-override %q; use PATTERN=DEPTH, as in 'src/**=4'", value)
+		}
+		if _, err := pathpkg.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid --depth-override pattern %q: %w", pattern, err)
+		}
+		overrides = append(overrides, ghtree.DepthOverride{Pattern: pattern, MaxDepth: depth})
+	}
+	return overrides, nil
+}
+
+// parseSize reads a size in bytes, with an optional binary unit as
+// HumanizeBytes writes them: 512, 10k, 1.5M, 2GB or 3GiB
+func parseSize(s string) (int64, error) {
+	number := strings.TrimSpace(s)
+	for _, suffix := range []string{"iB", "ib", "B", "b"} {
+		if strings.HasSuffix(number, suffix) {
+			number = strings.TrimSuffix(number, suffix)
+			break
+		}
+	}
+	multiplier := int64(1)
+	if number != "" {
+		if i := strings.Index("kmgt", strings.ToLower(number[len(number)-1:])); i >= 0 {
+			multiplier = 1 << (10 * (i + 1))
+			number = number[:len(number)-1]
+		}
+	}
+	n, err := strconv.ParseFloat(strings.TrimSpace(number), 64)
+	if err != nil || math.IsNaN(n) || math.IsInf(n, 0) || n < 0 {
+		return 0, fmt.Errorf("%q is not a size; use bytes, or a k, M, G or T suffix as in 10k", s)
+	}
+	if n*float64(multiplier) >= math.MaxInt64 {
+		return 0, fmt.Errorf("%q is too large a size", s)
+	}
+	return int64(n * float64(multiplier)), nil
+}
+
+// splitList flattens comma-separated values of a repeatable flag
+func splitList(values []string) []string {
+	var items []string
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+	}
+	return items
+}
+
+// getInputsPath returns the inputs file: --config if given, otherwise
+// inputs.json in the user config directory. A github-tree-inputs.txt in the
+// current directory, where older versions kept it, is still used until the
+// per-user file exists.
+func getInputsPath() (string, error) {
+	if configFlag != "" {
+		return configFlag, nil
+	}
+
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return getAbsolutePath("github-tree-inputs.txt")
+	}
+	inputsPath := filepath.Join(configDir, "github-tree", "inputs.json")
+
+	if _, err := os.Stat(inputsPath); os.IsNotExist(err) {
+		legacyPath, err := getAbsolutePath("github-tree-inputs.txt")
+		if err != nil {
+			return "", err
+		}
+		if _, err := os.Stat(legacyPath); err == nil {
+			warnf("using %s; move it to %s or pass --config", legacyPath, inputsPath)
+			return legacyPath, nil
+		}
+	}
+	return inputsPath, nil
+}
+
+func getAbsolutePath(filePath string) (string, error) {
+	currentDir, err := os.Getwd()
+	if err != nil {
+		return "", fmt.Errorf("failed to get current directory: %w", err)
+	}
+
+	return filepath.Join(currentDir, filePath), nil
+}
+
+// previews holds the --preview lines of the files being drawn
+var previews map[string][]string
+
+// links gives the --hyperlinks URL of each entry of the text tree
+var links func(node *ghtree.Node) string
+
+// annotations label the entries of the text tree, each adding its text
+// after the name
+var annotations []func(node *ghtree.Node) string
+
+// shaAnnotation labels an entry with its abbreviated SHA. Submodules show
+// the commit they are pinned to already, and local entries have none.
+func shaAnnotation(node *ghtree.Node) string {
+	if node.Type == "submodule" || node.SHA == "" {
+		return ""
+	}
+	if len(node.SHA) > 7 {
+		return node.SHA[:7]
+	}
+	return node.SHA
+}
+
+// annotate joins the text of every annotation of an entry
+func annotate(node *ghtree.Node) string {
+	var notes []string
+	for _, annotation := range annotations {
+		if note := annotation(node); note != "" {
+			notes = append(notes, note)
+		}
+	}
+	return strings.Join(notes, "  ")
+}
+
+// renderOptions are the options of the command line for the library's
+// renderers
+func renderOptions() ghtree.RenderOptions {
+	renderOpts := ghtree.RenderOptions{
+		ASCII:    useASCII(),
+		Sizes:    sizesFlag,
+		Width:    widthFlag,
+		Truncate: widthModeFlag == "truncate",
+		Color:    useColor(),
+		Palette:  parseLSColors(os.Getenv("LS_COLORS")),
+		Previews: previews,
+		Annotate: annotate,
+		Link:     links,
+	}
+	switch iconsFlag {
+	case "nerd":
+		renderOpts.Icons = ghtree.NerdIcons
+	case "emoji":
+		renderOpts.Icons = ghtree.EmojiIcons
+	}
+	return renderOpts
+}
+
+// renderTrees writes roots with one of the library's renderers, which the
+// command line's formats build on
+func renderTrees(renderer ghtree.Renderer, roots []*ghtree.Node) error {
+	return ghtree.RenderTrees(out, renderer, roots, renderOptions())
+}
+
+// printText draws roots as text trees. Listings of several paths arrive
+// joined under one root by joinPaths; comparisons still draw a tree per path.
+func printText(roots []*ghtree.Node) error {
+	for i, root := range roots {
+		// Label each compared path when there are several
+		if len(roots) > 1 {
+			if i > 0 {
+				fmt.Fprintln(out)
+			}
+			fmt.Fprintln(out, root.Name)
+		}
+
+		err := renderTrees(ghtree.TextRenderer(), []*ghtree.Node{root})
+		if err != nil {
+			return err
+		}
+
+		// Print a sentinel so scripts can tell an empty tree from a failed run
+		if len(root.TopLevel()) == 0 && root.Omitted == 0 && emptyMarkerFlag {
+			fmt.Fprintln(out, "(empty)")
+		}
+	}
+	return nil
+}
+
+func printRateLimit(stats ghtree.Stats) {
+	if stats.RateLimit == 0 {
+		fmt.Fprintln(os.Stderr, "rate limit: not reported by the API")
+		return
+	}
+	fmt.Fprintf(os.Stderr, "rate limit: %d of %d remaining, resetting at %s\n", stats.RateLimitRemaining, stats.RateLimit, stats.RateLimitReset.Format(time.Kitchen))
+}
+
+// printDryRun prints the requests walking paths would send, then how many
+// that makes against the rate limit left
+func printDryRun(ctx context.Context, client *ghtree.Client, paths []string) error {
+	total := 0
+	for _, path := range paths {
+		urls, err := client.Plan(ctx, path)
+		if err != nil {
+			return err
+		}
+		for _, u := range urls {
+			fmt.Fprintln(out, "GET "+u)
+		}
+		total += len(urls)
+	}
+
+	stats := client.Stats()
+	fmt.Fprintf(out, "the walk would make %s (planning it took %d)\n", plural(total, "API request", "API requests"), stats.Requests)
+	if stats.RateLimit > 0 {
+		fmt.Fprintf(out, "%d of %d rate-limit units remaining, resetting at %s\n", stats.RateLimitRemaining, stats.RateLimit, stats.RateLimitReset.Format(time.Kitchen))
+		if total > stats.RateLimitRemaining {
+			warnf("the walk needs more requests than the rate limit has left; add --wait-on-rate-limit to wait for it")
+		}
+	}
+	return nil
+}
+
+func printMarkdown(roots []*ghtree.Node) error {
+	// The code style fences the plain text tree
+	if markdownStyleFlag == "code" {
+		fmt.Fprintln(out, "```text")
+		err := printText(roots)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(out, "```")
+		return nil
+	}
+
+	if len(roots) == 1 {
+		return renderTrees(ghtree.MarkdownRenderer(), roots)
+	}
+
+	// Several trees each get a top-level item of their own
+	for _, root := range roots {
+		err := root.RenderMarkdownItem(out)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pageTitle names the listed repository, or the name --display-name
+// shows instead, in document headings
+func pageTitle(inputs Inputs) string {
+	title := inputs.Owner + "/" + inputs.Repo
+	if displayNameFlag != "" {
+		title = displayNameFlag
+	}
+	if gistFlag != "" {
+		title = "gist " + gistFlag
+	}
+	if localFlag != "" {
+		return localFlag
+	}
+	if inputs.Ref != "" {
+		title += "@" + inputs.Ref
+	}
+	return title
+}
+
+func printRawURLs(ctx context.Context, client *ghtree.Client, ref string, roots []*ghtree.Node) error {
+	// Pin the URLs to a branch even when the default one was listed
+	ref, err := rawURLRef(ctx, client, ref)
+	if err != nil {
+		return err
+	}
+
+	var walk func(nodes []*ghtree.Node, prefix string)
+	walk = func(nodes []*ghtree.Node, prefix string) {
+		for _, node := range nodes {
+			switch node.Type {
+			case "dir":
+				walk(node.Children, prefix+node.Name+"/")
+			case "file":
+				line := prefix + node.Name + " -> " + client.RawURL(ref, node.Path)
+				if note := annotate(node); note != "" {
+					line += "  " + note
+				}
+				fmt.Fprintln(out, line)
+			}
+		}
+	}
+
+	// Several trees only stay apart with their starting paths kept
+	for _, root := range roots {
+		prefix := ""
+		if len(roots) > 1 && root.Type == "dir" && root.Path != "" {
+			prefix = root.Path + "/"
+		}
+		walk(root.TopLevel(), prefix)
+	}
+	return nil
+}
+
+func printPaths(roots []*ghtree.Node) error {
+	// Only --type and --null need more than the library's paths
+	if typeFlag == "" && !nullFlag && len(roots) == 1 {
+		return renderTrees(ghtree.PathsRenderer(), roots)
+	}
+
+	for _, root := range roots {
+		// Paths come in the order of a walk of the entries, which tells
+		// their types
+		var nodes []*ghtree.Node
+		var walk func(entries []*ghtree.Node)
+		walk = func(entries []*ghtree.Node) {
+			for _, node := range entries {
+				nodes = append(nodes, node)
+				walk(node.Children)
+			}
+		}
+		walk(root.TopLevel())
+
+		for i, path := range root.Paths() {
+			if typeFlag != "" && nodes[i].Type != typeFlag {
+				continue
+			}
+			// Several trees only stay apart with their starting paths kept
+			if len(roots) > 1 && root.Type == "dir" && root.Path != "" {
+				path = root.Path + "/" + path
+			}
+			fmt.Fprint(out, path, pathEnd())
+		}
+	}
+	return nil
+}
+
+// pathEnd ends each path --format paths and --search print: a newline, or
+// a NUL with --null, since paths may hold newlines themselves
+func pathEnd() string {
+	if nullFlag {
+		return "\x00"
+	}
+	return "\n"
+}
+
+// useColor reports whether --color asks for color here. In auto mode that
+// means stdout is a terminal and NO_COLOR is unset.
+func useColor() bool {
+	// Escapes would end up inside the Markdown code block
+	if formatFlag == "markdown" {
+		return false
+	}
+
+	if noColorFlag {
+		return false
+	}
+
+	switch colorFlag {
+	case "always":
+		return true
+	case "never":
+		return false
+	}
+
+	if outputFileFlag != "" || os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	info, err := os.Stdout.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// lsColorKeys maps the LS_COLORS keys the tree has counterparts for to
+// entry types
+var lsColorKeys = map[string]string{
+	"di": "dir",
+	"ln": "symlink",
+	"ex": "executable",
+}
+
+// parseLSColors turns an LS_COLORS value such as "di=01;34:*.go=32" into a
+// render palette, ignoring keys with no counterpart in the tree
+func parseLSColors(value string) map[string]string {
+	palette := map[string]string{}
+	for _, item := range strings.Split(value, ":") {
+		key, style, ok := strings.Cut(item, "=")
+		if !ok {
+			continue
+		}
+		if ext := strings.TrimPrefix(key, "*"); ext != key && strings.HasPrefix(ext, ".") {
+			palette[strings.ToLower(ext)] = style
+		} else if kind, ok := lsColorKeys[key]; ok {
+			palette[kind] = style
+		}
+	}
+	return palette
+}
+
+// useASCII reports whether the tree should be drawn without box-drawing
+// characters. In auto mode that means the locale isn't UTF-8, or, with no
+// locale set, a Windows console other than Windows Terminal.
+func useASCII() bool {
+	if asciiFlag {
+		return true
+	}
+
+	switch charsetFlag {
+	case "ascii":
+		return true
+	case "unicode":
+		return false
+	}
+
+	// The first locale variable set decides, as it does for other programs
+	for _, name := range []string{"LC_ALL", "LC_CTYPE", "LANG"} {
+		if locale := os.Getenv(name); locale != "" {
+			locale = strings.ToLower(locale)
+			return !strings.Contains(locale, "utf-8") && !strings.Contains(locale, "utf8")
+		}
+	}
+	return runtime.GOOS == "windows" && os.Getenv("WT_SESSION") == ""
+}
+
+func printJSON(roots []*ghtree.Node) error {
+	// A single path renders as one object, several as an array
+	var v interface{} = roots
+	if len(roots) == 1 {
+		v = roots[0]
+	}
+
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// printTreeJSON writes the listed trees as printJSON does, or, when more
+// than the tree was asked for, as the "tree" of an object that holds the
+// rest too
+func printTreeJSON(roots []*ghtree.Node) error {
+	if !histogramFlag && !jsonStatsFlag {
+		return renderTrees(ghtree.JSONRenderer(), roots)
+	}
+
+	doc := struct {
+		Tree           interface{}  `json:"tree"`
+		Stats          *treeTotals  `json:"stats,omitempty"`
+		DepthHistogram []depthCount `json:"depthHistogram,omitempty"`
+	}{Tree: roots}
+	if len(roots) == 1 {
+		doc.Tree = roots[0]
+	}
+	if jsonStatsFlag {
+		totals := countTotals(roots)
+		doc.Stats = &totals
+	}
+	if histogramFlag {
+		doc.DepthHistogram = depthHistogram(roots)
+	}
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(doc)
+}
+
+// printTable writes one row per entry, under a header row, with comma as
+// the field separator
+func printTable(roots []*ghtree.Node, comma rune) error {
+	w := csv.NewWriter(out)
+	w.Comma = comma
+	w.Write([]string{"path", "type", "size", "sha", "depth"})
+	row := func(node *ghtree.Node, depth int) {
+		w.Write([]string{node.Path, node.Type, strconv.FormatInt(node.Size, 10), node.SHA, strconv.Itoa(depth)})
+	}
+
+	var walk func(nodes []*ghtree.Node, depth int)
+	walk = func(nodes []*ghtree.Node, depth int) {
+		for _, node := range nodes {
+			row(node, depth)
+			walk(node.Children, depth+1)
+		}
+	}
+	for _, root := range roots {
+		// A root that is a single file is the whole tree
+		if root.Type != "dir" {
+			row(root, 0)
+			continue
+		}
+		walk(root.Children, 1)
+	}
+
+	w.Flush()
+	return w.Error()
+}
+
+// templateEscapes expands the escapes a template given on the command line
+// can't otherwise hold
+var templateEscapes = strings.NewReplacer(`\\`, `\`, `\t`, "\t", `\n`, "\n")
+
+// templateEntry is what --template renders: the entry's fields, plus its
+// depth below the root
+type templateEntry struct {
+	*ghtree.Node
+	Depth int
+}
+
+// parseEntryTemplate parses the --template text, expanding \t and \n
+func parseEntryTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("entry").Parse(templateEscapes.Replace(text))
+	if err != nil {
+		return nil, fmt.Errorf("invalid --template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// printTemplate renders every entry through tmpl, one per line, in the
+// order the tree is drawn
+func printTemplate(roots []*ghtree.Node, tmpl *template.Template) error {
+	var walk func(nodes []*ghtree.Node, depth int) error
+	walk = func(nodes []*ghtree.Node, depth int) error {
+		for _, node := range nodes {
+			err := printTemplateEntry(tmpl, templateEntry{node, depth})
+			if err != nil {
+				return err
+			}
+			err = walk(node.Children, depth+1)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, root := range roots {
+		// A root that is a single file is the whole tree
+		if root.Type != "dir" {
+			err := printTemplateEntry(tmpl, templateEntry{root, 0})
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		err := walk(root.Children, 1)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func printTemplateEntry(tmpl *template.Template, entry templateEntry) error {
+	err := tmpl.Execute(out, entry)
+	if err != nil {
+		return fmt.Errorf("failed to render %s with --template: %w", entry.Path, err)
+	}
+	_, err = fmt.Fprintln(out)
+	return err
+}
+
+// depthCount tallies the directories and files at one depth level
+type depthCount struct {
+	Depth int `json:"depth"`
+	Dirs  int `json:"dirs"`
+	Files int `json:"files"`
+}
+
+// depthHistogram counts the directories and files at each depth of roots
+func depthHistogram(roots []*ghtree.Node) []depthCount {
+	counts := []depthCount{}
+	for _, root := range roots {
+		counts = countByDepth(root.TopLevel(), 1, counts)
+	}
+	return counts
+}
+
+func printDepthHistogram(roots []*ghtree.Node) {
+	fmt.Fprintln(out)
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "depth\tdirs\tfiles")
+	for _, c := range depthHistogram(roots) {
+		fmt.Fprintf(w, "%d\t%d\t%d\n", c.Depth, c.Dirs, c.Files)
+	}
+	w.Flush()
+}
+
+func printDiff(ctx context.Context, client *ghtree.Client, paths []string, base, head string) error {
+	var roots []*ghtree.Node
+	for _, path := range paths {
+		root, err := client.Diff(ctx, path, base, head)
+		if err != nil {
+			return err
+		}
+		roots = append(roots, root)
+	}
+
+	counts, err := printChanges(roots)
+	if err != nil || formatFlag == "json" || noSummaryFlag || verbosity < levelNormal {
+		return err
+	}
+	fmt.Fprintf(out, "\n%d added, %d removed, %d modified\n", counts["added"], counts["removed"], counts["modified"])
+	return nil
+}
+
+// readSnapshot loads a snapshot written by --snapshot
+func readSnapshot(filePath string) (*ghtree.Snapshot, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot: %w", err)
+	}
+	var snapshot ghtree.Snapshot
+	err = json.Unmarshal(data, &snapshot)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse snapshot %s: %w", filePath, err)
+	}
+	if snapshot.Owner == "" || snapshot.Repo == "" || snapshot.Entries == nil {
+		return nil, fmt.Errorf("%s is not a snapshot", filePath)
+	}
+	return &snapshot, nil
+}
+
+// printSnapshot writes a snapshot of path as indented JSON
+func printSnapshot(ctx context.Context, client *ghtree.Client, path string) error {
+	snapshot, err := client.Snapshot(ctx, path)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(out, "%s\n", data)
+	return err
+}
+
+// printSnapshotDiff draws what changed since snapshot was taken
+func printSnapshotDiff(ctx context.Context, client *ghtree.Client, snapshot *ghtree.Snapshot) error {
+	root, err := client.DiffSnapshot(ctx, snapshot)
+	if err != nil {
+		return err
+	}
+
+	counts, err := printChanges([]*ghtree.Node{root})
+	if err != nil || formatFlag == "json" || noSummaryFlag || verbosity < levelNormal {
+		return err
+	}
+	fmt.Fprintf(out, "\n%d added, %d removed, %d changed since %s\n", counts["added"], counts["removed"], counts["modified"], snapshot.Taken.Local().Format("2006-01-02 15:04"))
+	return nil
+}
+
+// printRepositoryDiff compares the paths of repository a, which client
+// reads, with the same paths of repository b, which other reads
+func printRepositoryDiff(ctx context.Context, client, other *ghtree.Client, a, b Inputs) error {
+	var roots []*ghtree.Node
+	for _, path := range a.Path {
+		root, err := client.DiffRepository(ctx, path, other)
+		if err != nil {
+			return err
+		}
+		roots = append(roots, root)
+	}
+
+	counts, err := printChanges(roots)
+	if err != nil || formatFlag == "json" || noSummaryFlag || verbosity < levelNormal {
+		return err
+	}
+	fmt.Fprintf(out, "\n%d only in %s/%s, %d only in %s/%s, %d differ\n", counts["removed"], a.Owner, a.Repo, counts["added"], b.Owner, b.Repo, counts["modified"])
+	return nil
+}
+
+// printVerify compares the remote tree at ref with the checkout in dir, and
+// fails when they differ
+func printVerify(ctx context.Context, client *ghtree.Client, paths []string, ref, dir string) error {
+	var roots []*ghtree.Node
+	for _, path := range paths {
+		root, err := client.VerifyLocal(ctx, path, ref, dir, contentsFlag)
+		if err != nil {
+			return err
+		}
+		roots = append(roots, root)
+	}
+
+	// Entries only in the checkout count as added
+	counts, err := printChanges(roots)
+	if err != nil {
+		return err
+	}
+	if formatFlag != "json" && !noSummaryFlag && verbosity >= levelNormal {
+		summary := fmt.Sprintf("%d missing locally, %d extra locally", counts["removed"], counts["added"])
+		if contentsFlag {
+			summary += fmt.Sprintf(", %d differ", counts["modified"])
+		} else if counts["modified"] > 0 {
+			summary += fmt.Sprintf(", %d of another type", counts["modified"])
+		}
+		fmt.Fprintf(out, "\n%s\n", summary)
+	}
+	if differences := counts["added"] + counts["removed"] + counts["modified"]; differences > 0 {
+		return fmt.Errorf("%s differs from the remote tree in %s", dir, plural(differences, "file", "files"))
+	}
+	return nil
+}
+
+// printChanges draws or encodes the trees of a comparison and counts the
+// files by change
+func printChanges(roots []*ghtree.Node) (map[string]int, error) {
+	var err error
+	if formatFlag == "json" {
+		err = printJSON(roots)
+	} else {
+		err = printText(roots)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	counts := map[string]int{}
+	var count func(nodes []*ghtree.Node)
+	count = func(nodes []*ghtree.Node) {
+		for _, node := range nodes {
+			if node.Type != "dir" {
+				counts[node.Change]++
+			}
+			count(node.Children)
+		}
+	}
+	for _, root := range roots {
+		count(root.Children)
+	}
+	return counts, nil
+}
+
+// printSummary prints the totals after the tree, like tree(1)'s report
+// treeTotals counts what the listed trees hold, for the summary line and
+// --json-stats
+type treeTotals struct {
+	Directories int   `json:"directories"`
+	Files       int   `json:"files"`
+	TotalSize   int64 `json:"totalSize"`
+	Omitted     int   `json:"-"`
+	Hidden      int   `json:"-"`
+}
+
+func countTotals(roots []*ghtree.Node) treeTotals {
+	var totals treeTotals
+	var count func(nodes []*ghtree.Node)
+	count = func(nodes []*ghtree.Node) {
+		for _, node := range nodes {
+			totals.Omitted += node.Omitted
+			totals.Hidden += node.Hidden
+			if node.Type == "dir" || node.Children != nil {
+				totals.Directories++
+				count(node.Children)
+				continue
+			}
+			totals.Files++
+			totals.TotalSize += node.Size
+		}
+	}
+	for _, root := range roots {
+		totals.Omitted += root.Omitted
+		totals.Hidden += root.Hidden
+		count(root.TopLevel())
+	}
+	return totals
+}
+
+func printSummary(roots []*ghtree.Node) {
+	totals := countTotals(roots)
+	fmt.Fprintf(out, "\n%s, %s, %s", plural(totals.Directories, "directory", "directories"), plural(totals.Files, "file", "files"), ghtree.HumanizeBytes(totals.TotalSize))
+	if totals.Omitted > 0 {
+		fmt.Fprintf(out, "; %s past --max-entries", plural(totals.Omitted, "entry", "entries"))
+	}
+	if totals.Hidden > 0 {
+		fmt.Fprintf(out, "; %s hidden by filters", plural(totals.Hidden, "entry", "entries"))
+	}
+	fmt.Fprintln(out)
+}
+
+func plural(n int, one, many string) string {
+	if n == 1 {
+		return "1 " + one
+	}
+	return fmt.Sprintf("%d %s", n, many)
+}
+
+func countByDepth(nodes []*ghtree.Node, level int, counts []depthCount) []depthCount {
+	for _, node := range nodes {
+		for len(counts) < level {
+			counts = append(counts, depthCount{Depth: len(counts) + 1})
+		}
+
+		if node.Type == "dir" {
+			counts[level-1].Dirs++
+			counts = countByDepth(node.Children, level+1, counts)
+		} else {
+			counts[level-1].Files++
+		}
+	}
+	return counts
+}
+
+func downloadTrees(ctx context.Context, client *ghtree.Client, roots []*ghtree.Node) error {
+	// Collect the files of every tree with their paths below its root
+	type download struct {
+		node   *ghtree.Node
+		target string
+	}
+	var downloads []download
+	var dirs []string
+	var collect func(nodes []*ghtree.Node, rootPath string)
+	collect = func(nodes []*ghtree.Node, rootPath string) {
+		for _, node := range nodes {
+			// A lone file root lands directly in the target directory
+			rel := strings.TrimPrefix(node.Path, rootPath+"/")
+			if rootPath == "" {
+				rel = node.Path
+			} else if node.Path == rootPath {
+				rel = node.Name
+			}
+			target := filepath.Join(downloadFlag, filepath.FromSlash(rel))
+
+			// A followed submodule holds files like a directory
+			kind := node.Type
+			if kind == "submodule" && node.Children != nil {
+				kind = "dir"
+			}
+			switch kind {
+			case "dir":
+				dirs = append(dirs, target)
+				collect(node.Children, rootPath)
+			case "file":
+				downloads = append(downloads, download{node: node, target: target})
+			}
+		}
+	}
+	for _, root := range roots {
+		collect(root.TopLevel(), root.Path)
+	}
+
+	// Recreate the directories, including empty ones
+	for _, dir := range dirs {
+		rel, err := filepath.Rel(downloadFlag, dir)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("refusing to create %s outside %s", dir, downloadFlag)
+		}
+		err = os.MkdirAll(dir, 0755)
+		if err != nil {
+			return fmt.Errorf("failed to create %s: %w", dir, err)
+		}
+	}
+
+	// Never write outside the target directory, nor over existing files
+	var pending []download
+	var skipped int
+	for _, d := range downloads {
+		rel, err := filepath.Rel(downloadFlag, d.target)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("refusing to download %s outside %s", d.node.Path, downloadFlag)
+		}
+		if _, err := os.Stat(d.target); err == nil && !overwriteFlag {
+			skipped++
+			continue
+		}
+		pending = append(pending, d)
+	}
+
+	// Fetch the files with as many workers as requests may be in flight,
+	// so only that many files are open at a time
+	progress := newDownloadProgress(len(pending))
+	var mu sync.Mutex
+	var files int
+	var written int64
+	var firstErr error
+	var wg sync.WaitGroup
+	queue := make(chan download)
+	for i := 0; i < concurrencyFlag; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for d := range queue {
+				n, err := downloadFile(ctx, client, d.node, d.target)
+
+				mu.Lock()
+				if err != nil && firstErr == nil {
+					firstErr = err
+				} else if err == nil {
+					files++
+					written += n
+					progress.update(files, written)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	// Stop handing out files after the first failure
+	for _, d := range pending {
+		mu.Lock()
+		failed := firstErr != nil
+		mu.Unlock()
+		if failed {
+			break
+		}
+		queue <- d
+	}
+	close(queue)
+	wg.Wait()
+	progress.done()
+
+	if firstErr != nil {
+		return firstErr
+	}
+
+	infof("downloaded %s (%s) to %s", plural(files, "file", "files"), ghtree.HumanizeBytes(written), downloadFlag)
+	if skipped > 0 {
+		infof("skipped %s that already exist; pass --overwrite to replace them", plural(skipped, "file", "files"))
+	}
+	return nil
+}
+
+// walkProgress keeps a live line on stderr while the tree is walked: the
+// directories listed, the requests made, the rate limit left and the time
+// taken. It is off unless stderr is a terminal.
+type walkProgress struct {
+	enabled bool
+	dirs    atomic.Int64
+	quit    chan struct{}
+	exited  chan struct{}
+}
+
+// progressMu keeps the progress line and warnings from interleaving
 var (
-	ownerFlag    string
-	repoFlag     string
-	pathFlag     string
-	maxDepthFlag int
+	progressMu    sync.Mutex
+	progressShown bool
 )
 
-type File struct {
-	Name string `json:"name"`
-	Type string `json:"type"`
+func newWalkProgress() *walkProgress {
+	info, err := os.Stderr.Stat()
+	enabled := err == nil && info.Mode()&os.ModeCharDevice != 0 && verbosity == levelNormal && !noProgressFlag
+	return &walkProgress{enabled: enabled}
 }
 
-func init() {
-	flag.StringVar(&ownerFlag, "O", "", "Repository owner")
-	flag.StringVar(&ownerFlag, "owner", "", "Repository owner")
+// start redraws the line until stop is called
+func (p *walkProgress) start(client *ghtree.Client) {
+	if !p.enabled {
+		return
+	}
+	p.quit, p.exited = make(chan struct{}), make(chan struct{})
+	began := time.Now()
 
-	flag.StringVar(&repoFlag, "R", "", "Repository name")
-	flag.StringVar(&repoFlag, "repo", "", "Repository name")
+	go func() {
+		defer close(p.exited)
+		ticker := time.NewTicker(200 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-p.quit:
+				progressMu.Lock()
+				clearProgressLine()
+				progressMu.Unlock()
+				return
+			case <-ticker.C:
+			}
 
-	flag.StringVar(&pathFlag, "P", "", "Path within the repository")
-	flag.StringVar(&pathFlag, "path", "", "Path within the repository")
+			stats := client.Stats()
+			line := fmt.Sprintf("listing: %s, %s", plural(int(p.dirs.Load()), "directory", "directories"), plural(stats.Requests, "request", "requests"))
+			if stats.RateLimit > 0 {
+				line += fmt.Sprintf(", %d of %d remaining", stats.RateLimitRemaining, stats.RateLimit)
+			}
+			line += ", " + time.Since(began).Round(time.Second).String()
 
-	flag.IntVar(&maxDepthFlag, "M", 1, "Maximum depth for fetching content")
-	flag.IntVar(&maxDepthFlag, "maxDepth", 1, "Maximum depth for fetching content")
+			progressMu.Lock()
+			fmt.Fprintf(os.Stderr, "\r%s\x1b[K", line)
+			progressShown = true
+			progressMu.Unlock()
+		}
+	}()
 }
 
-func main() {
+// stop clears the line so the tree and any errors start on a clean one
+func (p *walkProgress) stop() {
+	if p.quit == nil {
+		return
+	}
+	close(p.quit)
+	<-p.exited
+	p.quit = nil
+}
 
-	// Parse command-line flags
-	flag.Parse()
+// clearProgressLine erases the progress line, if one is showing; the
+// caller holds progressMu
+func clearProgressLine() {
+	if progressShown {
+		fmt.Fprint(os.Stderr, "\r\x1b[K")
+		progressShown = false
+	}
+}
 
-	// Get the absolute path to github-tree-inputs.txt
-	inputsFilePath := getAbsolutePath("github-tree-inputs.txt")
+// downloadProgress keeps a one-line count of finished downloads on stderr
+// while they run, when stderr is a terminal
+type downloadProgress struct {
+	total   int
+	enabled bool
+}
 
-	// Check if github-tree-inputs.txt exists
-	_, err := os.Stat(inputsFilePath)
+func newDownloadProgress(total int) *downloadProgress {
+	info, err := os.Stderr.Stat()
+	enabled := err == nil && info.Mode()&os.ModeCharDevice != 0 && verbosity >= levelNormal
+	return &downloadProgress{total: total, enabled: enabled}
+}
 
-	if err == nil {
-		// The file exists, so read existing inputs from the file
-		currentOwner, currentRepo, currentPath, currentMaxDepth := readInputsFromFile(inputsFilePath)
+func (p *downloadProgress) update(files int, written int64) {
+	if p.enabled {
+		fmt.Fprintf(os.Stderr, "\rdownloading: %d of %d files (%s)\x1b[K", files, p.total, ghtree.HumanizeBytes(written))
+	}
+}
 
-		// Check if the owner and repo fields are empty
-		if currentOwner == "" || currentRepo == "" {
-			panic("The 'owner' and 'repo' fields in github-tree-inputs.txt cannot be empty")
-		}
+// done clears the progress line before the closing summary
+func (p *downloadProgress) done() {
+	if p.enabled {
+		fmt.Fprint(os.Stderr, "\r\x1b[K")
+	}
+}
+
+func downloadFile(ctx context.Context, client *ghtree.Client, node *ghtree.Node, target string) (int64, error) {
+	err := os.MkdirAll(filepath.Dir(target), 0755)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create directory for %s: %w", target, err)
+	}
+
+	f, err := os.Create(target)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create %s: %w", target, err)
+	}
 
-		// Set default value for maxDepth if not available
-		if currentMaxDepth < 1 {
-			currentMaxDepth = 1
+	// Don't leave a partial or corrupted file behind
+	n, err := downloadVerified(ctx, client, node, f, func() error {
+		_, err := f.Seek(0, io.SeekStart)
+		if err == nil {
+			err = f.Truncate(0)
 		}
+		return err
+	})
+	if closeErr := f.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("failed to write %s: %w", target, closeErr)
+	}
+	if err != nil {
+		os.Remove(target)
+		return 0, err
+	}
+	return n, nil
+}
 
-		// Update inputs if flags were provided
-		if ownerFlag != "" {
-			currentOwner = ownerFlag
+// downloadVerified downloads node to w. Contents that don't match the blob
+// SHA of the listing are fetched again, after restart empties w, up to
+// --retries times before the mismatch is returned.
+func downloadVerified(ctx context.Context, client *ghtree.Client, node *ghtree.Node, w io.Writer, restart func() error) (int64, error) {
+	for attempt := 0; ; attempt++ {
+		n, err := client.Download(ctx, node, w)
+		var integrityErr *ghtree.IntegrityError
+		if !errors.As(err, &integrityErr) || attempt >= retriesFlag {
+			return n, err
 		}
-		if repoFlag != "" {
-			currentRepo = repoFlag
+		warnf("%v; downloading it again", err)
+		err = restart()
+		if err != nil {
+			return 0, fmt.Errorf("failed to restart %s: %w", node.Path, err)
 		}
-		if pathFlag != "" {
-			currentPath = pathFlag
+	}
+}
+
+// describeError adds command-line hints to errors from the GitHub client.
+// tokenSource says where the token came from; empty means there was none.
+func describeError(err error, tokenSource string) error {
+	var rateErr *ghtree.RateLimitError
+	if errors.As(err, &rateErr) && rateErr.MaxWait > 0 {
+		return fmt.Errorf("%w (raise --max-wait to wait longer)", err)
+	}
+	if errors.As(err, &rateErr) {
+		return fmt.Errorf("%w (use --wait-on-rate-limit to wait)", err)
+	}
+
+	// Say where a token was looked for when GitHub wanted one, and which
+	// one it turned down
+	var apiErr *ghtree.APIError
+	if errors.As(err, &apiErr) {
+		switch {
+		case tokenSource == "" && (apiErr.StatusCode == http.StatusUnauthorized || apiErr.StatusCode == http.StatusForbidden || apiErr.StatusCode == http.StatusNotFound):
+			return fmt.Errorf("%w (no token found; checked %s)", err, tokenSearchOrder(providerFlag))
+		case tokenSource != "" && apiErr.StatusCode == http.StatusUnauthorized:
+			return fmt.Errorf("%w (the token from %s was rejected)", err, tokenSource)
 		}
-		currentMaxDepth = maxDepthFlag
-		
+	}
+	return err
+}
+
+// tokenEnvVars are read for a token in this order; GITHUB_ACCESS_TOKEN
+// comes first because older versions read only that one
+var tokenEnvVars = []string{"GITHUB_ACCESS_TOKEN", "GH_TOKEN", "GITHUB_TOKEN"}
 
-		// Update the inputs in the file
-		updateInputsInFile(inputsFilePath, currentOwner, currentRepo, currentPath, currentMaxDepth)
+// tokenSearchOrder describes where resolveToken looks for provider's token,
+// for error messages
+func tokenSearchOrder(provider string) string {
+	if f, ok := forges[provider]; ok {
+		return "--token, --token-file, then " + f.tokenEnv
+	}
+	return "--token, --token-file, GITHUB_ACCESS_TOKEN, GH_TOKEN, GITHUB_TOKEN, then the gh CLI login"
+}
+
+// resolveToken finds the access token, in order of precedence: the --token
+// and --token-file flags, the environment, the token the gh CLI stored for
+// the API's host in hosts.yml, then whatever "gh auth token" reports, which
+// covers tokens gh keeps in the system keyring. Other forges have a token
+// variable of their own instead, so a GitHub token never leaves for them.
+// It also returns where the token came from; an empty token means
+// anonymous requests.
+func resolveToken(provider, apiBase string) (token, source string, err error) {
+	if tokenFlag != "" {
+		return tokenFlag, "--token", nil
+	}
 
-		// Retrieve access token from environment
-		accessToken := os.Getenv("GITHUB_ACCESS_TOKEN")
-		if accessToken == "" {
-			panic("GitHub access token not found in environment")
+	if tokenFileFlag != "" {
+		data, err := os.ReadFile(tokenFileFlag)
+		if err != nil {
+			return "", "", fmt.Errorf("failed to read token file: %w", err)
+		}
+		token := strings.TrimSpace(string(data))
+		if token == "" {
+			return "", "", fmt.Errorf("token file %s is empty", tokenFileFlag)
 		}
+		return token, tokenFileFlag, nil
+	}
 
-		// Fetch files and folders using the updated inputs
-		fetchFilesAndFolders(accessToken, currentOwner, currentRepo, currentPath, "", 1, currentMaxDepth)
-	} else {
-		// The "github-tree-inputs.txt" doesn't exist, so create it
+	if f, ok := forges[provider]; ok {
+		if token := os.Getenv(f.tokenEnv); token != "" {
+			return token, f.tokenEnv, nil
+		}
+		return "", "", nil
+	}
 
-		// Set default value for maxDepth if not available
-		if maxDepthFlag == 0 {
-			maxDepthFlag = 1
+	for _, name := range tokenEnvVars {
+		if token := os.Getenv(name); token != "" {
+			return token, name, nil
 		}
+	}
 
-		// Create a new inputs struct
-		newInputs := struct {
-			Owner    string `json:"owner"`
-			Repo     string `json:"repo"`
-			Path     string `json:"path"`
-			MaxDepth int    `json:"maxDepth"`
-		}{
-			Owner:    ownerFlag,
-			Repo:     repoFlag,
-			Path:     pathFlag,
-			MaxDepth: maxDepthFlag,
+	host := apiHost(apiBase)
+	if token := readGHToken(host); token != "" {
+		return token, "the gh CLI login", nil
+	}
+	if token := ghAuthToken(host); token != "" {
+		return token, "gh auth token", nil
+	}
+	return "", "", nil
+}
+
+// normalizeAPIURL checks an API base and completes the bare server URLs
+// people tend to give: github.com means its API host, and a GitHub
+// Enterprise Server without a path serves its API under /api/v3.
+func normalizeAPIURL(apiBase string) (string, error) {
+	if apiBase == ghtree.DefaultAPIURL {
+		return apiBase, nil
+	}
+
+	u, err := url.Parse(apiBase)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("invalid API URL %q: expected something like https://github.example.com/api/v3", apiBase)
+	}
+	switch {
+	case u.Host == "github.com" || u.Host == "www.github.com":
+		return ghtree.DefaultAPIURL, nil
+	case strings.Trim(u.Path, "/") == "":
+		u.Path = "/api/v3"
+		return u.String(), nil
+	}
+	return apiBase, nil
+}
+
+// newTransport returns the HTTP transport for API requests and downloads. It
+// goes through HTTPS_PROXY like the default one, and additionally trusts the
+// certificates in caFile, or none at all when insecure is set.
+func newTransport(caFile string, insecure bool) (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyFromEnvironment
+	if caFile == "" && !insecure {
+		return transport, nil
+	}
+
+	config := &tls.Config{}
+	if caFile != "" {
+		data, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the CA certificates: %w", err)
 		}
 
-		// Convert to JSON
-		newInputsJSON, err := json.MarshalIndent(newInputs, "", "  ")
+		// Keep trusting the system roots; the file only adds to them
+		pool, err := x509.SystemCertPool()
 		if err != nil {
-			panic(fmt.Errorf("failed to marshal new inputs: %w", err))
+			pool = x509.NewCertPool()
 		}
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, &usageError{msg: fmt.Sprintf("no PEM certificates found in %s", caFile)}
+		}
+		config.RootCAs = pool
+	}
+	if insecure {
+		warnf("--insecure is set; TLS certificates are not verified")
+		config.InsecureSkipVerify = true
+	}
+	transport.TLSClientConfig = config
+	return transport, nil
+}
 
-		// Write to the file
-		err = os.WriteFile(inputsFilePath, newInputsJSON, 0644)
+// resolveApp reads the GitHub App credentials from the flags, or else the
+// environment. It returns nil when no App ID is given.
+func resolveApp() (*ghtree.App, error) {
+	app := &ghtree.App{ID: appIDFlag, InstallationID: appInstallFlag}
+	var err error
+	if app.ID == 0 && os.Getenv("GITHUB_APP_ID") != "" {
+		app.ID, err = strconv.ParseInt(os.Getenv("GITHUB_APP_ID"), 10, 64)
 		if err != nil {
-			panic(fmt.Errorf("failed to write new inputs to file: %w", err))
+			return nil, &usageError{msg: fmt.Sprintf("invalid GITHUB_APP_ID %q", os.Getenv("GITHUB_APP_ID"))}
 		}
+	}
+	if app.ID == 0 {
+		return nil, nil
+	}
 
-		// Retrieve access token from environment
-		accessToken := os.Getenv("GITHUB_ACCESS_TOKEN")
-		if accessToken == "" {
-			panic("GitHub access token not found in environment")
+	if app.InstallationID == 0 && os.Getenv("GITHUB_APP_INSTALLATION_ID") != "" {
+		app.InstallationID, err = strconv.ParseInt(os.Getenv("GITHUB_APP_INSTALLATION_ID"), 10, 64)
+		if err != nil {
+			return nil, &usageError{msg: fmt.Sprintf("invalid GITHUB_APP_INSTALLATION_ID %q", os.Getenv("GITHUB_APP_INSTALLATION_ID"))}
 		}
+	}
+	if app.InstallationID == 0 {
+		return nil, &usageError{msg: "a GitHub App needs --app-installation-id or GITHUB_APP_INSTALLATION_ID"}
+	}
 
-		// Fetch files and folders using the new inputs
-		fetchFilesAndFolders(accessToken, ownerFlag, repoFlag, pathFlag, "", 1, maxDepthFlag)
+	// The key comes from a file, or inline from the environment
+	var key []byte
+	switch {
+	case appKeyFileFlag != "":
+		key, err = os.ReadFile(appKeyFileFlag)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the GitHub App key: %w", err)
+		}
+	case os.Getenv("GITHUB_APP_PRIVATE_KEY") != "":
+		key = []byte(os.Getenv("GITHUB_APP_PRIVATE_KEY"))
+	default:
+		return nil, &usageError{msg: "a GitHub App needs --app-key-file or GITHUB_APP_PRIVATE_KEY"}
+	}
+	app.PrivateKey, err = ghtree.ParsePrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("GitHub App key: %w", err)
 	}
+	return app, nil
 }
 
-func readInputsFromFile(filePath string) (owner, repo, path string, maxDepth int) {
-	// Read the contents of the file
-	fileData, err := os.ReadFile(filePath)
+// apiHost returns the GitHub host an API base belongs to, the host gh keys
+// its logins by
+func apiHost(apiBase string) string {
+	u, err := url.Parse(apiBase)
 	if err != nil {
-		panic(fmt.Errorf("failed to read inputs from file: %w", err))
+		return ""
+	}
+	if u.Host == "api.github.com" {
+		return "github.com"
 	}
+	return u.Host
+}
 
-	// Unmarshal the JSON data into a struct
-	var inputs struct {
-		Owner    string `json:"owner"`
-		Repo     string `json:"repo"`
-		Path     string `json:"path"`
-		MaxDepth int    `json:"maxDepth"`
+// readGHToken returns the oauth_token gh keeps for host in hosts.yml, or ""
+// if there is none. Newer gh versions keep tokens in the system keyring,
+// which this does not read.
+func readGHToken(host string) string {
+	configDir := os.Getenv("GH_CONFIG_DIR")
+	if configDir == "" {
+		if xdg := os.Getenv("XDG_CONFIG_HOME"); xdg != "" {
+			configDir = filepath.Join(xdg, "gh")
+		} else if home, err := os.UserHomeDir(); err == nil {
+			configDir = filepath.Join(home, ".config", "gh")
+		}
 	}
-	err = json.Unmarshal(fileData, &inputs)
+	if host == "" || configDir == "" {
+		return ""
+	}
+
+	data, err := os.ReadFile(filepath.Join(configDir, "hosts.yml"))
 	if err != nil {
-		panic(fmt.Errorf("failed to parse inputs from file: %w", err))
+		return ""
 	}
 
-	return inputs.Owner, inputs.Repo, inputs.Path, inputs.MaxDepth
+	// hosts.yml maps each host to an indented block of settings:
+	//   github.com:
+	//       oauth_token: gho_...
+	inHost := false
+	for _, line := range strings.Split(string(data), "\n") {
+		if line == "" {
+			continue
+		}
+		if line[0] != ' ' && line[0] != '\t' {
+			inHost = strings.TrimSpace(line) == host+":"
+			continue
+		}
+		key, value, found := strings.Cut(strings.TrimSpace(line), ":")
+		if inHost && found && key == "oauth_token" {
+			return strings.Trim(strings.TrimSpace(value), `"'`)
+		}
+	}
+	return ""
 }
 
-func getAbsolutePath(filePath string) string {
-	currentDir, err := os.Getwd()
+// ghAuthToken asks the gh CLI for its token for host, which it may keep in
+// the system keyring. It returns "" if gh isn't installed or isn't logged in.
+func ghAuthToken(host string) string {
+	if host == "" {
+		return ""
+	}
+	path, err := exec.LookPath("gh")
 	if err != nil {
-		panic(fmt.Errorf("failed to get current directory: %w", err))
+		return ""
 	}
 
-	return filepath.Join(currentDir, filePath)
+	// gh may prompt to unlock the keyring; don't wait on it forever
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	output, err := exec.CommandContext(ctx, path, "auth", "token", "--hostname", host).Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(output))
 }
 
-func fetchFilesAndFolders(accessToken, owner, repo, path, indent string, level, maxDepth int) {
-	// Stop if the maximum depth has been reached
-	if level > maxDepth {
-		return
+// logLevel selects which diagnostics reach stderr
+type logLevel int
+
+const (
+	levelQuiet logLevel = iota
+	levelNormal
+	levelVerbose
+	levelTrace
+)
+
+// verbosity is the current log level, set by --quiet, -v and -vv
+var verbosity = levelNormal
+
+// warnf reports a non-fatal problem unless --quiet is set
+func warnf(format string, args ...interface{}) {
+	if verbosity >= levelNormal {
+		writeLog("warning", fmt.Sprintf(format, args...), nil)
 	}
+}
 
-	// Make the API request
-	url := fmt.Sprintf("https://api.github.com/repos/%s/%s/contents/%s", owner, repo, path)
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		panic(err)
+// infof reports progress unless --quiet is set
+func infof(format string, args ...interface{}) {
+	if verbosity >= levelNormal {
+		writeLog("info", fmt.Sprintf(format, args...), nil)
 	}
-	req.Header.Set("Authorization", "Bearer "+accessToken)
+}
 
-	client := http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		panic(err)
+// debugf traces what the tool is doing with -v
+func debugf(format string, args ...interface{}) {
+	if verbosity >= levelVerbose {
+		writeLog("debug", fmt.Sprintf(format, args...), nil)
+	}
+}
+
+// logEntry logs a line of the client's at the level it asks for: warnings
+// unless --quiet is set, requests with -vv, and the rest with -v
+func logEntry(entry ghtree.LogEntry) {
+	level := levelVerbose
+	switch entry.Level {
+	case "warning":
+		level = levelNormal
+	case "trace":
+		level = levelTrace
 	}
-	defer resp.Body.Close()
+	if verbosity >= level {
+		writeLog(entry.Level, entry.Message, entry.Fields)
+	}
+}
 
-	// Read the response body
-	body, err := io.ReadAll(resp.Body)
+// logPrefixes start the text lines of each log level
+var logPrefixes = map[string]string{
+	"error":   "error: ",
+	"warning": "warning: ",
+	"debug":   "debug: ",
+	"trace":   "debug: ",
+}
+
+// writeLog prints a line of diagnostics to stderr: as text after the prefix
+// of its level, or with --log-format json as an object holding the time,
+// level and message along with any details
+func writeLog(level, msg string, fields map[string]interface{}) {
+	progressMu.Lock()
+	defer progressMu.Unlock()
+	clearProgressLine()
+
+	if logFormatFlag != "json" {
+		fmt.Fprintln(os.Stderr, logPrefixes[level]+msg)
+		return
+	}
+	line := map[string]interface{}{}
+	for key, value := range fields {
+		line[key] = value
+	}
+	line["time"] = time.Now().Format(time.RFC3339Nano)
+	line["level"] = level
+	line["msg"] = msg
+	data, err := json.Marshal(line)
 	if err != nil {
-		panic(err)
+		data, _ = json.Marshal(map[string]string{"level": level, "msg": msg})
+	}
+	fmt.Fprintln(os.Stderr, string(data))
+}
+
+func curlCommand(req *http.Request, includeToken bool) string {
+	// Sort header names so the command is stable across runs
+	names := make([]string, 0, len(req.Header))
+	for name := range req.Header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	parts := []string{"curl", "-X", req.Method}
+	for _, name := range names {
+		value := req.Header.Get(name)
+		if name == "Authorization" && !includeToken {
+			value = "Bearer <redacted>"
+		}
+		parts = append(parts, "-H", shellQuote(name+": "+value))
 	}
+	parts = append(parts, shellQuote(req.URL.String()))
 
-	// Unmarshal the response into a slice of File structs
-	var files []File
-	err = json.Unmarshal(body, &files)
+	return strings.Join(parts, " ")
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func preflight(ctx context.Context, client *ghtree.Client, owner, repo string) error {
+	info, err := client.Repository(ctx)
+
+	// GitHub hides repositories a token cannot see behind a 404
+	var apiErr *ghtree.APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.StatusCode {
+		case http.StatusUnauthorized:
+			return errors.New("preflight: the GitHub access token is invalid or expired")
+		case http.StatusForbidden, http.StatusNotFound:
+			// Ask what the token is to say why it can't see the repository
+			info, _ := client.TokenInfo(ctx)
+			return fmt.Errorf("preflight: %w", explainAccess(err, info, owner, repo))
+		default:
+			return fmt.Errorf("preflight: unexpected status %s checking %s/%s", apiErr.Status, owner, repo)
+		}
+	}
 	if err != nil {
-		panic(err)
+		return fmt.Errorf("preflight: %w", err)
+	}
+
+	if !info.CanPull {
+		warnf("the token does not have read permission on %s/%s", owner, repo)
 	}
 
-	// Iterate over the files and folders
-	for i, f := range files {
-		isLast := i == len(files)-1
-		if f.Type == "file" {
-			fmt.Printf("%s%s", indent, getFilePrefix(isLast))
-			fmt.Println(f.Name)
-		} else if f.Type == "dir" {
-			fmt.Printf("%s%s", indent, getDirPrefix(isLast))
-			fmt.Println(f.Name)
-			// Recursively fetch files and folders for subdirectory
-			fetchFilesAndFolders(accessToken, owner, repo, path+"/"+f.Name, indent+getIndentPrefix(isLast), level+1, maxDepth)
+	// Classic tokens report their scopes; private repositories need "repo"
+	if info.Scopes != nil && info.Private {
+		for _, scope := range info.Scopes {
+			if scope == "repo" {
+				return nil
+			}
 		}
+		warnf("the token's scopes (%s) do not include repo, which private repositories need", strings.Join(info.Scopes, ", "))
+	}
+	return nil
+}
+
+func listRefs(ctx context.Context, client *ghtree.Client) error {
+	refs, err := client.ListRefs(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, ref := range refs {
+		fmt.Fprintln(out, ref)
 	}
+	return nil
 }
 
-func getFilePrefix(isLast bool) string {
-	if isLast {
-		return "└── "
+// listRepos prints the repositories of owner, or those the token's user can
+// see if owner is empty, as owner/name
+func listRepos(ctx context.Context, client *ghtree.Client, owner string) error {
+	repos, err := client.ListRepositories(ctx, owner)
+	if err != nil {
+		return err
+	}
+
+	for _, repo := range repos {
+		fmt.Fprintln(out, repo.Owner+"/"+repo.Name)
 	}
-	return "├── "
+	return nil
 }
 
-func getDirPrefix(isLast bool) string {
-	if isLast {
-		return "└── "
+// streamPaths prints the paths of entries as the breadth-first walk lists
+// each level, in place of the text tree or --format paths
+var streamPaths bool
+
+// streamedTree says the text tree was drawn while the walk listed it, as
+// canStreamTree allows
+var streamedTree bool
+
+// canStreamTree reports whether the text tree can be drawn as the walk
+// lists it instead of once all of it is in memory: a single tree is drawn
+// in text, as it was listed. Whatever needs the whole tree first forces
+// buffering: other formats and commands, several paths or repositories,
+// entry labels and previews, LFS markers, directory sizes, followed
+// submodules, and the include filters and size bounds, which prune
+// directories left empty.
+func canStreamTree(command string, paths []string, severalRepos bool, v flagValues) bool {
+	switch {
+	case command != "show" && command != "download":
+		return false
+	case formatFlag != "text" || traversalFlag == "bfs" || v.entryTemplate != nil || v.searchMatch != nil || statsFlag || duFlag:
+		return false
+	case len(paths) != 1 || severalRepos || interactiveFlag || watchFlag || listRefsFlag || listReposFlag || dryRunFlag:
+		return false
+	case baseFlag != "" || diffRepoFlag != "" || verifyFlag || snapshotFlag || sinceSnapshotFlag != "":
+		return false
+	case ownersFlag || lastCommitFlag || showSHAFlag || hyperlinksFlag || previewFlag != "" || lfsFlag || sizesFlag || followSubsFlag:
+		return false
+	case len(includeFlag) > 0 || len(includeExtFlag) > 0 || v.minSize > 0 || v.maxSize > 0:
+		return false
 	}
-	return "├── "
+	return true
 }
 
-func getIndentPrefix(isLast bool) string {
-	if isLast {
-		return "    "
+// progressClearingWriter writes to out, clearing the progress line first,
+// for output written while the walk runs
+type progressClearingWriter struct{}
+
+func (progressClearingWriter) Write(p []byte) (int, error) {
+	progressMu.Lock()
+	clearProgressLine()
+	progressMu.Unlock()
+	return out.Write(p)
+}
+
+// pathsMu serializes the lines streamPaths writes
+var pathsMu sync.Mutex
+
+// pathWriter returns the Entry callback of streamPaths. Paths are relative
+// to a single requested path, as --format paths prints them; with several,
+// they are full. Directories get a trailing slash.
+func pathWriter(paths []string) func(node *ghtree.Node, depth int) error {
+	prefix := ""
+	if len(paths) == 1 && paths[0] != "" {
+		prefix = paths[0] + "/"
 	}
-	return "│   "
+	return func(node *ghtree.Node, depth int) error {
+		if typeFlag != "" && node.Type != typeFlag {
+			return nil
+		}
+		path := strings.TrimPrefix(node.Path, prefix)
+		if node.Type == "dir" {
+			path += "/"
+		}
+		return writePath(path)
+	}
+}
+
+// writePath prints one line of streamPaths, clearing the progress line
+// first
+func writePath(path string) error {
+	pathsMu.Lock()
+	defer pathsMu.Unlock()
+	progressMu.Lock()
+	clearProgressLine()
+	progressMu.Unlock()
+	_, err := fmt.Fprint(out, path, pathEnd())
+	return err
+}
+
+// jsonlEntry is one line of --format jsonl
+type jsonlEntry struct {
+	Path  string `json:"path"`
+	Type  string `json:"type"`
+	Size  int64  `json:"size"`
+	SHA   string `json:"sha"`
+	Depth int    `json:"depth"`
+}
+
+// writeJSONLEntry writes one entry of --format jsonl as soon as the walk
+// finds it, so the lines stream out in discovery order
+func writeJSONLEntry(node *ghtree.Node, depth int) error {
+	jsonlMu.Lock()
+	defer jsonlMu.Unlock()
+	return json.NewEncoder(out).Encode(jsonlEntry{Path: node.Path, Type: node.Type, Size: node.Size, SHA: node.SHA, Depth: depth})
 }
 
-func updateInputsInFile(filePath, owner, repo, path string, maxDepth int) {
-	// Create the new inputs struct
-	newInputs := struct {
-		Owner    string `json:"owner"`
-		Repo     string `json:"repo"`
-		Path     string `json:"path"`
-		MaxDepth int    `json:"maxDepth"`
-	}{
-		Owner:    owner,
-		Repo:     repo,
-		Path:     path,
-		MaxDepth: maxDepth,
+func writeZipListing(path string, files []ghtree.File) error {
+	// Each listing lives at <dir>/index.txt, mirroring the repository layout
+	name := "index.txt"
+	if dir := strings.Trim(path, "/"); dir != "" {
+		name = dir + "/index.txt"
+	}
+
+	// Directories are listed concurrently, but the archive takes one file at a time
+	zipMu.Lock()
+	defer zipMu.Unlock()
+
+	w, err := zipWriter.Create(name)
+	if err != nil {
+		return fmt.Errorf("failed to add %s to zip archive: %w", name, err)
+	}
+
+	// List one entry per line, marking directories with a trailing slash
+	for _, f := range files {
+		entry := f.Name
+		if f.Type == "dir" {
+			entry += "/"
+		}
+		_, err = fmt.Fprintln(w, entry)
+		if err != nil {
+			return fmt.Errorf("failed to write %s to zip archive: %w", name, err)
+		}
 	}
+	return nil
+}
 
+func updateInputsInFile(filePath string, newInputs Inputs) error {
 	// Convert to JSON
 	newInputsJSON, err := json.MarshalIndent(newInputs, "", "  ")
 	if err != nil {
-		panic(fmt.Errorf("failed to marshal new inputs: %w", err))
+		return fmt.Errorf("failed to marshal new inputs: %w", err)
 	}
 
-	// Write to the file
+	// Write to the file, creating its directory on first use
+	err = os.MkdirAll(filepath.Dir(filePath), 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create the inputs directory: %w", err)
+	}
 	err = os.WriteFile(filePath, newInputsJSON, 0644)
 	if err != nil {
-		panic(fmt.Errorf("failed to write updated inputs to file: %w", err))
+		return fmt.Errorf("failed to write updated inputs to file: %w", err)
+	}
+	return nil
+}
+
+// newlineTrimmer passes writes through but holds back a trailing newline
+// until more output follows, so the final newline is never written.
+type newlineTrimmer struct {
+	w       io.Writer
+	pending bool
+}
+
+func (t *newlineTrimmer) Write(p []byte) (int, error) {
+	n := len(p)
+	if n == 0 {
+		return 0, nil
+	}
+
+	// More output arrived, so the held newline was not the last one
+	if t.pending {
+		if _, err := t.w.Write([]byte{'\n'}); err != nil {
+			return 0, err
+		}
+		t.pending = false
+	}
+
+	if p[n-1] == '\n' {
+		p = p[:n-1]
+		t.pending = true
+	}
+	if _, err := t.w.Write(p); err != nil {
+		return 0, err
 	}
+	return n, nil
 }
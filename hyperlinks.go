@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/sbdtu5498/github-tree/pkg/ghtree"
+)
+
+// entryLinks links the entries of the text tree to their pages on GitHub at
+// ref, or with --local to the files themselves. Entries a snapshot
+// comparison marks removed have no page left, and get no link.
+func entryLinks(client *ghtree.Client, ref string) (func(node *ghtree.Node) string, error) {
+	if localFlag == "" {
+		return func(node *ghtree.Node) string {
+			if node.Change == "removed" {
+				return ""
+			}
+			return client.WebURL(ref, node)
+		}, nil
+	}
+
+	dir, err := filepath.Abs(localFlag)
+	if err != nil {
+		return nil, err
+	}
+	return func(node *ghtree.Node) string {
+		path := filepath.ToSlash(filepath.Join(dir, filepath.FromSlash(node.Path)))
+		if !strings.HasPrefix(path, "/") {
+			// Windows drive letters follow the slash of the empty host
+			path = "/" + path
+		}
+		return (&url.URL{Scheme: "file", Path: path}).String()
+	}, nil
+}
+
+// relinkEntries points the pages the listing reported for the entries
+// below roots at the repository --display-name shows instead
+func relinkEntries(client *ghtree.Client, ref string, roots []*ghtree.Node) {
+	var walk func(nodes []*ghtree.Node)
+	walk = func(nodes []*ghtree.Node) {
+		for _, node := range nodes {
+			if node.HTMLURL != "" {
+				node.HTMLURL = client.WebURL(ref, node)
+			}
+			walk(node.Children)
+		}
+	}
+	for _, root := range roots {
+		walk(root.TopLevel())
+	}
+}
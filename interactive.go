@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/sbdtu5498/github-tree/pkg/ghtree"
+)
+
+// browserHelp lists the commands of an --interactive session
+const browserHelp = `commands:
+  <n>      expand or collapse directory n
+  o <n>    open entry n on GitHub
+  c <n>    copy the path of entry n to the clipboard
+  /<text>  show only loaded entries whose path contains text
+  /        clear the search
+  ?        show this help
+  q        quit`
+
+// browser is the state of an --interactive session. Directories are listed
+// one level at a time, when they are first expanded.
+type browser struct {
+	ctx      context.Context
+	client   *ghtree.Client
+	root     *ghtree.Node
+	expanded map[*ghtree.Node]bool
+	search   string
+
+	// visible holds the entries of the last view, numbered from 1
+	visible []*ghtree.Node
+}
+
+// browse runs an interactive session on path, reading commands from stdin
+func browse(ctx context.Context, client *ghtree.Client, path string) error {
+	root, err := client.Fetch(ctx, path)
+	if err != nil {
+		return err
+	}
+	b := &browser{ctx: ctx, client: client, root: root, expanded: map[*ghtree.Node]bool{}}
+
+	// Read stdin on its own so an interrupt doesn't wait for a line
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+
+	b.draw()
+	for {
+		fmt.Fprint(out, "> ")
+		var line string
+		var ok bool
+		select {
+		case line, ok = <-lines:
+		case <-ctx.Done():
+			fmt.Fprintln(out)
+			return nil
+		}
+		if !ok {
+			fmt.Fprintln(out)
+			return nil
+		}
+
+		quit, err := b.handle(strings.TrimSpace(line))
+		if err != nil {
+			fmt.Fprintf(out, "error: %v\n", err)
+			continue
+		}
+		if quit {
+			return nil
+		}
+	}
+}
+
+// handle runs one command and reports whether the session is over
+func (b *browser) handle(line string) (bool, error) {
+	switch {
+	case line == "":
+		b.draw()
+	case line == "q" || line == "quit":
+		return true, nil
+	case line == "?" || line == "help":
+		fmt.Fprintln(out, browserHelp)
+	case strings.HasPrefix(line, "/"):
+		b.search = strings.ToLower(strings.TrimPrefix(line, "/"))
+		b.draw()
+	case strings.HasPrefix(line, "o "):
+		node, err := b.entry(strings.TrimPrefix(line, "o "))
+		if err != nil {
+			return false, err
+		}
+		if node.HTMLURL == "" {
+			return false, fmt.Errorf("GitHub reported no page for %s", node.Path)
+		}
+		return false, openBrowser(node.HTMLURL)
+	case strings.HasPrefix(line, "c "):
+		node, err := b.entry(strings.TrimPrefix(line, "c "))
+		if err != nil {
+			return false, err
+		}
+		copyToClipboard(node.Path)
+		fmt.Fprintf(out, "copied %s\n", node.Path)
+	default:
+		node, err := b.entry(line)
+		if err != nil {
+			return false, err
+		}
+		if node.Type != "dir" {
+			fmt.Fprintln(out, node.Path)
+			return false, nil
+		}
+		err = b.toggle(node)
+		if err != nil {
+			return false, err
+		}
+		b.draw()
+	}
+	return false, nil
+}
+
+// entry looks up an entry of the last view by its number
+func (b *browser) entry(arg string) (*ghtree.Node, error) {
+	n, err := strconv.Atoi(strings.TrimSpace(arg))
+	if err != nil || n < 1 || n > len(b.visible) {
+		return nil, fmt.Errorf("no entry %q; type ? for help", arg)
+	}
+	return b.visible[n-1], nil
+}
+
+// toggle expands or collapses dir, listing it first if it never was
+func (b *browser) toggle(dir *ghtree.Node) error {
+	if b.expanded[dir] {
+		delete(b.expanded, dir)
+		return nil
+	}
+
+	if dir.Children == nil && dir.Omitted == 0 {
+		listed, err := b.client.Fetch(b.ctx, dir.Path)
+		if err != nil {
+			return err
+		}
+		dir.Children = listed.Children
+		dir.Omitted = listed.Omitted
+	}
+	b.expanded[dir] = true
+	return nil
+}
+
+// draw prints the current view: the expanded tree, or the loaded entries
+// matching the search
+func (b *browser) draw() {
+	b.visible = b.visible[:0]
+	if b.search != "" {
+		b.collectMatches(b.root.TopLevel())
+		for i, node := range b.visible {
+			fmt.Fprintf(out, "%4d  %s\n", i+1, node.Path)
+		}
+		if len(b.visible) == 0 {
+			fmt.Fprintf(out, "no loaded entries match %q\n", b.search)
+		}
+		return
+	}
+
+	b.drawNodes(b.root.TopLevel(), "")
+	if b.root.Omitted > 0 {
+		fmt.Fprintf(out, "      … and %d more\n", b.root.Omitted)
+	}
+}
+
+func (b *browser) drawNodes(nodes []*ghtree.Node, indent string) {
+	collapsed, open := "▸ ", "▾ "
+	if useASCII() {
+		collapsed, open = "+ ", "- "
+	}
+
+	for _, node := range nodes {
+		b.visible = append(b.visible, node)
+		marker := "  "
+		if node.Type == "dir" {
+			marker = collapsed
+			if b.expanded[node] {
+				marker = open
+			}
+		}
+		fmt.Fprintf(out, "%4d  %s%s%s\n", len(b.visible), indent, marker, node.Name)
+
+		if b.expanded[node] {
+			b.drawNodes(node.Children, indent+"  ")
+			if node.Omitted > 0 {
+				fmt.Fprintf(out, "      %s  … and %d more\n", indent, node.Omitted)
+			}
+		}
+	}
+}
+
+// collectMatches gathers the loaded entries whose path contains the search
+func (b *browser) collectMatches(nodes []*ghtree.Node) {
+	for _, node := range nodes {
+		if strings.Contains(strings.ToLower(node.Path), b.search) {
+			b.visible = append(b.visible, node)
+		}
+		b.collectMatches(node.Children)
+	}
+}
+
+// openBrowser shows url in the system's default browser
+func openBrowser(url string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", url)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
+	default:
+		cmd = exec.Command("xdg-open", url)
+	}
+
+	err := cmd.Start()
+	if errors.Is(err, exec.ErrNotFound) {
+		return fmt.Errorf("no browser opener found; the page is %s", url)
+	}
+	if err != nil {
+		return err
+	}
+	go cmd.Wait()
+	return nil
+}
+
+// copyToClipboard asks the terminal to set the clipboard with an OSC 52
+// escape, which works over SSH and needs no helper program
+func copyToClipboard(text string) {
+	fmt.Fprintf(os.Stdout, "\x1b]52;c;%s\a", base64.StdEncoding.EncodeToString([]byte(text)))
+}
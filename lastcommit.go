@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/sbdtu5498/github-tree/pkg/ghtree"
+)
+
+// fetchLastCommits looks up the last commit of every entry below roots,
+// concurrently; the client bounds the requests in flight
+func fetchLastCommits(ctx context.Context, client *ghtree.Client, roots []*ghtree.Node) (map[*ghtree.Node]*ghtree.Commit, error) {
+	var nodes []*ghtree.Node
+	var collect func(nodes []*ghtree.Node)
+	collect = func(children []*ghtree.Node) {
+		for _, node := range children {
+			nodes = append(nodes, node)
+			collect(node.Children)
+		}
+	}
+	for _, root := range roots {
+		collect(root.TopLevel())
+	}
+
+	commits := make(map[*ghtree.Node]*ghtree.Commit, len(nodes))
+	var mu sync.Mutex
+	var firstErr error
+	var wg sync.WaitGroup
+	for _, node := range nodes {
+		wg.Add(1)
+		go func(node *ghtree.Node) {
+			defer wg.Done()
+			commit, err := client.LastCommit(ctx, node.Path)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				return
+			}
+			commits[node] = commit
+		}(node)
+	}
+	wg.Wait()
+	return commits, firstErr
+}
+
+// lastCommitAnnotation labels entries with the date and author of their
+// last commit, as GitHub's file listings do; with --relative-time the date
+// reads as an age, such as 3 days ago
+func lastCommitAnnotation(commits map[*ghtree.Node]*ghtree.Commit) func(node *ghtree.Node) string {
+	now := time.Now()
+	relative := relativeTimeFlag && !absoluteTimeFlag
+	return func(node *ghtree.Node) string {
+		commit := commits[node]
+		if commit == nil {
+			return ""
+		}
+		date := commit.Date.Local().Format("2006-01-02")
+		if relative {
+			date = ghtree.HumanizeAge(commit.Date, now)
+		}
+		return "[" + date + " " + commit.Author + "]"
+	}
+}
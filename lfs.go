@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/sbdtu5498/github-tree/pkg/ghtree"
+)
+
+// loadLFSPatterns reads the patterns the root .gitattributes routes through
+// Git LFS, from the --local directory or the repository. They come back as
+// ignore rules, a match meaning the file is in LFS: filter=lfs adds a
+// pattern, and any other filter setting takes it back. It returns nil when
+// there is no .gitattributes.
+func loadLFSPatterns(ctx context.Context, client *ghtree.Client) (*ghtree.IgnoreRules, error) {
+	var data []byte
+	var err error
+	if localFlag != "" {
+		data, err = os.ReadFile(filepath.Join(localFlag, ".gitattributes"))
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+	} else {
+		data, err = client.ReadFile(ctx, ".gitattributes")
+		var apiErr *ghtree.APIError
+		if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+			return nil, nil
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var rules strings.Builder
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		// Attributes can't be taken back with a negative pattern
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") || strings.HasPrefix(fields[0], "!") {
+			continue
+		}
+		for _, attribute := range fields[1:] {
+			switch {
+			case attribute == "filter=lfs":
+				rules.WriteString(fields[0] + "\n")
+			case attribute == "-filter" || attribute == "!filter" || strings.HasPrefix(attribute, "filter="):
+				rules.WriteString("!" + fields[0] + "\n")
+			}
+		}
+	}
+
+	patterns := &ghtree.IgnoreRules{}
+	err = patterns.Add(strings.NewReader(rules.String()))
+	if err != nil {
+		return nil, errors.New(".gitattributes: " + err.Error())
+	}
+	return patterns, nil
+}
+
+// markLFS reads the start of every file below roots that patterns route
+// through LFS, concurrently, and marks those holding an LFS pointer with the
+// size of the object it stands for. Files not yet converted to pointers
+// keep their size.
+func markLFS(ctx context.Context, client *ghtree.Client, roots []*ghtree.Node, patterns *ghtree.IgnoreRules) error {
+	var candidates []*ghtree.Node
+	var collect func(nodes []*ghtree.Node)
+	collect = func(nodes []*ghtree.Node) {
+		for _, node := range nodes {
+			if node.Type == "file" && node.Size <= ghtree.MaxLFSPointerSize && patterns.Match(node.Path, false) {
+				candidates = append(candidates, node)
+			}
+			collect(node.Children)
+		}
+	}
+	for _, root := range roots {
+		collect(root.TopLevel())
+	}
+
+	var mu sync.Mutex
+	var firstErr error
+	var wg sync.WaitGroup
+	for _, node := range candidates {
+		wg.Add(1)
+		go func(node *ghtree.Node) {
+			defer wg.Done()
+			data, err := readPointer(ctx, client, node)
+			if err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+				return
+			}
+			if size, ok := ghtree.ParseLFSPointer(data); ok {
+				node.LFS = true
+				node.Size = size
+			}
+		}(node)
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// readPointer reads a small file that may be an LFS pointer
+func readPointer(ctx context.Context, client *ghtree.Client, node *ghtree.Node) ([]byte, error) {
+	switch {
+	case localFlag != "":
+		return os.ReadFile(filepath.Join(localFlag, filepath.FromSlash(node.Path)))
+	case node.DownloadURL != "":
+		var b bytes.Buffer
+		_, err := client.Download(ctx, node, &b)
+		return b.Bytes(), err
+	}
+	return client.ReadFile(ctx, node.Path)
+}
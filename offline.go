@@ -0,0 +1,40 @@
+package main
+
+// needsNetwork names the options that send requests beyond the walk
+// itself, which --offline can't serve from a stored tree, if any of them
+// is in use
+func needsNetwork() string {
+	for _, option := range []struct {
+		name string
+		set  bool
+	}{
+		{"--gist", gistFlag != ""},
+		{"--all-repos", allReposFlag},
+		{"--graphql", graphqlFlag},
+		{"--follow-submodules", followSubsFlag},
+		{"--base", baseFlag != ""},
+		{"--diff-repo", diffRepoFlag != ""},
+		{"--verify", verifyFlag},
+		{"--snapshot", snapshotFlag},
+		{"--since-snapshot", sinceSnapshotFlag != ""},
+		{"--watch", watchFlag},
+		{"--list-refs", listRefsFlag},
+		{"--list-repos", listReposFlag},
+		{"--preflight", preflightFlag},
+		{"--dry-run", dryRunFlag},
+		{"--sort commit-date", sortFlag == "commit-date"},
+		{"--last-commit", lastCommitFlag},
+		{"--owners", ownersFlag},
+		{"--lfs", lfsFlag},
+		{"--preview", previewFlag != ""},
+		{"--download", downloadFlag != ""},
+		{"--archive", archiveFlag != ""},
+		{"--format raw-urls", formatFlag == "raw-urls"},
+		{"--verify-urls", verifyURLsFlag},
+	} {
+		if option.set {
+			return option.name
+		}
+	}
+	return ""
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	pathpkg "path"
+	"path/filepath"
+	"strings"
+
+	"github.com/sbdtu5498/github-tree/pkg/ghtree"
+)
+
+// loadCodeOwners reads the first CODEOWNERS file found where GitHub looks
+// for one, from the --local directory or the repository. It returns nil
+// when there is none.
+func loadCodeOwners(ctx context.Context, client *ghtree.Client) (*ghtree.CodeOwners, error) {
+	for _, path := range ghtree.CodeOwnersPaths {
+		var data []byte
+		var err error
+		if localFlag != "" {
+			data, err = os.ReadFile(filepath.Join(localFlag, filepath.FromSlash(path)))
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+		} else {
+			data, err = client.ReadFile(ctx, path)
+			var apiErr *ghtree.APIError
+			if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+				continue
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		debugf("reading owners from %s", path)
+		owners, err := ghtree.ParseCodeOwners(bytes.NewReader(data))
+		if err != nil {
+			return nil, errors.New(path + ": " + err.Error())
+		}
+		return owners, nil
+	}
+	return nil, nil
+}
+
+// ownersAnnotation labels entries with their owners where ownership
+// changes: on the top level of each tree, and below it wherever an entry's
+// owners differ from its directory's. Entries no rule covers are marked
+// unowned when their directory has owners.
+func ownersAnnotation(owners *ghtree.CodeOwners, roots []*ghtree.Node) func(node *ghtree.Node) string {
+	topLevel := map[*ghtree.Node]bool{}
+	for _, root := range roots {
+		for _, node := range root.TopLevel() {
+			topLevel[node] = true
+		}
+	}
+
+	return func(node *ghtree.Node) string {
+		parent := pathpkg.Dir(node.Path)
+		if parent == "." {
+			parent = ""
+		}
+		own := owners.Owners(node.Path, node.Type == "dir")
+		inherited := owners.Owners(parent, true)
+		switch {
+		case len(own) == 0 && len(inherited) == 0:
+			return ""
+		case !topLevel[node] && strings.Join(own, " ") == strings.Join(inherited, " "):
+			return ""
+		case len(own) == 0:
+			return "[unowned]"
+		}
+		return "[" + strings.Join(own, " ") + "]"
+	}
+}
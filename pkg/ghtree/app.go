@@ -0,0 +1,142 @@
+package ghtree
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// App identifies a GitHub App installation to authenticate as. The client
+// signs a JWT with PrivateKey, exchanges it for an installation token, and
+// mints a new one shortly before the old one expires.
+type App struct {
+	ID             int64
+	InstallationID int64
+	PrivateKey     *rsa.PrivateKey
+}
+
+// appTokenMargin is how long before expiry an installation token is
+// replaced, so requests in flight and retries never carry a stale one
+const appTokenMargin = 5 * time.Minute
+
+// ParsePrivateKey decodes the PEM private key GitHub issues for an App, in
+// either PKCS #1 or PKCS #8 form.
+func ParsePrivateKey(data []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found in the private key")
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid private key: %w", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("the private key is not an RSA key")
+	}
+	return key, nil
+}
+
+// token returns the token to authenticate requests with: Options.Token, or
+// a current installation token when authenticating as an App
+func (c *Client) token(ctx context.Context) (string, error) {
+	app := c.opts.App
+	if app == nil {
+		return c.opts.Token, nil
+	}
+
+	// One caller mints while the others wait for its token
+	c.appMu.Lock()
+	defer c.appMu.Unlock()
+	if c.appToken != "" && time.Until(c.appTokenExpiry) > appTokenMargin {
+		return c.appToken, nil
+	}
+
+	token, expiry, err := c.mintInstallationToken(ctx, app)
+	if err != nil {
+		return "", err
+	}
+	c.debugf("minted an installation token valid until %s", expiry.Format(time.RFC3339))
+	c.appToken, c.appTokenExpiry = token, expiry
+	return token, nil
+}
+
+// mintInstallationToken exchanges a JWT signed by the App for a token of
+// its installation
+func (c *Client) mintInstallationToken(ctx context.Context, app *App) (string, time.Time, error) {
+	jwt, err := appJWT(app, time.Now())
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	url := c.apiURL("app/installations/%d/access_tokens", app.InstallationID)
+	req, err := http.NewRequestWithContext(ctx, "POST", url, nil)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("invalid request URL %s: %w", url, err)
+	}
+	req.Header.Set("Authorization", "Bearer "+jwt)
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	resp, body, err := c.send(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", time.Time{}, stopped(ctx)
+		}
+		return "", time.Time{}, c.describeRequestError(err)
+	}
+	if resp.StatusCode != http.StatusCreated {
+		return "", time.Time{}, fmt.Errorf("failed to authenticate as GitHub App %d: %w", app.ID, newAPIError(url, resp, body))
+	}
+
+	var result struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+	err = json.Unmarshal(body, &result)
+	if err != nil || result.Token == "" {
+		return "", time.Time{}, fmt.Errorf("unexpected installation token response from %s", url)
+	}
+	return result.Token, result.ExpiresAt, nil
+}
+
+// appJWT returns the JWT that authenticates as the App itself. GitHub
+// accepts at most ten minutes of validity, and the issue time is backdated
+// to allow for clock drift.
+func appJWT(app *App, now time.Time) (string, error) {
+	if app.PrivateKey == nil {
+		return "", errors.New("the GitHub App has no private key")
+	}
+
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`))
+	claims, err := json.Marshal(map[string]interface{}{
+		"iat": now.Add(-time.Minute).Unix(),
+		"exp": now.Add(9 * time.Minute).Unix(),
+		"iss": strconv.FormatInt(app.ID, 10),
+	})
+	if err != nil {
+		return "", err
+	}
+	unsigned := header + "." + base64.RawURLEncoding.EncodeToString(claims)
+
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, app.PrivateKey, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("failed to sign the GitHub App JWT: %w", err)
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
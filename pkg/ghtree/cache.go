@@ -0,0 +1,66 @@
+package ghtree
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// cacheEntry is a directory listing stored on disk
+type cacheEntry struct {
+	Fetched time.Time `json:"fetched"`
+	Files   []File    `json:"files"`
+
+	// ETag lets an expired listing be revalidated with a conditional request
+	ETag string `json:"etag,omitempty"`
+}
+
+// cachePath names the file holding the listing of path at the client's ref
+func (c *Client) cachePath(path string) string {
+	key := c.opts.APIURL + "\x00" + c.opts.Owner + "\x00" + c.opts.Repo + "\x00" + c.opts.Ref + "\x00" + path
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(c.opts.CacheDir, hex.EncodeToString(sum[:])+".json")
+}
+
+// cachedListing returns the stored listing of path, if there is one, and
+// whether it is younger than the cache TTL
+func (c *Client) cachedListing(path string) (*cacheEntry, bool) {
+	if c.opts.CacheDir == "" || c.opts.CacheTTL <= 0 {
+		return nil, false
+	}
+
+	data, err := os.ReadFile(c.cachePath(path))
+	if err != nil {
+		return nil, false
+	}
+
+	// Unreadable entries are simply fetched again
+	var entry cacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, false
+	}
+	return &entry, time.Since(entry.Fetched) <= c.opts.CacheTTL
+}
+
+// storeListing saves a listing with the ETag to revalidate it by; a cache
+// that can't be written only costs requests, so failures are reported as
+// warnings
+func (c *Client) storeListing(path string, files []File, etag string) {
+	if c.opts.CacheDir == "" || c.opts.CacheTTL <= 0 {
+		return
+	}
+
+	data, err := json.Marshal(cacheEntry{Fetched: time.Now(), Files: files, ETag: etag})
+	if err == nil {
+		err = os.MkdirAll(c.opts.CacheDir, 0700)
+	}
+	if err == nil {
+		err = os.WriteFile(c.cachePath(path), data, 0600)
+	}
+	if err != nil {
+		c.warnf("failed to cache the listing of %q: %v", path, err)
+	}
+}
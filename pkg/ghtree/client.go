@@ -0,0 +1,1018 @@
+package ghtree
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultAPIURL is the public GitHub REST API.
+const DefaultAPIURL = "https://api.github.com"
+
+// Client talks to the GitHub REST API for one repository. It is safe for
+// concurrent use, and every request it makes shares one concurrency bound
+// and one rate limit.
+type Client struct {
+	opts    Options
+	http    *http.Client
+	slots   chan struct{}
+	limiter *rateLimiter
+
+	statsMu sync.Mutex
+	stats   Stats
+
+	// The installation token in use when authenticating as an App
+	appMu          sync.Mutex
+	appToken       string
+	appTokenExpiry time.Time
+
+	// Last commits looked up so far, by path
+	commitsMu sync.Mutex
+	commits   map[string]*Commit
+
+	// Repository metadata looked up so far, by owner/repo
+	reposMu sync.Mutex
+	repos   map[string]*Repository
+
+	// The repositories whose submodules led to this one, outermost first
+	enclosing []string
+}
+
+// Stats summarizes the requests a Client has made.
+type Stats struct {
+	// Requests counts every request sent, including retries.
+	Requests int
+
+	// RateLimit, RateLimitRemaining and RateLimitReset echo the rate-limit
+	// headers of the latest response that had them. RateLimit is zero until
+	// such a response arrives.
+	RateLimit          int
+	RateLimitRemaining int
+	RateLimitReset     time.Time
+}
+
+// Stats returns a snapshot of the client's request statistics.
+func (c *Client) Stats() Stats {
+	c.statsMu.Lock()
+	defer c.statsMu.Unlock()
+	return c.stats
+}
+
+// recordResponse counts a request and keeps the rate-limit headers of its
+// response, if there was one
+func (c *Client) recordResponse(resp *http.Response) {
+	c.statsMu.Lock()
+	defer c.statsMu.Unlock()
+
+	c.stats.Requests++
+	if resp == nil {
+		return
+	}
+	limit, err := strconv.Atoi(resp.Header.Get("X-RateLimit-Limit"))
+	if err != nil {
+		return
+	}
+	remaining, _ := strconv.Atoi(resp.Header.Get("X-RateLimit-Remaining"))
+	c.stats.RateLimit = limit
+	c.stats.RateLimitRemaining = remaining
+	c.stats.RateLimitReset = rateLimitReset(resp)
+}
+
+// addStats counts the requests another client made on this one's behalf
+func (c *Client) addStats(s Stats) {
+	c.statsMu.Lock()
+	defer c.statsMu.Unlock()
+
+	c.stats.Requests += s.Requests
+	if s.RateLimit > 0 {
+		c.stats.RateLimit = s.RateLimit
+		c.stats.RateLimitRemaining = s.RateLimitRemaining
+		c.stats.RateLimitReset = s.RateLimitReset
+	}
+}
+
+// NewClient returns a Client configured by opts.
+func NewClient(opts Options) *Client {
+	if opts.APIURL == "" {
+		opts.APIURL = DefaultAPIURL
+	}
+	if opts.Concurrency < 1 {
+		opts.Concurrency = 4
+	}
+
+	return &Client{
+		opts:    opts,
+		http:    &http.Client{Timeout: opts.Timeout, Transport: opts.Transport},
+		slots:   make(chan struct{}, opts.Concurrency),
+		limiter: newRateLimiter(opts.RPS),
+	}
+}
+
+// APIError reports a non-success response from the GitHub API. Message
+// and DocumentationURL come from GitHub's error body when it has one.
+type APIError struct {
+	URL              string
+	StatusCode       int
+	Status           string
+	Message          string
+	DocumentationURL string
+}
+
+// newAPIError describes a failed response, keeping GitHub's own explanation
+func newAPIError(url string, resp *http.Response, body []byte) *APIError {
+	apiErr := &APIError{URL: url, StatusCode: resp.StatusCode, Status: resp.Status}
+
+	var detail struct {
+		Message          string `json:"message"`
+		DocumentationURL string `json:"documentation_url"`
+	}
+	if json.Unmarshal(body, &detail) == nil {
+		apiErr.Message = detail.Message
+		apiErr.DocumentationURL = detail.DocumentationURL
+	}
+	return apiErr
+}
+
+func (e *APIError) Error() string {
+	var msg string
+	switch e.StatusCode {
+	case http.StatusUnauthorized:
+		msg = fmt.Sprintf("GitHub rejected the access token (%s) for %s", e.Status, e.URL)
+	case http.StatusForbidden:
+		msg = fmt.Sprintf("GitHub denied access (%s) to %s", e.Status, e.URL)
+	case http.StatusNotFound:
+		msg = fmt.Sprintf("GitHub found nothing (%s) at %s", e.Status, e.URL)
+	case http.StatusUnprocessableEntity:
+		msg = fmt.Sprintf("GitHub could not process the request (%s) for %s", e.Status, e.URL)
+	default:
+		msg = fmt.Sprintf("GitHub API returned %s for %s", e.Status, e.URL)
+	}
+
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	return msg
+}
+
+// isEmptyRepository reports whether err is GitHub's answer for a repository
+// without commits: a 404 from the contents API, or a 409 from the git trees
+// API, each saying the repository is empty
+func isEmptyRepository(err error) bool {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	switch apiErr.StatusCode {
+	case http.StatusNotFound, http.StatusConflict:
+		return strings.Contains(strings.ToLower(apiErr.Message), "repository is empty")
+	}
+	return false
+}
+
+// RateLimitError reports an exhausted rate limit when Options.WaitOnRateLimit
+// is not set, or a wait GitHub asked for that is longer than Options.MaxWait,
+// until its rate limit resets or as a Retry-After header.
+type RateLimitError struct {
+	Reset time.Time
+
+	// MaxWait is the limit the wait until Reset exceeded; zero when the
+	// client wasn't asked to wait at all.
+	MaxWait time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	if e.MaxWait > 0 {
+		return fmt.Sprintf("GitHub asked to wait until %s, longer than the %s wait limit", e.Reset.Format(time.Kitchen), e.MaxWait)
+	}
+	return fmt.Sprintf("GitHub API rate limit exceeded; it resets at %s", e.Reset.Format(time.Kitchen))
+}
+
+// IncompleteError accompanies the partial tree Fetch returns when its
+// context ends mid-walk. Directories the walk didn't get to are left
+// unlisted, as if beyond the depth limit.
+type IncompleteError struct {
+	Err error
+}
+
+func (e *IncompleteError) Error() string {
+	return "the walk stopped early (" + e.Err.Error() + "); the tree is incomplete"
+}
+
+func (e *IncompleteError) Unwrap() error {
+	return e.Err
+}
+
+// IntegrityError reports a downloaded file whose contents don't hash to the
+// blob SHA its listing gave, such as one cut short or corrupted on the way.
+type IntegrityError struct {
+	Path string
+
+	// SHA is the listed blob SHA, and Got what the contents hash to
+	SHA string
+	Got string
+
+	// Size is the listed size, and Written how many bytes arrived
+	Size    int64
+	Written int64
+}
+
+func (e *IntegrityError) Error() string {
+	if e.Written != e.Size {
+		return fmt.Sprintf("%s: downloaded %d bytes of %d; the contents don't match blob %s", e.Path, e.Written, e.Size, shortSHA(e.SHA))
+	}
+	return fmt.Sprintf("%s: the downloaded contents hash to %s, not blob %s", e.Path, shortSHA(e.Got), shortSHA(e.SHA))
+}
+
+// stopped describes why ctx ended: an interrupt, or a deadline
+func stopped(ctx context.Context) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return errors.New("deadline exceeded")
+	}
+	return errors.New("interrupted")
+}
+
+// listingError rewords a failed directory listing while keeping the
+// underlying *APIError reachable through errors.As.
+type listingError struct {
+	msg string
+	err error
+}
+
+func (e *listingError) Error() string {
+	return e.msg
+}
+
+func (e *listingError) Unwrap() error {
+	return e.err
+}
+
+// Repository describes a repository and the caller's access to it.
+type Repository struct {
+	Private       bool
+	CanPull       bool
+	DefaultBranch string
+
+	// Scopes lists the OAuth scopes of a classic token; it is nil for
+	// anonymous requests and fine-grained tokens.
+	Scopes []string
+}
+
+// Repository fetches the repository's metadata. The client keeps the answer
+// for as long as it lives, so a process asks GitHub once however often it
+// calls Repository; nothing is kept between processes, and failures aren't
+// kept at all.
+func (c *Client) Repository(ctx context.Context) (*Repository, error) {
+	err := CheckRepository(c.opts.Owner, c.opts.Repo)
+	if err != nil {
+		return nil, err
+	}
+
+	// One caller looks the repository up while the others wait for it
+	key := c.opts.Owner + "/" + c.opts.Repo
+	c.reposMu.Lock()
+	defer c.reposMu.Unlock()
+	if repository, ok := c.repos[key]; ok {
+		copied := *repository
+		return &copied, nil
+	}
+
+	repoURL := c.apiURL("repos/%s/%s", c.opts.Owner, c.opts.Repo)
+	resp, body, err := c.do(ctx, repoURL)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(repoURL, resp, body)
+	}
+
+	var info struct {
+		Private       bool   `json:"private"`
+		DefaultBranch string `json:"default_branch"`
+		Permissions   struct {
+			Pull bool `json:"pull"`
+		} `json:"permissions"`
+	}
+	err = json.Unmarshal(body, &info)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse repository info: %w", err)
+	}
+
+	repository := &Repository{
+		Private:       info.Private,
+		CanPull:       info.Permissions.Pull,
+		DefaultBranch: info.DefaultBranch,
+	}
+
+	repository.Scopes = oauthScopes(resp)
+	if c.repos == nil {
+		c.repos = map[string]*Repository{}
+	}
+	copied := *repository
+	c.repos[key] = &copied
+	return repository, nil
+}
+
+// oauthScopes returns the scopes a response says its token has. Only
+// classic and OAuth tokens report them; for others it returns nil.
+func oauthScopes(resp *http.Response) []string {
+	if _, classic := resp.Header["X-Oauth-Scopes"]; !classic {
+		return nil
+	}
+	scopes := []string{}
+	for _, scope := range strings.Split(resp.Header.Get("X-OAuth-Scopes"), ",") {
+		if scope = strings.TrimSpace(scope); scope != "" {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes
+}
+
+// ListRefs returns the names of the repository's branches followed by its tags.
+func (c *Client) ListRefs(ctx context.Context) ([]string, error) {
+	err := CheckRepository(c.opts.Owner, c.opts.Repo)
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+
+	// Branches and tags share the same response shape
+	for _, kind := range []string{"branches", "tags"} {
+		refsURL := c.apiURL("repos/%s/%s/%s?per_page=100", c.opts.Owner, c.opts.Repo, kind)
+		for refsURL != "" {
+			body, next, err := c.getPage(ctx, refsURL)
+			if err != nil {
+				return nil, err
+			}
+
+			var refs []struct {
+				Name string `json:"name"`
+			}
+			err = json.Unmarshal(body, &refs)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse %s: %w", kind, err)
+			}
+
+			for _, ref := range refs {
+				names = append(names, ref.Name)
+			}
+			refsURL = next
+		}
+	}
+	return names, nil
+}
+
+// RepositorySummary is one entry of ListRepositories.
+type RepositorySummary struct {
+	Owner string
+	Name  string
+
+	// Visibility is "public", "private" or "internal"
+	Visibility string
+
+	Archived bool
+}
+
+// ListRepositories returns the repositories owner has, sorted by name.
+// owner is an organization or a user; only the organization's members see
+// its private repositories, and a user's private ones are left out. An
+// empty owner lists the repositories the token's user owns, collaborates on
+// or sees as an organization member, sorted by owner first.
+func (c *Client) ListRepositories(ctx context.Context, owner string) ([]RepositorySummary, error) {
+	var repos []RepositorySummary
+	var err error
+	if owner == "" {
+		repos, err = c.listRepositories(ctx, c.apiURL("user/repos?per_page=100&affiliation=owner,collaborator,organization_member"))
+	} else {
+		err = CheckOwner(owner)
+		if err != nil {
+			return nil, err
+		}
+
+		// An organization's listing 404s for users, who have one of their own
+		repos, err = c.listRepositories(ctx, c.apiURL("orgs/%s/repos?per_page=100&type=all", owner))
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+			repos, err = c.listRepositories(ctx, c.apiURL("users/%s/repos?per_page=100&type=owner", owner))
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	for i := range repos {
+		if repos[i].Owner == "" {
+			repos[i].Owner = owner
+		}
+	}
+	sort.Slice(repos, func(i, j int) bool {
+		if !strings.EqualFold(repos[i].Owner, repos[j].Owner) {
+			return strings.ToLower(repos[i].Owner) < strings.ToLower(repos[j].Owner)
+		}
+		return strings.ToLower(repos[i].Name) < strings.ToLower(repos[j].Name)
+	})
+	return repos, nil
+}
+
+// listRepositories reads every page of a repository listing
+func (c *Client) listRepositories(ctx context.Context, reposURL string) ([]RepositorySummary, error) {
+	repos := []RepositorySummary{}
+	for reposURL != "" {
+		body, next, err := c.getPage(ctx, reposURL)
+		if err != nil {
+			return nil, err
+		}
+
+		var page []struct {
+			Owner struct {
+				Login string `json:"login"`
+			} `json:"owner"`
+			Name       string `json:"name"`
+			Private    bool   `json:"private"`
+			Visibility string `json:"visibility"`
+			Archived   bool   `json:"archived"`
+		}
+		err = json.Unmarshal(body, &page)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse repositories: %w", err)
+		}
+
+		for _, repo := range page {
+			// Older GitHub Enterprise servers only report privacy
+			if repo.Visibility == "" {
+				repo.Visibility = "public"
+				if repo.Private {
+					repo.Visibility = "private"
+				}
+			}
+			repos = append(repos, RepositorySummary{Owner: repo.Owner.Login, Name: repo.Name, Visibility: repo.Visibility, Archived: repo.Archived})
+		}
+		reposURL = next
+	}
+	return repos, nil
+}
+
+// Download copies the contents of the file n to w and returns the number of
+// bytes written. Files fetched with RecursiveAPI have no download URL. When
+// n has a blob SHA, the contents are hashed on the way to w, and if they
+// don't match it Download returns an *IntegrityError once all of them were
+// written; LFS files are left unchecked.
+func (c *Client) Download(ctx context.Context, n *Node, w io.Writer) (int64, error) {
+	if n.DownloadURL == "" {
+		return 0, fmt.Errorf("%s has no download URL", n.Path)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", n.DownloadURL, nil)
+	if err != nil {
+		return 0, fmt.Errorf("invalid download URL %s: %w", n.DownloadURL, err)
+	}
+
+	// Failures are retried like API requests until the body starts
+	// streaming, holding a request slot until it is read
+	resp, _, err := c.retry(ctx, req, func(req *http.Request) (*http.Response, []byte, error) {
+		resp, err := c.open(req)
+		if err != nil || resp.StatusCode == http.StatusOK {
+			return resp, nil, err
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read response from %s: %w", req.URL, err)
+		}
+		return resp, body, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, &APIError{URL: n.DownloadURL, StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+	defer resp.Body.Close()
+
+	dst := w
+	hash := blobHash(n)
+	if hash != nil {
+		dst = io.MultiWriter(w, hash)
+	}
+	written, err := io.Copy(dst, resp.Body)
+	if err != nil {
+		return written, fmt.Errorf("failed to download %s: %w", n.Path, err)
+	}
+	if hash != nil {
+		got := hex.EncodeToString(hash.Sum(nil))
+		if got != strings.ToLower(n.SHA) {
+			return written, &IntegrityError{Path: n.Path, SHA: n.SHA, Got: got, Size: n.Size, Written: written}
+		}
+	}
+	return written, nil
+}
+
+// CheckURL sends a HEAD request for rawURL, such as a file's download URL,
+// and returns an *APIError unless it answers with success. The request
+// carries no token, as whoever is handed the URL may have none; failures
+// are retried like API requests.
+func (c *Client) CheckURL(ctx context.Context, rawURL string) error {
+	req, err := http.NewRequestWithContext(ctx, "HEAD", rawURL, nil)
+	if err != nil {
+		return fmt.Errorf("invalid URL %s: %w", rawURL, err)
+	}
+
+	resp, _, err := c.retry(ctx, req, func(req *http.Request) (*http.Response, []byte, error) {
+		req.Header.Del("Authorization")
+		return c.send(req)
+	})
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &APIError{URL: rawURL, StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+	return nil
+}
+
+// ReadFile returns the contents of the file at path as of Options.Ref,
+// through the GitHub contents API. A missing file is an *APIError with
+// StatusCode 404.
+func (c *Client) ReadFile(ctx context.Context, path string) ([]byte, error) {
+	path = strings.Trim(path, "/")
+	body, err := c.get(ctx, c.contentsURL(path))
+	if err != nil {
+		return nil, err
+	}
+
+	var file struct {
+		Type        string `json:"type"`
+		Content     string `json:"content"`
+		Encoding    string `json:"encoding"`
+		DownloadURL string `json:"download_url"`
+	}
+	if json.Unmarshal(body, &file) != nil || file.Type != "file" {
+		return nil, fmt.Errorf("%s is not a file", path)
+	}
+	if file.Encoding == "base64" {
+		return base64.StdEncoding.DecodeString(strings.ReplaceAll(file.Content, "\n", ""))
+	}
+
+	// Files over 1 MB come without their content
+	var b bytes.Buffer
+	_, err = c.Download(ctx, &Node{Path: path, DownloadURL: file.DownloadURL}, &b)
+	if err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// RawURL returns the URL serving the raw contents of path at ref: a
+// raw.githubusercontent.com URL for github.com, and the /raw/ endpoint of
+// the server for GitHub Enterprise.
+func (c *Client) RawURL(ref, path string) string {
+	rest := c.linkedRepository() + "/" + escapePath(ref) + "/" + escapePath(path)
+
+	u, err := url.Parse(c.opts.APIURL)
+	if err != nil || u.Host == "api.github.com" {
+		return "https://raw.githubusercontent.com/" + rest
+	}
+	return u.Scheme + "://" + u.Host + "/raw/" + rest
+}
+
+// WebURL returns the page of n on GitHub at ref, or on the default branch
+// if ref is empty. That is n.HTMLURL when the listing reported one and no
+// DisplayName replaces the repository in it.
+func (c *Client) WebURL(ref string, n *Node) string {
+	if n.HTMLURL != "" && c.opts.DisplayName == "" {
+		return n.HTMLURL
+	}
+	if ref == "" {
+		ref = "HEAD"
+	}
+	kind := "blob"
+	if n.Type == "dir" || n.Type == "submodule" {
+		kind = "tree"
+	}
+	rest := c.linkedRepository() + "/" + kind + "/" + escapePath(ref) + "/" + escapePath(n.Path)
+
+	u, err := url.Parse(c.opts.APIURL)
+	if err != nil || u.Host == "api.github.com" {
+		return "https://github.com/" + rest
+	}
+	return u.Scheme + "://" + u.Host + "/" + rest
+}
+
+// linkedRepository is the owner/name the URLs of RawURL and WebURL name
+func (c *Client) linkedRepository() string {
+	if c.opts.DisplayName != "" {
+		return c.opts.DisplayName
+	}
+	return c.opts.Owner + "/" + c.opts.Repo
+}
+
+func (c *Client) warnf(format string, args ...interface{}) {
+	c.logf("warning", nil, format, args...)
+}
+
+func (c *Client) debugf(format string, args ...interface{}) {
+	c.logf("debug", nil, format, args...)
+}
+
+// logf sends a line to Log with its fields, or else to the function for
+// its level: "warning", "debug", or "trace" for requests
+func (c *Client) logf(level string, fields map[string]interface{}, format string, args ...interface{}) {
+	if c.opts.Log != nil {
+		c.opts.Log(LogEntry{Level: level, Message: fmt.Sprintf(format, args...), Fields: fields})
+		return
+	}
+
+	var printf func(format string, args ...interface{})
+	switch level {
+	case "warning":
+		printf = c.opts.Warnf
+	case "trace":
+		printf = c.opts.Tracef
+		if printf == nil {
+			printf = c.opts.Debugf
+		}
+	default:
+		printf = c.opts.Debugf
+	}
+	if printf != nil {
+		printf(format, args...)
+	}
+}
+
+// apiURL joins a request path to the API base, tolerating trailing slashes
+func (c *Client) apiURL(format string, args ...interface{}) string {
+	return strings.TrimRight(c.opts.APIURL, "/") + "/" + fmt.Sprintf(format, args...)
+}
+
+func (c *Client) describeListingError(err error, path string) error {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		return err
+	}
+
+	// GitHub answers 404 for private repositories when the caller is anonymous
+	anonymous := c.opts.Token == "" && c.opts.App == nil
+	hint := ""
+	if anonymous {
+		hint = "; if the repository is private, an access token is required"
+	}
+
+	owner, repo := c.opts.Owner, c.opts.Repo
+	if isEmptyRepository(err) {
+		return &listingError{
+			msg: fmt.Sprintf("repository %s/%s is empty, so path %q does not exist", owner, repo, strings.Trim(path, "/")),
+			err: err,
+		}
+	}
+	switch apiErr.StatusCode {
+	case http.StatusNotFound:
+		return &listingError{
+			msg: fmt.Sprintf("repository %s/%s not found or path %q does not exist%s", owner, repo, strings.Trim(path, "/"), hint),
+			err: err,
+		}
+	case http.StatusUnauthorized, http.StatusForbidden:
+		if anonymous {
+			return &listingError{
+				msg: fmt.Sprintf("GitHub refused anonymous access to %s/%s (%s); an access token is required", owner, repo, apiErr.Status),
+				err: err,
+			}
+		}
+	}
+	return err
+}
+
+func (c *Client) get(ctx context.Context, url string) ([]byte, error) {
+	body, _, err := c.getPage(ctx, url)
+	return body, err
+}
+
+// getPage fetches one page and returns the URL of the next page, if any
+func (c *Client) getPage(ctx context.Context, url string) ([]byte, string, error) {
+	body, next, _, _, err := c.getPageIfChanged(ctx, url, "")
+	return body, next, err
+}
+
+// getPageIfChanged is getPage with a conditional request: when etag is set
+// and still current it reports notModified instead of returning a body,
+// which GitHub doesn't count against the rate limit. It also returns the
+// page's own ETag.
+func (c *Client) getPageIfChanged(ctx context.Context, url, etag string) (body []byte, next, newETag string, notModified bool, err error) {
+	req, err := c.newRequest(ctx, url)
+	if err != nil {
+		return nil, "", "", false, err
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+
+	resp, body, err := c.doRequest(ctx, req)
+	if err != nil {
+		return nil, "", "", false, err
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && etag != "":
+		return nil, "", etag, true, nil
+	case resp.StatusCode != http.StatusOK:
+		return nil, "", "", false, newAPIError(url, resp, body)
+	}
+	return body, nextPageURL(resp.Header.Get("Link")), resp.Header.Get("ETag"), false, nil
+}
+
+// newRequest prepares a GET request; doRequest authorizes it
+func (c *Client) newRequest(ctx context.Context, url string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid request URL %s: %w", url, err)
+	}
+	return req, nil
+}
+
+// authorize adds the token, if there is one, to req
+func (c *Client) authorize(req *http.Request) error {
+	token, err := c.token(req.Context())
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return nil
+}
+
+// do sends a GET request for url; see doRequest
+func (c *Client) do(ctx context.Context, url string) (*http.Response, []byte, error) {
+	req, err := c.newRequest(ctx, url)
+	if err != nil {
+		return nil, nil, err
+	}
+	return c.doRequest(ctx, req)
+}
+
+// doRequest sends a request, retrying transient failures and waiting out an
+// exhausted rate limit if configured to
+func (c *Client) doRequest(ctx context.Context, req *http.Request) (*http.Response, []byte, error) {
+	return c.retry(ctx, req, c.send)
+}
+
+// retry makes attempts at req with send until one succeeds, fails for good
+// or runs out of retries; see doRequest
+func (c *Client) retry(ctx context.Context, req *http.Request, send func(*http.Request) (*http.Response, []byte, error)) (*http.Response, []byte, error) {
+	for attempt := 0; ; {
+		// Authorize each attempt, since an App's token may have been
+		// renewed while this one waited
+		err := c.authorize(req)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		resp, body, err := send(req)
+		if err != nil && ctx.Err() != nil {
+			return nil, nil, stopped(ctx)
+		}
+
+		// Either wait out an exhausted rate limit or stop with its reset time
+		if err == nil && isRateLimited(resp) {
+			reset := rateLimitReset(resp)
+			if !c.opts.WaitOnRateLimit {
+				return nil, nil, &RateLimitError{Reset: reset}
+			}
+			if c.opts.MaxWait > 0 && time.Until(reset) > c.opts.MaxWait {
+				return nil, nil, &RateLimitError{Reset: reset, MaxWait: c.opts.MaxWait}
+			}
+
+			c.logf("warning", map[string]interface{}{"reset": reset.Format(time.RFC3339)}, "rate limit exceeded; waiting until %s", reset.Format(time.Kitchen))
+			err = sleep(ctx, time.Until(reset)+time.Second)
+			if err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+
+		// Give up on permanent failures, once the retries are spent, and
+		// when GitHub asks for a longer wait than allowed
+		delay, retry, waitErr := c.retryDelay(resp, body, err, attempt)
+		if waitErr != nil {
+			return nil, nil, waitErr
+		}
+		if !retry {
+			if err != nil {
+				return nil, nil, c.describeRequestError(err)
+			}
+			return resp, body, nil
+		}
+
+		attempt++
+		problem := "failed to reach GitHub"
+		fields := map[string]interface{}{"url": req.URL.String(), "attempt": attempt, "retries": c.opts.Retries, "delay_ms": delay.Milliseconds()}
+		if err == nil {
+			problem = "GitHub API returned " + resp.Status
+			fields["status"] = resp.StatusCode
+		} else {
+			fields["error"] = err.Error()
+		}
+		c.logf("warning", fields, "%s for %s; retrying in %s (%d of %d)", problem, req.URL, delay.Round(time.Millisecond), attempt, c.opts.Retries)
+		err = sleep(ctx, delay)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+}
+
+// retryDelay reports whether a failed attempt should be retried, and after
+// how long. Unless GitHub names a delay, it doubles with each attempt up to
+// RetryMaxWait, and a random part of it is shaved off so that clients
+// failing together don't retry together. A delay GitHub names longer than
+// MaxWait fails with a *RateLimitError instead.
+func (c *Client) retryDelay(resp *http.Response, body []byte, err error, attempt int) (time.Duration, bool, error) {
+	if attempt >= c.opts.Retries {
+		return 0, false, nil
+	}
+	if err == nil && !isTransient(resp, body) {
+		return 0, false, nil
+	}
+	if err == nil {
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds >= 0 {
+			delay := time.Duration(seconds) * time.Second
+			if c.opts.MaxWait > 0 && (delay > c.opts.MaxWait || delay < 0) {
+				return 0, false, &RateLimitError{Reset: time.Now().Add(delay), MaxWait: c.opts.MaxWait}
+			}
+			return delay, true, nil
+		}
+	}
+
+	delay := time.Second << attempt
+	if c.opts.RetryMaxWait > 0 && (delay > c.opts.RetryMaxWait || delay <= 0) {
+		delay = c.opts.RetryMaxWait
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1)), true, nil
+}
+
+// isTransient reports whether a response is worth retrying: server errors,
+// and secondary rate limits, which ask clients to back off, with or without
+// a Retry-After header
+func isTransient(resp *http.Response, body []byte) bool {
+	if resp.StatusCode >= 500 {
+		return true
+	}
+	if resp.StatusCode != http.StatusForbidden && resp.StatusCode != http.StatusTooManyRequests {
+		return false
+	}
+	return resp.Header.Get("Retry-After") != "" || bytes.Contains(bytes.ToLower(body), []byte("secondary rate limit"))
+}
+
+// sleep waits for d, returning early if ctx is cancelled
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return stopped(ctx)
+	}
+}
+
+func isRateLimited(resp *http.Response) bool {
+	if resp.StatusCode != http.StatusForbidden && resp.StatusCode != http.StatusTooManyRequests {
+		return false
+	}
+	return resp.Header.Get("X-RateLimit-Remaining") == "0"
+}
+
+func rateLimitReset(resp *http.Response) time.Time {
+	// The reset header holds Unix seconds; retry in a minute if it's missing
+	seconds, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64)
+	if err != nil {
+		return time.Now().Add(time.Minute)
+	}
+	return time.Unix(seconds, 0)
+}
+
+func nextPageURL(link string) string {
+	// The Link header looks like: <url>; rel="next", <url>; rel="last"
+	for _, part := range strings.Split(link, ",") {
+		segments := strings.Split(part, ";")
+		if len(segments) < 2 {
+			continue
+		}
+
+		for _, param := range segments[1:] {
+			if strings.TrimSpace(param) == `rel="next"` {
+				return strings.Trim(strings.TrimSpace(segments[0]), "<>")
+			}
+		}
+	}
+	return ""
+}
+
+// acquire waits for the rate limit to allow another request, then takes a
+// request slot, giving up if ctx is done first. The wait comes first so a
+// request held back by the rate limit doesn't keep others from their slots.
+func (c *Client) acquire(ctx context.Context) error {
+	err := c.limiter.Wait(ctx)
+	if err != nil {
+		return err
+	}
+	select {
+	case c.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return stopped(ctx)
+	}
+}
+
+// release gives back the request slot acquire took
+func (c *Client) release() {
+	<-c.slots
+}
+
+// send makes a single attempt at req
+func (c *Client) send(req *http.Request) (*http.Response, []byte, error) {
+	resp, err := c.open(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	// Read the response body
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read response from %s: %w", req.URL, err)
+	}
+
+	return resp, body, nil
+}
+
+// open makes a single attempt at req, leaving the response body to the
+// caller. The request slot it takes is held until the body is closed.
+func (c *Client) open(req *http.Request) (*http.Response, error) {
+	if c.opts.Trace != nil {
+		c.opts.Trace(req)
+	}
+
+	err := c.acquire(req.Context())
+	if err != nil {
+		return nil, err
+	}
+
+	// A retried request needs its body again
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			c.release()
+			return nil, err
+		}
+		req.Body = body
+	}
+
+	start := time.Now()
+	resp, err := c.http.Do(req)
+	c.recordResponse(resp)
+	if err != nil {
+		c.release()
+		c.logf("trace", map[string]interface{}{"method": req.Method, "url": req.URL.String(), "error": err.Error()}, "%s %s: %v", req.Method, req.URL, err)
+		return nil, err
+	}
+	elapsed := time.Since(start)
+	c.logf("trace", map[string]interface{}{"method": req.Method, "url": req.URL.String(), "status": resp.StatusCode, "ms": elapsed.Milliseconds()}, "%s %s: %s in %s", req.Method, req.URL, resp.Status, elapsed.Round(time.Millisecond))
+
+	resp.Body = &slotBody{ReadCloser: resp.Body, release: c.release}
+	return resp, nil
+}
+
+// slotBody is a response body holding a request slot until it is closed
+type slotBody struct {
+	io.ReadCloser
+	release func()
+	once    sync.Once
+}
+
+func (b *slotBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
+
+func (c *Client) describeRequestError(err error) error {
+	// Distinguish a timeout from other network failures
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return fmt.Errorf("request timed out after %s: %w", c.opts.Timeout, err)
+	}
+	return fmt.Errorf("failed to reach GitHub: %w", err)
+}
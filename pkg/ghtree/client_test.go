@@ -0,0 +1,429 @@
+package ghtree
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// limitedFirst answers its first limited requests as if the rate limit
+// were spent, with reset as the reset time, then passes requests on to next
+type limitedFirst struct {
+	limited  int32
+	status   int
+	reset    time.Time
+	next     http.Handler
+	requests atomic.Int32
+}
+
+func (h *limitedFirst) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("X-RateLimit-Limit", "60")
+	w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(h.reset.Unix(), 10))
+	if h.requests.Add(1) > h.limited {
+		w.Header().Set("X-RateLimit-Remaining", "59")
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	w.Header().Set("X-RateLimit-Remaining", "0")
+	w.WriteHeader(h.status)
+	w.Write([]byte(`{"message": "API rate limit exceeded for 127.0.0.1."}`))
+}
+
+func TestRateLimitHeaders(t *testing.T) {
+	later := time.Now().Add(time.Hour).Truncate(time.Second)
+	listings := map[string][]File{"": {file("README.md", 1)}}
+
+	tests := []struct {
+		name     string
+		limited  int32
+		status   int
+		reset    time.Time
+		wait     bool
+		wantErr  bool
+		requests int32
+	}{
+		{name: "remaining requests", limited: 0, status: http.StatusForbidden, reset: later, requests: 1},
+		{name: "spent, 403", limited: 1, status: http.StatusForbidden, reset: later, wantErr: true, requests: 1},
+		{name: "spent, 429", limited: 1, status: http.StatusTooManyRequests, reset: later, wantErr: true, requests: 1},
+		{name: "spent, waiting for the reset", limited: 1, status: http.StatusForbidden, reset: time.Now().Add(-time.Second), wait: true, requests: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := &limitedFirst{limited: tt.limited, status: tt.status, reset: tt.reset, next: &fakeContents{listings: listings}}
+			client := newFakeClient(t, handler, Options{WaitOnRateLimit: tt.wait})
+
+			root, err := client.Fetch(context.Background(), "")
+			if tt.wantErr {
+				var limitErr *RateLimitError
+				if !errors.As(err, &limitErr) {
+					t.Fatalf("err = %v, want a *RateLimitError", err)
+				}
+				if !limitErr.Reset.Equal(tt.reset) {
+					t.Errorf("reset = %v, want %v", limitErr.Reset, tt.reset)
+				}
+				if root != nil && len(root.Children) > 0 {
+					t.Errorf("a rate-limited response was listed as %v", root.Paths())
+				}
+			} else {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got, want := root.Paths(), []string{"README.md"}; !reflect.DeepEqual(got, want) {
+					t.Errorf("paths = %v, want %v", got, want)
+				}
+				stats := client.Stats()
+				if stats.RateLimit != 60 || stats.RateLimitRemaining != 59 {
+					t.Errorf("rate limit = %d of %d left, want 59 of 60", stats.RateLimitRemaining, stats.RateLimit)
+				}
+			}
+			if got := handler.requests.Load(); got != tt.requests {
+				t.Errorf("%d requests, want %d", got, tt.requests)
+			}
+		})
+	}
+}
+
+// flaky answers its first requests with failures, one each, then passes
+// requests on to next
+type flaky struct {
+	failures []func(w http.ResponseWriter)
+	next     http.Handler
+	requests atomic.Int32
+}
+
+func (h *flaky) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	n := int(h.requests.Add(1))
+	if n <= len(h.failures) {
+		h.failures[n-1](w)
+		return
+	}
+	h.next.ServeHTTP(w, r)
+}
+
+// failWith answers with status, and with Retry-After if retryAfter isn't
+// empty
+func failWith(status int, retryAfter string) func(w http.ResponseWriter) {
+	return func(w http.ResponseWriter) {
+		if retryAfter != "" {
+			w.Header().Set("Retry-After", retryAfter)
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(`{"message": "failed"}`))
+	}
+}
+
+// dropConnection closes the connection without answering
+func dropConnection(w http.ResponseWriter) {
+	conn, _, err := w.(http.Hijacker).Hijack()
+	if err == nil {
+		conn.Close()
+	}
+}
+
+func TestFetchRetries(t *testing.T) {
+	listings := map[string][]File{"": {dir("src"), file("README.md", 1)}, "src": {file("main.go", 1)}}
+
+	tests := []struct {
+		name     string
+		failures []func(w http.ResponseWriter)
+		status   int // of the *APIError expected, if any
+		requests int32
+	}{
+		{name: "no failures", requests: 2},
+		{name: "bad gateway twice", failures: []func(http.ResponseWriter){failWith(502, ""), failWith(502, "")}, requests: 4},
+		{name: "dropped connections twice", failures: []func(http.ResponseWriter){dropConnection, dropConnection}, requests: 4},
+		{name: "secondary rate limit", failures: []func(http.ResponseWriter){failWith(403, "0"), failWith(429, "0")}, requests: 4},
+		{name: "retries spent", failures: []func(http.ResponseWriter){failWith(503, ""), failWith(503, ""), failWith(503, ""), failWith(503, "")}, status: 503, requests: 4},
+		{name: "not found is final", failures: []func(http.ResponseWriter){failWith(404, "")}, status: 404, requests: 1},
+		{name: "forbidden is final", failures: []func(http.ResponseWriter){failWith(403, "")}, status: 403, requests: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := &flaky{failures: tt.failures, next: &fakeContents{listings: listings}}
+			client := newFakeClient(t, handler, Options{Retries: 3, RetryMaxWait: time.Millisecond, Concurrency: 1})
+
+			root, err := client.Fetch(context.Background(), "")
+			if tt.status != 0 {
+				var apiErr *APIError
+				if !errors.As(err, &apiErr) || apiErr.StatusCode != tt.status {
+					t.Fatalf("err = %v, want a %d *APIError", err, tt.status)
+				}
+			} else {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got, want := root.Paths(), []string{"src/", "src/main.go", "README.md"}; !reflect.DeepEqual(got, want) {
+					t.Errorf("paths = %v, want %v", got, want)
+				}
+			}
+			if got := handler.requests.Load(); got != tt.requests {
+				t.Errorf("%d requests, want %d", got, tt.requests)
+			}
+		})
+	}
+}
+
+func TestAPIErrors(t *testing.T) {
+	tests := []struct {
+		status  int
+		body    string
+		want    string // the start of the error message
+		message string
+		docs    string
+	}{
+		{
+			status:  401,
+			body:    `{"message": "Bad credentials", "documentation_url": "https://docs.github.com/rest"}`,
+			want:    "GitHub rejected the access token (401 Unauthorized)",
+			message: "Bad credentials",
+			docs:    "https://docs.github.com/rest",
+		},
+		{
+			status:  403,
+			body:    `{"message": "Resource not accessible by integration"}`,
+			want:    "GitHub denied access (403 Forbidden)",
+			message: "Resource not accessible by integration",
+		},
+		{
+			status:  404,
+			body:    `{"message": "Not Found"}`,
+			want:    "GitHub found nothing (404 Not Found)",
+			message: "Not Found",
+		},
+		{
+			status:  422,
+			body:    `{"message": "Validation Failed"}`,
+			want:    "GitHub could not process the request (422 Unprocessable Entity)",
+			message: "Validation Failed",
+		},
+		{
+			status: 500,
+			body:   "<html>oops</html>",
+			want:   "GitHub API returned 500 Internal Server Error",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(strconv.Itoa(tt.status), func(t *testing.T) {
+			client := newFakeClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}), Options{})
+
+			_, err := client.Fetch(context.Background(), "")
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("err = %v, want an *APIError", err)
+			}
+			if apiErr.StatusCode != tt.status || apiErr.Message != tt.message || apiErr.DocumentationURL != tt.docs {
+				t.Errorf("got status %d, message %q, docs %q; want %d, %q, %q", apiErr.StatusCode, apiErr.Message, apiErr.DocumentationURL, tt.status, tt.message, tt.docs)
+			}
+			if msg := apiErr.Error(); !strings.HasPrefix(msg, tt.want) || tt.message != "" && !strings.HasSuffix(msg, ": "+tt.message) {
+				t.Errorf("message %q, want it to start with %q and end with the API's message", msg, tt.want)
+			}
+		})
+	}
+}
+
+func TestMaxWait(t *testing.T) {
+	listings := map[string][]File{"": {file("README.md", 1)}}
+
+	tests := []struct {
+		name     string
+		handler  func(next http.Handler) http.Handler
+		maxWait  time.Duration
+		wantErr  bool
+		requests int32
+	}{
+		{
+			name: "Retry-After within the limit",
+			handler: func(next http.Handler) http.Handler {
+				return &flaky{failures: []func(http.ResponseWriter){failWith(503, "0")}, next: next}
+			},
+			maxWait:  time.Minute,
+			requests: 2,
+		},
+		{
+			name: "Retry-After past the limit",
+			handler: func(next http.Handler) http.Handler {
+				return &flaky{failures: []func(http.ResponseWriter){failWith(503, "7200")}, next: next}
+			},
+			maxWait:  time.Minute,
+			wantErr:  true,
+			requests: 1,
+		},
+		{
+			name: "rate limit reset within the limit",
+			handler: func(next http.Handler) http.Handler {
+				return &limitedFirst{limited: 1, status: http.StatusForbidden, reset: time.Now().Add(-time.Second), next: next}
+			},
+			maxWait:  time.Minute,
+			requests: 2,
+		},
+		{
+			name: "rate limit reset past the limit",
+			handler: func(next http.Handler) http.Handler {
+				return &limitedFirst{limited: 1, status: http.StatusForbidden, reset: time.Now().Add(time.Hour), next: next}
+			},
+			maxWait:  time.Minute,
+			wantErr:  true,
+			requests: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests atomic.Int32
+			handler := tt.handler(&fakeContents{listings: listings})
+			client := newFakeClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests.Add(1)
+				handler.ServeHTTP(w, r)
+			}), Options{Retries: 3, WaitOnRateLimit: true, MaxWait: tt.maxWait})
+
+			_, err := client.Fetch(context.Background(), "")
+			if tt.wantErr {
+				var limitErr *RateLimitError
+				if !errors.As(err, &limitErr) || limitErr.MaxWait != tt.maxWait {
+					t.Fatalf("err = %v, want a *RateLimitError for the %s limit", err, tt.maxWait)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if got := requests.Load(); got != tt.requests {
+				t.Errorf("%d requests, want %d", got, tt.requests)
+			}
+		})
+	}
+}
+
+func TestRetryAfterIsNotCut(t *testing.T) {
+	client := NewClient(Options{Retries: 3, RetryMaxWait: time.Second})
+	resp := &http.Response{StatusCode: http.StatusServiceUnavailable, Header: http.Header{"Retry-After": {"120"}}}
+
+	delay, retry, err := client.retryDelay(resp, nil, nil, 0)
+	if err != nil || !retry || delay != 2*time.Minute {
+		t.Errorf("retryDelay = %s, %v, %v; want 2m0s, true, no error", delay, retry, err)
+	}
+}
+
+func TestCheckURL(t *testing.T) {
+	var unexpected atomic.Int32
+	client := newFakeClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "HEAD" || r.Header.Get("Authorization") != "" {
+			unexpected.Add(1)
+		}
+		switch r.URL.Path {
+		case "/ok":
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}), Options{Token: "secret", Retries: 1, RetryMaxWait: time.Millisecond})
+	base := client.opts.APIURL
+
+	tests := []struct {
+		path   string
+		status int // of the *APIError expected, if any
+	}{
+		{path: "/ok"},
+		{path: "/private/README.md", status: 404},
+	}
+
+	for _, tt := range tests {
+		err := client.CheckURL(context.Background(), base+tt.path)
+		if tt.status == 0 {
+			if err != nil {
+				t.Errorf("CheckURL(%s) = %v, want no error", tt.path, err)
+			}
+			continue
+		}
+		var apiErr *APIError
+		if !errors.As(err, &apiErr) || apiErr.StatusCode != tt.status {
+			t.Errorf("CheckURL(%s) = %v, want a %d *APIError", tt.path, err, tt.status)
+		}
+	}
+	if n := unexpected.Load(); n > 0 {
+		t.Errorf("%d requests weren't anonymous HEAD requests", n)
+	}
+}
+
+func TestDisplayName(t *testing.T) {
+	node := &Node{Name: "main.go", Path: "src/main.go", Type: "file", HTMLURL: "https://github.com/internal/mirror/blob/main/src/main.go"}
+
+	tests := []struct {
+		name    string
+		opts    Options
+		wantRaw string
+		wantWeb string
+	}{
+		{
+			name:    "fetched repository",
+			opts:    Options{Owner: "internal", Repo: "mirror"},
+			wantRaw: "https://raw.githubusercontent.com/internal/mirror/main/src/main.go",
+			wantWeb: "https://github.com/internal/mirror/blob/main/src/main.go",
+		},
+		{
+			name:    "display name",
+			opts:    Options{Owner: "internal", Repo: "mirror", DisplayName: "public/name"},
+			wantRaw: "https://raw.githubusercontent.com/public/name/main/src/main.go",
+			wantWeb: "https://github.com/public/name/blob/main/src/main.go",
+		},
+		{
+			name:    "display name on GitHub Enterprise",
+			opts:    Options{Owner: "internal", Repo: "mirror", DisplayName: "public/name", APIURL: "https://ghe.example.com/api/v3"},
+			wantRaw: "https://ghe.example.com/raw/public/name/main/src/main.go",
+			wantWeb: "https://ghe.example.com/public/name/blob/main/src/main.go",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := NewClient(tt.opts)
+			if got := client.RawURL("main", node.Path); got != tt.wantRaw {
+				t.Errorf("RawURL = %s, want %s", got, tt.wantRaw)
+			}
+			if got := client.WebURL("main", node); got != tt.wantWeb {
+				t.Errorf("WebURL = %s, want %s", got, tt.wantWeb)
+			}
+		})
+	}
+}
+
+func TestRepositoryCache(t *testing.T) {
+	handler := &flaky{
+		failures: []func(http.ResponseWriter){failWith(404, "")},
+		next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"private": true, "default_branch": "main", "permissions": {"pull": true}}`))
+		}),
+	}
+	client := newFakeClient(t, handler, Options{})
+
+	// A failure isn't kept, so the next call asks again
+	_, err := client.Repository(context.Background())
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != 404 {
+		t.Fatalf("err = %v, want a 404 *APIError", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		info, err := client.Repository(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.DefaultBranch != "main" || !info.Private || !info.CanPull {
+			t.Errorf("call %d: got %+v", i, info)
+		}
+		// Callers changing their copy leave the kept one alone
+		info.DefaultBranch = "changed"
+	}
+	if got := handler.requests.Load(); got != 2 {
+		t.Errorf("%d requests, want 2", got)
+	}
+}
@@ -0,0 +1,81 @@
+package ghtree
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// CodeOwnersPaths are where GitHub looks for a CODEOWNERS file, in order.
+var CodeOwnersPaths = []string{".github/CODEOWNERS", "CODEOWNERS", "docs/CODEOWNERS"}
+
+// CodeOwners holds the rules of a CODEOWNERS file: a pattern in
+// .gitignore syntax, without "!", followed by the users, teams or email
+// addresses owning what it matches. The last matching rule wins.
+type CodeOwners struct {
+	rules []ownersRule
+}
+
+type ownersRule struct {
+	re      *regexp.Regexp
+	dirOnly bool
+	owners  []string
+
+	// shallow rules, ending in "/*", cover a directory's entries but not
+	// what lies below its subdirectories
+	shallow bool
+}
+
+// ParseCodeOwners reads a CODEOWNERS file. A rule without owners leaves
+// what it matches unowned.
+func ParseCodeOwners(src io.Reader) (*CodeOwners, error) {
+	c := &CodeOwners{}
+	scanner := bufio.NewScanner(src)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if i := strings.Index(text, "#"); i >= 0 && (i == 0 || text[i-1] != '\\') {
+			text = text[:i]
+		}
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+
+		pattern := strings.ReplaceAll(fields[0], `\#`, "#")
+		if strings.HasPrefix(pattern, "!") || strings.Trim(pattern, "/") == "" {
+			return nil, fmt.Errorf("line %d: invalid pattern %q", line, fields[0])
+		}
+		re, dirOnly, err := compileGitPattern(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid pattern %q", line, fields[0])
+		}
+		c.rules = append(c.rules, ownersRule{re: re, dirOnly: dirOnly, owners: fields[1:], shallow: strings.HasSuffix(pattern, "/*")})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Owners returns the owners of the entry at path, a repository path without
+// surrounding slashes. A rule matching a directory covers everything below
+// it, unless it ends in "/*".
+func (c *CodeOwners) Owners(path string, isDir bool) []string {
+	if c == nil {
+		return nil
+	}
+	for i := len(c.rules) - 1; i >= 0; i-- {
+		rule := c.rules[i]
+		if (isDir || !rule.dirOnly) && rule.re.MatchString(path) {
+			return rule.owners
+		}
+		for j := 0; j < len(path) && !rule.shallow; j++ {
+			if path[j] == '/' && rule.re.MatchString(path[:j]) {
+				return rule.owners
+			}
+		}
+	}
+	return nil
+}
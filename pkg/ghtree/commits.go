@@ -0,0 +1,126 @@
+package ghtree
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Commit summarizes the last commit to touch a path.
+type Commit struct {
+	SHA string `json:"sha"`
+
+	// Author is the GitHub login of the commit's author, or the name in
+	// the commit when it isn't linked to an account
+	Author string `json:"author"`
+
+	// Date is when the commit was made, by its committer's clock
+	Date time.Time `json:"date"`
+
+	// Message is the first line of the commit message
+	Message string `json:"message"`
+}
+
+// commitCacheEntry is a last commit stored on disk
+type commitCacheEntry struct {
+	Fetched time.Time `json:"fetched"`
+	Commit  *Commit   `json:"commit"`
+}
+
+// LastCommit returns the most recent commit to touch path as of
+// Options.Ref, or nil when path has no history there. It costs a commits
+// API request per path; answers are remembered for the life of the client,
+// and kept in CacheDir for CacheTTL like directory listings.
+func (c *Client) LastCommit(ctx context.Context, path string) (*Commit, error) {
+	path = strings.Trim(path, "/")
+	c.commitsMu.Lock()
+	commit, ok := c.commits[path]
+	c.commitsMu.Unlock()
+	if ok {
+		return commit, nil
+	}
+
+	// The cache key can't collide with a listing's, which holds no NUL
+	cacheKey := "\x00commit\x00" + path
+	if c.opts.CacheDir != "" && c.opts.CacheTTL > 0 {
+		data, err := os.ReadFile(c.cachePath(cacheKey))
+		var entry commitCacheEntry
+		if err == nil && json.Unmarshal(data, &entry) == nil && time.Since(entry.Fetched) <= c.opts.CacheTTL {
+			c.rememberCommit(path, entry.Commit)
+			return entry.Commit, nil
+		}
+	}
+
+	body, err := c.get(ctx, c.lastCommitURL(path))
+	if err != nil {
+		return nil, err
+	}
+
+	var commits []struct {
+		SHA    string `json:"sha"`
+		Commit struct {
+			Author struct {
+				Name string `json:"name"`
+			} `json:"author"`
+			Committer struct {
+				Date time.Time `json:"date"`
+			} `json:"committer"`
+			Message string `json:"message"`
+		} `json:"commit"`
+		Author *struct {
+			Login string `json:"login"`
+		} `json:"author"`
+	}
+	err = json.Unmarshal(body, &commits)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse commits for %s: %w", path, err)
+	}
+	if len(commits) > 0 {
+		found := commits[0]
+		commit = &Commit{SHA: found.SHA, Author: found.Commit.Author.Name, Date: found.Commit.Committer.Date}
+		if found.Author != nil && found.Author.Login != "" {
+			commit.Author = found.Author.Login
+		}
+		commit.Message, _, _ = strings.Cut(found.Commit.Message, "\n")
+	}
+	c.rememberCommit(path, commit)
+
+	if c.opts.CacheDir != "" && c.opts.CacheTTL > 0 {
+		data, err := json.Marshal(commitCacheEntry{Fetched: time.Now(), Commit: commit})
+		if err == nil {
+			err = os.MkdirAll(c.opts.CacheDir, 0700)
+		}
+		if err == nil {
+			err = os.WriteFile(c.cachePath(cacheKey), data, 0600)
+		}
+		if err != nil {
+			c.warnf("failed to cache the last commit of %q: %v", path, err)
+		}
+	}
+	return commit, nil
+}
+
+func (c *Client) rememberCommit(path string, commit *Commit) {
+	c.commitsMu.Lock()
+	defer c.commitsMu.Unlock()
+	if c.commits == nil {
+		c.commits = map[string]*Commit{}
+	}
+	c.commits[path] = commit
+}
+
+// lastCommitURL asks for the most recent commit touching path, which is all
+// LastCommit needs
+func (c *Client) lastCommitURL(path string) string {
+	query := url.Values{}
+	query.Set("path", strings.Trim(path, "/"))
+	if c.opts.Ref != "" {
+		query.Set("sha", c.opts.Ref)
+	}
+	query.Set("per_page", "1")
+	return c.apiURL("repos/%s/%s/commits?%s", c.opts.Owner, c.opts.Repo, query.Encode())
+}
@@ -0,0 +1,62 @@
+package ghtree
+
+import (
+	pathpkg "path"
+	"strings"
+)
+
+// DepthOverride sets the depth limit for the directories Pattern matches.
+// Pattern is written like Options.Exclude's, so "src/**" covers src and
+// everything below it, and the last override matching a directory wins.
+// MaxDepth counts levels like Options.MaxDepth; zero or less means no
+// limit. Directories on the way to what a pattern with a slash names are
+// listed however deep they lie, so the walk can reach it.
+type DepthOverride struct {
+	Pattern  string
+	MaxDepth int
+}
+
+// withinDepth reports whether the directory at path, level levels below
+// the start of the walk, is to be listed
+func (c *Client) withinDepth(path string, level int) bool {
+	limit, onTheWay := c.depthLimit(path)
+	return onTheWay || limit <= 0 || level <= limit
+}
+
+// depthLimit returns the depth limit for the directory at path, and
+// whether it lies on the way to what an override names
+func (c *Client) depthLimit(path string) (limit int, onTheWay bool) {
+	limit = c.opts.MaxDepth
+	for _, o := range c.opts.DepthOverrides {
+		if matchesAny([]string{o.Pattern}, path, pathpkg.Base(path)) {
+			limit = o.MaxDepth
+		} else if leadsTo(o.Pattern, path) {
+			onTheWay = true
+		}
+	}
+	return limit, onTheWay
+}
+
+// leadsTo reports whether what pattern names could lie below the directory
+// at path: each of its segments matches the pattern's segment at the same
+// place, up to a "**". A pattern without a slash can name entries anywhere,
+// so it leads nowhere in particular.
+func leadsTo(pattern, path string) bool {
+	if !strings.Contains(pattern, "/") {
+		return false
+	}
+	patternSegments := strings.Split(pattern, "/")
+	pathSegments := strings.Split(path, "/")
+	if len(pathSegments) >= len(patternSegments) {
+		return false
+	}
+	for i, segment := range pathSegments {
+		if patternSegments[i] == "**" {
+			return true
+		}
+		if ok, _ := pathpkg.Match(patternSegments[i], segment); !ok {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,287 @@
+package ghtree
+
+import (
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io/fs"
+	"os"
+	pathpkg "path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Diff compares path as of two refs and returns a tree of the entries that
+// differ, each marked in Node.Change as "added", "removed" or "modified".
+// Directories leading to a change are included too, unmarked unless they
+// were added or removed as a whole. Both trees come from one git trees API
+// request each; Exclude and Include apply, the depth limit does not.
+func (c *Client) Diff(ctx context.Context, path, base, head string) (*Node, error) {
+	root := strings.Trim(path, "/")
+	baseEntries, err := c.treeAt(ctx, base, root)
+	if err != nil {
+		return nil, err
+	}
+	headEntries, err := c.treeAt(ctx, head, root)
+	if err != nil {
+		return nil, err
+	}
+	return c.diffTrees(root, baseEntries, headEntries, true), nil
+}
+
+// DiffRepository compares path in the client's repository as of
+// Options.Ref with the same path in other's repository as of its Ref, and
+// returns a tree of the differences as Diff does: entries only in other's
+// are "added", entries only in the client's "removed", and files whose
+// blob SHA, mode or type differs "modified". The client's Exclude and
+// Include apply.
+func (c *Client) DiffRepository(ctx context.Context, path string, other *Client) (*Node, error) {
+	root := strings.Trim(path, "/")
+	baseEntries, err := c.treeAt(ctx, c.opts.Ref, root)
+	if err != nil {
+		return nil, fmt.Errorf("%s/%s: %w", c.opts.Owner, c.opts.Repo, err)
+	}
+	headEntries, err := other.treeAt(ctx, other.opts.Ref, root)
+	if err != nil {
+		return nil, fmt.Errorf("%s/%s: %w", other.opts.Owner, other.opts.Repo, err)
+	}
+	return c.diffTrees(root, baseEntries, headEntries, true), nil
+}
+
+// VerifyLocal compares path as of ref with the local checkout dir and
+// returns a tree of the differences as Diff does: entries only in dir are
+// "added", entries missing from it "removed", and files of another type
+// "modified". With compareContents, files whose git blob SHA or mode
+// differs are "modified" too. The .git directory is ignored.
+func (c *Client) VerifyLocal(ctx context.Context, path, ref, dir string, compareContents bool) (*Node, error) {
+	root := strings.Trim(path, "/")
+	remote, err := c.treeAt(ctx, ref, root)
+	if err != nil {
+		return nil, err
+	}
+	local, err := localTree(filepath.Join(dir, filepath.FromSlash(root)), remote, compareContents)
+	if err != nil {
+		return nil, err
+	}
+	return c.diffTrees(root, remote, local, compareContents), nil
+}
+
+// localTree describes every entry below dir the way the git trees API
+// would, keyed by path relative to dir. Blob SHAs are only computed when
+// withSHAs is set. A directory where remote has a submodule stands for the
+// submodule, and isn't walked.
+func localTree(dir string, remote map[string]gitTreeEntry, withSHAs bool) (map[string]gitTreeEntry, error) {
+	entries := map[string]gitTreeEntry{}
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == dir {
+			return nil
+		}
+		if d.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		entry := gitTreeEntry{Path: rel, Type: "blob", Mode: "100644"}
+		switch {
+		case d.IsDir() && remote[rel].Type == "commit":
+			entry.Type, entry.Mode = "commit", "160000"
+			entries[rel] = entry
+			return filepath.SkipDir
+		case d.IsDir():
+			entry.Type, entry.Mode = "tree", "040000"
+		case info.Mode()&fs.ModeSymlink != 0:
+			entry.Mode = "120000"
+			if withSHAs {
+				target, err := os.Readlink(path)
+				if err != nil {
+					return err
+				}
+				entry.SHA = blobSHA([]byte(filepath.ToSlash(target)))
+			}
+		case info.Mode().IsRegular():
+			if info.Mode()&0111 != 0 {
+				entry.Mode = "100755"
+			}
+			if withSHAs {
+				data, err := os.ReadFile(path)
+				if err != nil {
+					return err
+				}
+				entry.SHA = blobSHA(data)
+			}
+		default:
+			return nil
+		}
+		entries[rel] = entry
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// blobSHA returns the git object ID of a blob holding data
+func blobSHA(data []byte) string {
+	h := sha1.New()
+	fmt.Fprintf(h, "blob %d\x00", len(data))
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// blobHash starts the git object hash of n's blob, to be fed its contents,
+// or returns nil if its listing gave no SHA to check them against. LFS
+// files are skipped, since their Size is of the object rather than of the
+// pointer blob.
+func blobHash(n *Node) hash.Hash {
+	if n.LFS {
+		return nil
+	}
+	if _, err := hex.DecodeString(n.SHA); err != nil {
+		return nil
+	}
+	var h hash.Hash
+	switch len(n.SHA) {
+	case 2 * sha1.Size:
+		h = sha1.New()
+	case 2 * sha256.Size:
+		h = sha256.New()
+	default:
+		return nil
+	}
+	fmt.Fprintf(h, "blob %d\x00", n.Size)
+	return h
+}
+
+// diffTrees marks the differences between two trees of entries below root,
+// keyed by relative path. Blob SHAs and modes are only compared when
+// compareContents is set.
+func (c *Client) diffTrees(root string, baseEntries, headEntries map[string]gitTreeEntry, compareContents bool) *Node {
+	// A directory that exists on both sides has changed only through the
+	// entries below it, which are marked on their own
+	changes := map[string]string{}
+	for rel, h := range headEntries {
+		b, ok := baseEntries[rel]
+		switch {
+		case !ok:
+			changes[rel] = "added"
+		case h.Type == "tree" && b.Type == "tree":
+		case h.Type != b.Type:
+			changes[rel] = "modified"
+		case h.Type == "commit" && (h.SHA == "" || b.SHA == ""):
+			// A checked-out submodule's commit isn't known here
+		case compareContents && (b.SHA != h.SHA || b.Mode != h.Mode):
+			changes[rel] = "modified"
+		}
+	}
+	for rel := range baseEntries {
+		if _, ok := headEntries[rel]; !ok {
+			changes[rel] = "removed"
+		}
+	}
+
+	// Group the changed entries, and the directories above them, by parent
+	listings := map[string][]File{}
+	placed := map[string]bool{}
+	var place func(rel string)
+	place = func(rel string) {
+		if placed[rel] {
+			return
+		}
+		placed[rel] = true
+
+		entry, ok := headEntries[rel]
+		if !ok {
+			entry = baseEntries[rel]
+		}
+		dir := pathpkg.Dir(rel)
+		if dir == "." {
+			dir = ""
+		} else {
+			place(dir)
+		}
+		listings[dir] = append(listings[dir], entry.file())
+	}
+	for rel := range changes {
+		// Directories only show up as the parents of files
+		entry, ok := headEntries[rel]
+		if !ok {
+			entry = baseEntries[rel]
+		}
+		if entry.Type == "tree" {
+			continue
+		}
+
+		full := strings.Trim(root+"/"+rel, "/")
+		if matchesAny(c.opts.Exclude, full, pathpkg.Base(rel)) || c.opts.Ignore.Match(full, false) {
+			continue
+		}
+		if len(c.opts.Include) > 0 && !matchesAny(c.opts.Include, full, pathpkg.Base(rel)) {
+			continue
+		}
+		place(rel)
+	}
+
+	var build func(dir string) []*Node
+	build = func(dir string) []*Node {
+		files := listings[dir]
+		sort.Slice(files, func(i, j int) bool {
+			if (files[i].Type == "dir") != (files[j].Type == "dir") {
+				return files[i].Type == "dir"
+			}
+			return lessName(files[i], files[j])
+		})
+
+		nodes := []*Node{}
+		for _, f := range files {
+			rel := strings.Trim(dir+"/"+f.Name, "/")
+			node := c.newNode(root+"/"+rel, f)
+			if node == nil {
+				continue
+			}
+			node.Change = changes[rel]
+			if f.Type == "dir" {
+				node.Children = build(rel)
+			}
+			nodes = append(nodes, node)
+		}
+		return nodes
+	}
+	return &Node{Name: root, Path: root, Type: "dir", Children: build("")}
+}
+
+// treeAt returns every entry below root as of ref, keyed by its path
+// relative to root
+func (c *Client) treeAt(ctx context.Context, ref, root string) (map[string]gitTreeEntry, error) {
+	entries, truncated, err := c.getGitTree(ctx, ref, root, true)
+	if err != nil && ref != "" {
+		return nil, fmt.Errorf("%s: %w", ref, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if truncated {
+		return nil, fmt.Errorf("the tree of %q at %s is too large to compare in one request", root, ref)
+	}
+
+	byPath := make(map[string]gitTreeEntry, len(entries))
+	for _, entry := range entries {
+		byPath[entry.Path] = entry
+	}
+	return byPath, nil
+}
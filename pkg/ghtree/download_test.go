@@ -0,0 +1,67 @@
+package ghtree
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDownloadRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int // answered in turn, then 200
+		retries  int
+		want     string
+		status   int // of the *APIError expected, if any
+		requests int32
+	}{
+		{name: "first try", statuses: nil, retries: 2, want: "hello\n", requests: 1},
+		{name: "bad gateway twice", statuses: []int{502, 502}, retries: 2, want: "hello\n", requests: 3},
+		{name: "retries spent", statuses: []int{503, 503, 503}, retries: 2, status: 503, requests: 3},
+		{name: "not found is final", statuses: []int{404}, retries: 2, status: 404, requests: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := atomic.AddInt32(&requests, 1)
+				if int(n) <= len(tt.statuses) {
+					w.WriteHeader(tt.statuses[n-1])
+					return
+				}
+				w.Write([]byte("hello\n"))
+			}))
+			defer server.Close()
+
+			client := NewClient(Options{APIURL: server.URL, Owner: "o", Repo: "r", Retries: tt.retries, RetryMaxWait: time.Millisecond, Concurrency: 1})
+			var buf bytes.Buffer
+			_, err := client.Download(context.Background(), &Node{Path: "hello.txt", DownloadURL: server.URL + "/hello.txt"}, &buf)
+
+			var apiErr *APIError
+			switch {
+			case tt.status != 0 && (!errors.As(err, &apiErr) || apiErr.StatusCode != tt.status):
+				t.Errorf("err = %v, want a %d *APIError", err, tt.status)
+			case tt.status == 0 && err != nil:
+				t.Errorf("err = %v", err)
+			case buf.String() != tt.want:
+				t.Errorf("downloaded %q, want %q", buf.String(), tt.want)
+			}
+			if requests := atomic.LoadInt32(&requests); requests != tt.requests {
+				t.Errorf("%d requests, want %d", requests, tt.requests)
+			}
+
+			// Every request slot is given back
+			err = client.acquire(context.Background())
+			if err != nil {
+				t.Fatalf("acquire after download: %v", err)
+			}
+			client.release()
+		})
+	}
+}
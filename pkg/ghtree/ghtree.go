@@ -0,0 +1,1170 @@
+// Package ghtree fetches the directory tree of a GitHub repository through
+// the REST contents API and renders it as text or JSON.
+package ghtree
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	pathpkg "path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Options configures a Client and the trees it fetches.
+type Options struct {
+	// Owner and Repo name the repository to list.
+	Owner string
+	Repo  string
+
+	// DisplayName, as owner/name, stands in for Owner and Repo in the URLs
+	// RawURL and WebURL return, for listings published under another name
+	// than the repository they were fetched from, such as a mirror's.
+	DisplayName string
+
+	// Ref is the branch, tag, or commit to list; empty means the default branch.
+	Ref string
+
+	// Provider lists the directories on a forge other than GitHub, such as
+	// GitLabProvider or BitbucketProvider; nil means GitHub. APIURL must
+	// then point at that forge's API.
+	Provider Provider
+
+	// Gist, if set, lists the files of this gist instead of a repository,
+	// and Owner and Repo are ignored. Ref then names a revision of the
+	// gist. Gists have no directories, so Path must be empty, and neither
+	// RecursiveAPI, GraphQL nor the commit-date sort apply.
+	Gist string
+
+	// Path is the directory to start from; empty means the repository root.
+	Path string
+
+	// MaxDepth limits how many levels are fetched; zero or less means no limit.
+	MaxDepth int
+
+	// DepthOverrides replace MaxDepth below the directories they match,
+	// so parts of a shallow tree can be drawn deeper, or the reverse.
+	// MaxDepth counts from Path either way.
+	DepthOverrides []DepthOverride
+
+	// MaxPathDepth is a hard recursion limit that applies even when MaxDepth
+	// is unlimited. Zero means 100.
+	MaxPathDepth int
+
+	// Exclude lists path.Match patterns; entries whose name or repository
+	// path matches any of them are skipped along with their children. A
+	// pattern ending in "/**" also matches the directory it names.
+	Exclude []string
+
+	// Ignore, if set, also skips the entries its .gitignore-style rules
+	// leave out, along with their children.
+	Ignore *IgnoreRules
+
+	// Include, when non-empty, keeps only files whose name or repository
+	// path matches one of these patterns, written like Exclude's.
+	// Directories are still walked, and listed ones left without any
+	// entries are pruned.
+	Include []string
+
+	// RecursiveAPI fetches the whole tree with a single git trees API
+	// request instead of listing each directory. Symlink targets and
+	// submodule URLs are not available this way. A path the trees API
+	// doesn't know, such as a file's, is looked up with the contents API.
+	RecursiveAPI bool
+
+	// GraphQL lists directories through the GraphQL API, three levels per
+	// request, which takes far fewer requests than the contents API for
+	// trees of moderate depth. It needs a token, and like RecursiveAPI it
+	// leaves out symlink targets and download URLs.
+	GraphQL bool
+
+	// IncludeExt, when non-empty, keeps only entries whose names end in one
+	// of these extensions, such as "go" or ".md", ignoring case. Listed
+	// directories left without any entries are pruned.
+	IncludeExt []string
+
+	// MinSize and MaxSize, when non-zero, keep only files of at least and
+	// at most that many bytes, leaving out the entries that aren't files.
+	// Listed directories left without any entries are pruned.
+	MinSize int64
+	MaxSize int64
+
+	// MaxEntries lists only the first this many entries of each directory,
+	// after filtering and sorting, and counts the rest in Node.Omitted
+	// without walking them; zero means no limit.
+	MaxEntries int
+
+	// DirsOnly leaves files out of the tree, keeping only directories.
+	DirsOnly bool
+
+	// Sort orders each directory: "name" case-insensitively, "type" with
+	// directories first and then by name, "size" largest first, "ext" by
+	// extension and then by name, and "commit-date" by each entry's last
+	// commit, oldest first. Empty or "none" keeps the API order.
+	Sort string
+
+	// Reverse inverts the Sort order.
+	Reverse bool
+
+	// DirsFirst lists directories before the other entries under any
+	// Sort, Reverse included.
+	DirsFirst bool
+
+	// CountCollapsed makes one recursive git trees API request up front to
+	// count the entries of the directories the depth limit collapses, for
+	// Node.CollapsedEntries. Only walks on GitHub count them, and only if
+	// the tree fits in one response.
+	CountCollapsed bool
+
+	// Traversal orders the walk: "bfs" lists every directory of one depth
+	// before any below it, so Entry sees the tree level by level. Empty or
+	// "dfs" descends into each directory as soon as its parent is listed.
+	Traversal string
+
+	// FollowSubmodules lists submodules hosted on the same GitHub as the
+	// repository too, as of the commits they are pinned to, within what the
+	// depth limit leaves below them. Their entries' paths carry on from the
+	// submodule's. Submodules leading back to a repository already being
+	// listed are not followed, and gists have none.
+	FollowSubmodules bool
+
+	// Token authenticates requests; empty sends anonymous requests.
+	Token string
+
+	// App, if set, authenticates as a GitHub App installation instead of
+	// with Token. Installation tokens are minted on the first request and
+	// renewed before they expire, however long the walk takes.
+	App *App
+
+	// APIURL is the REST API root; empty means https://api.github.com.
+	APIURL string
+
+	// Concurrency bounds the number of requests in flight; zero means 4.
+	Concurrency int
+
+	// ParallelUntil, when positive, walks the subdirectories of directories
+	// at most that deep concurrently, and below that walks each branch one
+	// directory at a time, so deep trees don't start a goroutine for every
+	// directory. The root is at depth 0, so 1 walks each top-level
+	// directory on a goroutine of its own. Concurrency still bounds the
+	// requests in flight; zero walks every level concurrently.
+	ParallelUntil int
+
+	// RPS caps the request rate across the client; zero means unlimited.
+	RPS float64
+
+	// CacheDir holds directory listings between runs. Listings younger than
+	// CacheTTL are read from it instead of the API, and older ones are
+	// revalidated with If-None-Match; caching is off unless both are set.
+	CacheDir string
+	CacheTTL time.Duration
+
+	// TreeDir keeps the whole tree of a repository whenever one recursive
+	// git trees API request lists all of it, keyed by repository, ref and
+	// tree SHA, for Offline walks. Only the latest tree of each ref is kept.
+	TreeDir string
+
+	// Offline walks the tree stored in TreeDir for Owner, Repo and Ref
+	// instead of sending any request, so the depth limit, filters and sort
+	// can change without another walk; Fetch fails if none is stored.
+	// Stored trees carry no download or page URLs, symlink targets or
+	// submodule URLs.
+	Offline bool
+
+	// Timeout bounds each request; zero means no timeout.
+	Timeout time.Duration
+
+	// Transport sends the requests; nil means http.DefaultTransport, which
+	// honours HTTPS_PROXY and NO_PROXY. Set it to use a proxy or a CA of
+	// your own.
+	Transport http.RoundTripper
+
+	// Retries is how many times a request is retried after a network error,
+	// a server error, or a secondary rate limit. Zero disables retries.
+	Retries int
+
+	// RetryMaxWait caps the delay before a retry, which otherwise doubles
+	// from a second with each attempt. Zero means no cap.
+	RetryMaxWait time.Duration
+
+	// WaitOnRateLimit sleeps until an exhausted rate limit resets instead of
+	// returning a *RateLimitError.
+	WaitOnRateLimit bool
+
+	// MaxWait caps the waits GitHub asks for: the delay of a Retry-After
+	// header, and the time until an exhausted rate limit resets when
+	// WaitOnRateLimit is set. A longer wait returns a *RateLimitError
+	// instead of sleeping. Zero means no cap.
+	MaxWait time.Duration
+
+	// Trace, if set, is called with every request just before it is sent.
+	Trace func(*http.Request)
+
+	// Warnf, if set, receives non-fatal warnings.
+	Warnf func(format string, args ...interface{})
+
+	// Debugf, if set, receives a line for every listed directory, and for
+	// every response unless Tracef is set.
+	Debugf func(format string, args ...interface{})
+
+	// Tracef, if set, receives a line for every response and every request
+	// that failed, instead of Debugf.
+	Tracef func(format string, args ...interface{})
+
+	// Log, if set, receives every line meant for Warnf, Debugf or Tracef
+	// instead of them, along with its details, for structured logs.
+	Log func(entry LogEntry)
+
+	// Listing, if set, is called with every directory listing after
+	// exclusion and sorting. Directories are listed concurrently, so it must
+	// be safe for concurrent use; a non-nil error aborts the fetch.
+	Listing func(path string, files []File) error
+
+	// Entry, if set, is called with every entry the walk keeps as soon as
+	// it is found (with the "bfs" Traversal, once its level is), with its
+	// depth below the root starting at 1. Children
+	// are not filled in yet, and directories the include filters later
+	// leave empty are still reported. Like Listing, it must be safe for
+	// concurrent use, and a non-nil error aborts the fetch.
+	Entry func(node *Node, depth int) error
+
+	// Listed, if set, is called with the entries the walk keeps of each
+	// directory as soon as it is listed, along with how many MaxEntries
+	// omitted, before the walk goes on below them. The directory's path has
+	// no surrounding slashes. Entries are as Entry sees them, and the same
+	// rules apply; TreeStream.Listed fits here.
+	Listed func(path string, entries []*Node, omitted int) error
+}
+
+// Node is an entry in the fetched tree. Type is "file", "dir", "symlink" or
+// "submodule". Children is nil for everything but directories and followed
+// submodules, and for directories beyond the depth limit; it is non-nil once
+// a directory is listed.
+type Node struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+	Type string `json:"type"`
+	Size int64  `json:"size,omitempty"`
+
+	// SHA is the git object ID of the entry's blob or tree
+	SHA string `json:"sha,omitempty"`
+
+	// Executable marks files with the executable bit, which only the git
+	// trees API reports
+	Executable bool `json:"executable,omitempty"`
+
+	// Target is where a symlink points
+	Target string `json:"target,omitempty"`
+
+	// DownloadURL serves a file's raw contents
+	DownloadURL string `json:"-"`
+
+	// HTMLURL is the entry's page on GitHub
+	HTMLURL string `json:"-"`
+
+	// SubmoduleURL and Commit identify the repository and commit a
+	// submodule is pinned to
+	SubmoduleURL string `json:"submoduleUrl,omitempty"`
+	Commit       string `json:"commit,omitempty"`
+
+	// Change marks the entries of a Diff tree as "added", "removed" or
+	// "modified"
+	Change string `json:"change,omitempty"`
+
+	// LFS marks a file stored in Git LFS; Size is then the size of the
+	// object rather than of its pointer. Fetch doesn't set it, since that
+	// takes reading the pointers; see ParseLFSPointer.
+	LFS bool `json:"lfs,omitempty"`
+
+	// Collapsed marks a directory the depth limit kept the walk out of, and
+	// CollapsedEntries counts what it holds when the walk could tell
+	// without listing it; zero means unknown.
+	Collapsed        bool `json:"collapsed,omitempty"`
+	CollapsedEntries int  `json:"collapsedEntries,omitempty"`
+
+	// Omitted counts the entries of a directory left out past
+	// Options.MaxEntries
+	Omitted int `json:"omitted,omitempty"`
+
+	// Hidden counts the entries of a directory that Exclude, Include,
+	// IncludeExt, the size bounds or DirsOnly left out, including
+	// directories pruned for being left empty
+	Hidden int `json:"hidden,omitempty"`
+
+	Children []*Node `json:"children"`
+}
+
+func (n Node) MarshalJSON() ([]byte, error) {
+	type plain Node
+
+	// Listed directories always carry a children array, even an empty one
+	if n.Children != nil {
+		return json.Marshal(plain(n))
+	}
+	return json.Marshal(struct {
+		plain
+		Children []*Node `json:"children,omitempty"`
+	}{plain: plain(n)})
+}
+
+// File is an entry of a contents API directory listing.
+type File struct {
+	Name            string `json:"name"`
+	Type            string `json:"type"`
+	Size            int64  `json:"size"`
+	SHA             string `json:"sha"`
+	Target          string `json:"target"`
+	SubmoduleGitURL string `json:"submodule_git_url"`
+	DownloadURL     string `json:"download_url"`
+	HTMLURL         string `json:"html_url"`
+
+	// Executable is set from the file mode in git trees API listings
+	Executable bool `json:"-"`
+}
+
+// Fetch lists the tree described by opts with a new Client.
+func Fetch(ctx context.Context, opts Options) (*Node, error) {
+	return NewClient(opts).Fetch(ctx, opts.Path)
+}
+
+// Fetch lists the tree rooted at path. The returned root is named after the
+// path, or "." for the repository root. When path names a file, symlink or
+// submodule, the root is that entry. An empty repository has an empty root.
+// If ctx ends after the top level was listed, Fetch returns the partial tree
+// along with an *IncompleteError.
+func (c *Client) Fetch(ctx context.Context, path string) (*Node, error) {
+	provider := c.opts.Provider
+	if provider == nil {
+		provider = GitHubProvider()
+	}
+	_, onGitHub := provider.(githubProvider)
+	_, local := provider.(localProvider)
+	switch {
+	case !onGitHub && (c.opts.RecursiveAPI || c.opts.GraphQL || c.opts.Gist != "" || c.opts.Sort == "commit-date"):
+		return nil, errors.New("RecursiveAPI, GraphQL, Gist and the commit-date sort are only available on GitHub")
+	case !onGitHub && !local && (c.opts.Owner == "" || c.opts.Repo == ""):
+		// Other forges name repositories more freely, as with GitLab's subgroups
+		return nil, errors.New("the owner and repository names are required")
+	case onGitHub && c.opts.Gist == "":
+		err := CheckRepository(c.opts.Owner, c.opts.Repo)
+		if err != nil {
+			return nil, err
+		}
+	}
+	path, err := CleanPath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch c.opts.Sort {
+	case "", "none", "name", "type", "size", "ext", "commit-date":
+	default:
+		return nil, fmt.Errorf("unknown sort mode %q", c.opts.Sort)
+	}
+	switch c.opts.Traversal {
+	case "", "dfs", "bfs":
+	default:
+		return nil, fmt.Errorf("unknown traversal %q", c.opts.Traversal)
+	}
+	for _, pattern := range c.opts.Exclude {
+		if _, err := pathpkg.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid exclude pattern %q: %w", pattern, err)
+		}
+	}
+	for _, pattern := range c.opts.Include {
+		if _, err := pathpkg.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid include pattern %q: %w", pattern, err)
+		}
+	}
+
+	// One trees API request can stand in for every directory listing
+	list := func(ctx context.Context, path string) ([]File, error) {
+		return provider.List(ctx, c, path)
+	}
+	if c.opts.RecursiveAPI && c.opts.GraphQL {
+		return nil, errors.New("RecursiveAPI and GraphQL can't be combined")
+	}
+	if c.opts.GraphQL {
+		lister, err := c.newGraphQLLister(path)
+		if err != nil {
+			return nil, err
+		}
+		list = lister.list
+	}
+	if c.opts.Gist != "" {
+		switch {
+		case path != "":
+			return nil, errors.New("gists have no directories to start from")
+		case c.opts.RecursiveAPI || c.opts.GraphQL || c.opts.Sort == "commit-date":
+			return nil, errors.New("RecursiveAPI, GraphQL and the commit-date sort don't apply to gists")
+		}
+		listings, err := c.fetchGist(ctx)
+		if err != nil {
+			return nil, err
+		}
+		list = listings.list
+	}
+	var count func(path string) int
+	if c.opts.Offline {
+		if !onGitHub || c.opts.Gist != "" || c.opts.GraphQL || c.opts.Sort == "commit-date" || c.opts.FollowSubmodules {
+			return nil, errors.New("offline walks are only available for GitHub repositories, without GraphQL, the commit-date sort or FollowSubmodules")
+		}
+		stored, err := c.loadStoredTree()
+		if err != nil {
+			return nil, err
+		}
+		list, count = stored.list, stored.listings.count
+	} else if c.opts.RecursiveAPI {
+		listings, err := c.fetchGitTree(ctx, path)
+		switch {
+		case err == nil:
+			if listings != nil {
+				list, count = listings.list, listings.count
+			}
+		case isEmptyRepository(err) && strings.Trim(path, "/") == "":
+			return c.emptyRoot(), nil
+		case isMissingTree(err) && strings.Trim(path, "/") != "":
+			// A path naming a file has no tree of its own; the contents API
+			// lists it as a single entry, or says it doesn't exist
+			c.debugf("%q has no git tree; listing it through the contents API", strings.Trim(path, "/"))
+		default:
+			return nil, err
+		}
+	}
+
+	// One more trees API request can count what the depth limit collapses
+	depthLimited := c.opts.MaxDepth > 0 || len(c.opts.DepthOverrides) > 0
+	if count == nil && c.opts.CountCollapsed && depthLimited && onGitHub && c.opts.Gist == "" {
+		counts, err := c.collapsedCounts(ctx, path)
+		if err != nil {
+			c.debugf("can't count the entries of collapsed directories: %v", err)
+		} else if counts != nil {
+			count = counts.count
+		}
+	}
+
+	w := &walk{list: list, count: count, visited: map[string]bool{}}
+	children, omitted, hidden, err := c.fetchFilesAndFolders(ctx, w, path, 1, nil)
+
+	// A path naming a single entry becomes a tree of just that entry
+	var single *notDirectoryError
+	if errors.As(err, &single) {
+		node := c.newNode(path, single.file)
+		if node == nil {
+			return nil, err
+		}
+		if c.opts.FollowSubmodules && onGitHub {
+			err = c.followSubmodules(ctx, []*Node{node}, 0)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return node, nil
+	}
+	if isEmptyRepository(err) && strings.Trim(path, "/") == "" {
+		return c.emptyRoot(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// The root is named after the requested path
+	root := &Node{Name: strings.Trim(path, "/"), Path: strings.Trim(path, "/"), Type: "dir", Children: children, Omitted: omitted, Hidden: hidden}
+	if root.Name == "" {
+		root.Name = "."
+	}
+	if c.opts.FollowSubmodules && onGitHub && c.opts.Gist == "" {
+		err = c.followSubmodules(ctx, root.Children, 1)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Hand back what was listed before the context ended
+	if ctx.Err() != nil {
+		return root, &IncompleteError{Err: stopped(ctx)}
+	}
+	return root, nil
+}
+
+// isMissingTree reports whether err is the trees API's answer for a path
+// that names no tree: a 404, or a 422 for one naming a blob
+func isMissingTree(err error) bool {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	return apiErr.StatusCode == http.StatusNotFound || apiErr.StatusCode == http.StatusUnprocessableEntity
+}
+
+// emptyRoot is the tree of a repository without commits
+func (c *Client) emptyRoot() *Node {
+	c.warnf("repository %s/%s is empty", c.opts.Owner, c.opts.Repo)
+	return &Node{Name: ".", Type: "dir", Children: []*Node{}}
+}
+
+// CheckRepository rejects owner and repository names GitHub could never
+// accept, so typos fail before any request is made.
+func CheckRepository(owner, repo string) error {
+	err := CheckOwner(owner)
+	if err != nil {
+		return err
+	}
+	return checkName("repository", repo)
+}
+
+// CheckOwner rejects owner names GitHub could never accept.
+func CheckOwner(owner string) error {
+	return checkName("owner", owner)
+}
+
+// checkName validates one owner or repository name; kind says which
+func checkName(kind, value string) error {
+	if value == "" {
+		return fmt.Errorf("the %s name is empty", kind)
+	}
+	if value == "." || value == ".." {
+		return fmt.Errorf("invalid %s name %q", kind, value)
+	}
+	for _, r := range value {
+		if !isNameRune(r) {
+			return fmt.Errorf("invalid %s name %q: %q is not allowed", kind, value, r)
+		}
+	}
+	return nil
+}
+
+// isNameRune reports whether r may appear in an owner or repository name
+func isNameRune(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.'
+}
+
+// CleanPath normalizes a repository path: surrounding and repeated slashes
+// are dropped, and "." or ".." segments are rejected.
+func CleanPath(path string) (string, error) {
+	var segments []string
+	for _, segment := range strings.Split(path, "/") {
+		switch segment {
+		case "":
+			continue
+		case ".", "..":
+			return "", fmt.Errorf("invalid path %q: %q segments are not allowed", path, segment)
+		}
+		segments = append(segments, segment)
+	}
+	return strings.Join(segments, "/"), nil
+}
+
+// escapePath escapes each segment of a repository path for use in a URL
+func escapePath(path string) string {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// notDirectoryError reports that a listed path is a single entry
+type notDirectoryError struct {
+	file File
+}
+
+func (e *notDirectoryError) Error() string {
+	return fmt.Sprintf("%s is a %s, not a directory", e.file.Name, e.file.Type)
+}
+
+// newNode builds the node for an entry at path, or returns nil for entry
+// types the tree doesn't show
+func (c *Client) newNode(path string, f File) *Node {
+	node := &Node{Name: f.Name, Path: strings.Trim(path, "/"), Type: f.Type, SHA: f.SHA, HTMLURL: f.HTMLURL}
+	switch f.Type {
+	case "file":
+		node.Size = f.Size
+		node.DownloadURL = f.DownloadURL
+		node.Executable = f.Executable
+	case "dir":
+	case "symlink":
+		node.Target = f.Target
+	case "submodule":
+		node.SubmoduleURL = f.SubmoduleGitURL
+		node.Commit = f.SHA
+	default:
+		return nil
+	}
+	return node
+}
+
+// lister returns the entries of one directory
+type lister func(ctx context.Context, path string) ([]File, error)
+
+// walk is the state shared by every directory of one Fetch
+type walk struct {
+	list lister
+
+	// count, if set, tells how many entries a directory holds without a
+	// request, for those the depth limit collapses
+	count func(path string) int
+
+	mu      sync.Mutex
+	visited map[string]bool
+}
+
+// visit marks path as fetched, reporting false if it already was
+func (w *walk) visit(path string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.visited[path] {
+		return false
+	}
+	w.visited[path] = true
+	return true
+}
+
+// fetchFilesAndFolders lists path and everything below it, depth first
+// unless Options.Traversal asks for breadth first
+func (c *Client) fetchFilesAndFolders(ctx context.Context, w *walk, path string, level int, ancestors []string) ([]*Node, int, int, error) {
+	if c.opts.Traversal == "bfs" {
+		return c.fetchBreadthFirst(ctx, w, path)
+	}
+	return c.fetchDepthFirst(ctx, w, path, level, ancestors)
+}
+
+// subdir is a listed directory still to be walked
+type subdir struct {
+	node      *Node
+	path      string
+	level     int
+	ancestors []string
+}
+
+// listEntries lists path and builds the nodes of its entries, returning
+// the directories among them to walk next. ancestors holds the tree SHAs
+// of the directories above path; git trees can't contain themselves, so
+// meeting one again means the API is echoing a directory into itself.
+// Nodes are nil for a directory left unlisted, beyond the depth limit or
+// listed already.
+func (c *Client) listEntries(ctx context.Context, w *walk, path string, level int, ancestors []string) (nodes []*Node, omitted, hidden int, subdirs []subdir, err error) {
+	// Stop if the maximum depth has been reached; a non-positive maxDepth is unlimited
+	if !c.withinDepth(strings.Trim(path, "/"), level) {
+		return nil, 0, 0, nil, nil
+	}
+
+	// Guard against pathological nesting regardless of maxDepth
+	maxPathDepth := c.opts.MaxPathDepth
+	if maxPathDepth == 0 {
+		maxPathDepth = 100
+	}
+	if level > maxPathDepth {
+		c.warnf("%s is nested deeper than the %d level limit, skipping", strings.Trim(path, "/"), maxPathDepth)
+		return nil, 0, 0, nil, nil
+	}
+
+	// Never fetch the same directory twice in one walk
+	if !w.visit(strings.Trim(path, "/")) {
+		c.warnf("%s was already listed, skipping", strings.Trim(path, "/"))
+		return nil, 0, 0, nil, nil
+	}
+
+	files, err := w.list(ctx, path)
+	if err != nil {
+		return nil, 0, 0, nil, err
+	}
+
+	// Drop excluded entries first so they neither render nor cost requests
+	listed := len(files)
+	files = c.excludeFiles(path, files)
+	hidden = listed - len(files)
+
+	// Order the entries before rendering so connectors stay correct
+	err = c.sortFiles(ctx, path, files)
+	if err != nil {
+		return nil, 0, 0, nil, err
+	}
+
+	// Hand the listing to the caller
+	if c.opts.Listing != nil {
+		err = c.opts.Listing(path, files)
+		if err != nil {
+			return nil, 0, 0, nil, err
+		}
+	}
+
+	// Collect the files and folders in the order of the listing
+	nodes = []*Node{}
+	for _, f := range files {
+		if f.Type != "dir" && c.opts.DirsOnly {
+			hidden++
+			continue
+		}
+		if f.Type != "dir" && !c.hasIncludedExt(f.Name) {
+			hidden++
+			continue
+		}
+		if f.Type != "dir" && len(c.opts.Include) > 0 && !matchesAny(c.opts.Include, strings.Trim(path+"/"+f.Name, "/"), f.Name) {
+			hidden++
+			continue
+		}
+		if f.Type != "dir" && !c.withinSize(f) {
+			hidden++
+			continue
+		}
+
+		node := c.newNode(path+"/"+f.Name, f)
+		if node == nil {
+			continue
+		}
+
+		// Count what is past the cap instead of walking it
+		if c.opts.MaxEntries > 0 && len(nodes) >= c.opts.MaxEntries {
+			omitted++
+			continue
+		}
+		nodes = append(nodes, node)
+		if f.Type != "dir" {
+			continue
+		}
+
+		// Mark a directory the depth limit stops at, and say what is in it
+		// when that costs nothing; one known to be empty is simply listed
+		if !c.withinDepth(node.Path, level+1) {
+			node.Collapsed = true
+			if w.count != nil {
+				node.CollapsedEntries = w.count(node.Path)
+				if node.CollapsedEntries == 0 {
+					node.Collapsed, node.Children = false, []*Node{}
+				}
+			}
+			continue
+		}
+
+		// Skip a directory that claims to be one of its own ancestors
+		if isAncestor(ancestors, f.SHA) {
+			c.warnf("%s repeats a directory above it, skipping", node.Path)
+			continue
+		}
+		subdirs = append(subdirs, subdir{node: node, path: path + "/" + f.Name, level: level + 1, ancestors: append(ancestors[:len(ancestors):len(ancestors)], f.SHA)})
+	}
+	if omitted > 0 {
+		c.debugf("%q has %d more entries than the %d allowed; truncating", strings.Trim(path, "/"), omitted, c.opts.MaxEntries)
+	}
+	if c.opts.Listed != nil {
+		err = c.opts.Listed(strings.Trim(path, "/"), nodes, omitted)
+		if err != nil {
+			return nil, 0, 0, nil, err
+		}
+	}
+	return nodes, omitted, hidden, subdirs, nil
+}
+
+// reportEntries hands nodes to Options.Entry, if set
+func (c *Client) reportEntries(nodes []*Node, level int) error {
+	if c.opts.Entry == nil {
+		return nil
+	}
+	for _, node := range nodes {
+		err := c.opts.Entry(node, level)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fetchDepthFirst lists path, then each subdirectory below it concurrently,
+// down to ParallelUntil, as soon as its parent is listed
+func (c *Client) fetchDepthFirst(ctx context.Context, w *walk, path string, level int, ancestors []string) ([]*Node, int, int, error) {
+	nodes, omitted, hidden, subdirs, err := c.listEntries(ctx, w, path, level, ancestors)
+	if nodes == nil || err != nil {
+		return nil, 0, 0, err
+	}
+	err = c.reportEntries(nodes, level)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	// Each child fills in its own node, so the order stays that of the
+	// listing. Below ParallelUntil, children are walked in turn.
+	var wg sync.WaitGroup
+	var errMu sync.Mutex
+	var firstErr error
+	for _, d := range subdirs {
+		wg.Add(1)
+		walkChild := func(d subdir) {
+			defer wg.Done()
+			children, omitted, hidden, err := c.fetchDepthFirst(ctx, w, d.path, d.level, d.ancestors)
+
+			// A directory cut off by the context stays unlisted
+			if err != nil && ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				errMu.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				errMu.Unlock()
+				return
+			}
+			d.node.Children = children
+			d.node.Omitted = omitted
+			d.node.Hidden = hidden
+		}
+		if c.parallel(d.level) {
+			go walkChild(d)
+		} else {
+			walkChild(d)
+		}
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, 0, 0, firstErr
+	}
+	kept := c.pruneEmptyDirs(nodes)
+	return kept, omitted, hidden + len(nodes) - len(kept), nil
+}
+
+// fetchBreadthFirst lists path, then the directories below it one level at
+// a time: every directory of a level is listed, concurrently down to
+// ParallelUntil, before any of the next. Options.Entry gets each level's entries once the level is done,
+// in the order of the listings.
+func (c *Client) fetchBreadthFirst(ctx context.Context, w *walk, path string) ([]*Node, int, int, error) {
+	nodes, omitted, hidden, subdirs, err := c.listEntries(ctx, w, path, 1, nil)
+	if nodes == nil || err != nil {
+		return nil, 0, 0, err
+	}
+	err = c.reportEntries(nodes, 1)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	var walked []subdir
+	for level := 2; len(subdirs) > 0 && ctx.Err() == nil; level++ {
+		type result struct {
+			nodes           []*Node
+			omitted, hidden int
+			subdirs         []subdir
+			err             error
+		}
+		results := make([]result, len(subdirs))
+		var wg sync.WaitGroup
+		for i, d := range subdirs {
+			wg.Add(1)
+			list := func(i int, d subdir) {
+				defer wg.Done()
+				r := &results[i]
+				r.nodes, r.omitted, r.hidden, r.subdirs, r.err = c.listEntries(ctx, w, d.path, d.level, d.ancestors)
+			}
+			if c.parallel(level) {
+				go list(i, d)
+			} else {
+				list(i, d)
+			}
+		}
+		wg.Wait()
+
+		var next []subdir
+		for i, r := range results {
+			// A directory cut off by the context stays unlisted
+			if r.err != nil && ctx.Err() != nil {
+				continue
+			}
+			if r.err != nil {
+				return nil, 0, 0, r.err
+			}
+			if r.nodes == nil {
+				continue
+			}
+			d := subdirs[i]
+			d.node.Children, d.node.Omitted, d.node.Hidden = r.nodes, r.omitted, r.hidden
+			err = c.reportEntries(r.nodes, level)
+			if err != nil {
+				return nil, 0, 0, err
+			}
+			walked = append(walked, d)
+			next = append(next, r.subdirs...)
+		}
+		subdirs = next
+	}
+
+	// Prune from the deepest directories up, as the depth-first walk does
+	for i := len(walked) - 1; i >= 0; i-- {
+		node := walked[i].node
+		kept := c.pruneEmptyDirs(node.Children)
+		node.Hidden += len(node.Children) - len(kept)
+		node.Children = kept
+	}
+	kept := c.pruneEmptyDirs(nodes)
+	return kept, omitted, hidden + len(nodes) - len(kept), nil
+}
+
+// parallel reports whether directories listed at level, the root's
+// listing being level 1, are walked concurrently with their siblings
+func (c *Client) parallel(level int) bool {
+	return c.opts.ParallelUntil <= 0 || level <= c.opts.ParallelUntil+1
+}
+
+func isAncestor(ancestors []string, sha string) bool {
+	if sha == "" {
+		return false
+	}
+	for _, ancestor := range ancestors {
+		if ancestor == sha {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Client) hasIncludedExt(name string) bool {
+	if len(c.opts.IncludeExt) == 0 {
+		return true
+	}
+
+	name = strings.ToLower(name)
+	for _, ext := range c.opts.IncludeExt {
+		if strings.HasSuffix(name, "."+strings.ToLower(strings.TrimPrefix(ext, "."))) {
+			return true
+		}
+	}
+	return false
+}
+
+// withinSize reports whether f is a file within MinSize and MaxSize, or
+// true when neither is set
+func (c *Client) withinSize(f File) bool {
+	if c.opts.MinSize == 0 && c.opts.MaxSize == 0 {
+		return true
+	}
+	if f.Type != "file" {
+		return false
+	}
+	return f.Size >= c.opts.MinSize && (c.opts.MaxSize == 0 || f.Size <= c.opts.MaxSize)
+}
+
+// pruneEmptyDirs drops listed directories that the include filters or size
+// bounds left empty. Directories beyond the depth limit were never listed
+// and are kept.
+func (c *Client) pruneEmptyDirs(nodes []*Node) []*Node {
+	if len(c.opts.IncludeExt) == 0 && len(c.opts.Include) == 0 && c.opts.MinSize == 0 && c.opts.MaxSize == 0 {
+		return nodes
+	}
+
+	kept := nodes[:0]
+	for _, node := range nodes {
+		if node.Type == "dir" && node.Children != nil && len(node.Children) == 0 && node.Omitted == 0 {
+			continue
+		}
+		kept = append(kept, node)
+	}
+	return kept
+}
+
+// contentsLimit is the most entries the contents API lists for a directory
+const contentsLimit = 1000
+
+// listDirectory returns the complete listing of path, from the cache when a
+// fresh copy is there or GitHub confirms a stale one is unchanged
+func (c *Client) listDirectory(ctx context.Context, path string) ([]File, error) {
+	cached, fresh := c.cachedListing(path)
+	if fresh {
+		c.logf("debug", map[string]interface{}{"path": strings.Trim(path, "/"), "entries": len(cached.Files), "cache": "fresh"}, "listed %q: %d entries (cached)", strings.Trim(path, "/"), len(cached.Files))
+		return cached.Files, nil
+	}
+	etag := ""
+	if cached != nil {
+		etag = cached.ETag
+	}
+
+	// Make the API request, following pagination until the listing is complete
+	files := []File{}
+	listingETag := ""
+	for listingURL, first := c.contentsURL(path), true; listingURL != ""; first = false {
+		if !first {
+			etag = ""
+		}
+		body, next, newETag, notModified, err := c.getPageIfChanged(ctx, listingURL, etag)
+		if err != nil {
+			return nil, c.describeListingError(err, path)
+		}
+		if notModified {
+			c.logf("debug", map[string]interface{}{"path": strings.Trim(path, "/"), "entries": len(cached.Files), "cache": "unchanged"}, "listed %q: %d entries (cached, unchanged)", strings.Trim(path, "/"), len(cached.Files))
+			c.storeListing(path, cached.Files, cached.ETag)
+			return cached.Files, nil
+		}
+
+		// Only a single-page listing can be revalidated as a whole
+		if first && next == "" {
+			listingETag = newETag
+		}
+
+		// A file path answers with the file's object instead of a listing
+		if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] == '{' {
+			var file File
+			err = json.Unmarshal(trimmed, &file)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse the entry at %q: %w", strings.Trim(path, "/"), err)
+			}
+			return nil, &notDirectoryError{file: file}
+		}
+
+		// Unmarshal the response into a slice of File structs
+		var page []File
+		err = json.Unmarshal(body, &page)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse the listing of %q: %w", strings.Trim(path, "/"), err)
+		}
+		files = append(files, page...)
+		listingURL = next
+	}
+
+	// The contents API stops at 1000 entries without saying so
+	if len(files) >= contentsLimit {
+		c.debugf("%q reached the contents API limit of %d entries; completing it from the git trees API", strings.Trim(path, "/"), contentsLimit)
+		var err error
+		files, err = c.completeListing(ctx, path, files)
+		if err != nil {
+			return nil, err
+		}
+		listingETag = ""
+	}
+
+	c.logf("debug", map[string]interface{}{"path": strings.Trim(path, "/"), "entries": len(files)}, "listed %q: %d entries", strings.Trim(path, "/"), len(files))
+	c.storeListing(path, files, listingETag)
+	return files, nil
+}
+
+func (c *Client) excludeFiles(path string, files []File) []File {
+	if len(c.opts.Exclude) == 0 && c.opts.Ignore == nil {
+		return files
+	}
+
+	kept := files[:0]
+	for _, f := range files {
+		full := strings.Trim(path+"/"+f.Name, "/")
+		if !matchesAny(c.opts.Exclude, full, f.Name) && !c.opts.Ignore.Match(full, f.Type == "dir") {
+			kept = append(kept, f)
+		}
+	}
+	return kept
+}
+
+// matchesAny reports whether an entry's name or repository path matches one
+// of patterns. Patterns may name an entry anywhere or spell out its path.
+func matchesAny(patterns []string, fullPath, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := pathpkg.Match(pattern, name); ok {
+			return true
+		}
+		if ok, _ := pathpkg.Match(pattern, fullPath); ok {
+			return true
+		}
+
+		// "dir/**" covers dir itself and everything below it
+		if dir := strings.TrimSuffix(pattern, "/**"); dir != pattern {
+			for prefix := fullPath; prefix != "."; prefix = pathpkg.Dir(prefix) {
+				if ok, _ := pathpkg.Match(dir, prefix); ok {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func (c *Client) contentsURL(path string) string {
+	contents := c.apiURL("repos/%s/%s/contents/%s", c.opts.Owner, c.opts.Repo, escapePath(path))
+
+	// Without a ref the API lists the default branch
+	if c.opts.Ref != "" {
+		contents += "?ref=" + url.QueryEscape(c.opts.Ref)
+	}
+	return contents
+}
+
+func (c *Client) sortFiles(ctx context.Context, path string, files []File) error {
+	var less func(a, b File) bool
+	switch c.opts.Sort {
+	case "", "none":
+	case "name":
+		less = lessName
+	case "type":
+		less = func(a, b File) bool {
+			if (a.Type == "dir") != (b.Type == "dir") {
+				return a.Type == "dir"
+			}
+			return lessName(a, b)
+		}
+	case "size":
+		less = func(a, b File) bool {
+			if a.Size != b.Size {
+				return a.Size > b.Size
+			}
+			return lessName(a, b)
+		}
+	case "ext":
+		less = func(a, b File) bool {
+			ea, eb := strings.ToLower(pathpkg.Ext(a.Name)), strings.ToLower(pathpkg.Ext(b.Name))
+			if ea != eb {
+				return ea < eb
+			}
+			return lessName(a, b)
+		}
+	case "commit-date":
+		// Look up the last commit touching each entry
+		dates := make(map[string]time.Time, len(files))
+		for _, f := range files {
+			date, err := c.fetchLastCommitDate(ctx, path+"/"+f.Name)
+			if err != nil {
+				return err
+			}
+			dates[f.Name] = date
+		}
+		less = func(a, b File) bool {
+			return dates[a.Name].Before(dates[b.Name])
+		}
+	}
+
+	if less != nil {
+		sort.SliceStable(files, func(i, j int) bool {
+			if c.opts.Reverse {
+				return less(files[j], files[i])
+			}
+			return less(files[i], files[j])
+		})
+	}
+
+	// Directories lead whichever way the rest is ordered
+	if c.opts.DirsFirst {
+		sort.SliceStable(files, func(i, j int) bool {
+			return files[i].Type == "dir" && files[j].Type != "dir"
+		})
+	}
+	return nil
+}
+
+// lessName orders names case-insensitively, breaking ties by exact bytes
+func lessName(a, b File) bool {
+	la, lb := strings.ToLower(a.Name), strings.ToLower(b.Name)
+	if la != lb {
+		return la < lb
+	}
+	return a.Name < b.Name
+}
+
+// fetchLastCommitDate returns when path last changed; entries without
+// history sort as the oldest
+func (c *Client) fetchLastCommitDate(ctx context.Context, path string) (time.Time, error) {
+	commit, err := c.LastCommit(ctx, path)
+	if err != nil || commit == nil {
+		return time.Time{}, err
+	}
+	return commit.Date, nil
+}
@@ -0,0 +1,809 @@
+package ghtree
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	pathpkg "path"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeContents serves the contents API of the repository o/r from
+// listings, which map each directory path to its entries. With a pageSize,
+// listings come that many entries a page, linked by Link headers.
+type fakeContents struct {
+	listings map[string][]File
+	pageSize int
+	requests atomic.Int32
+}
+
+func (f *fakeContents) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.requests.Add(1)
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/repos/o/r/contents"), "/")
+	files, ok := f.listings[path]
+	if !ok {
+		// A file answers with its own entry
+		dir, name := pathpkg.Split(path)
+		for _, file := range f.listings[strings.TrimSuffix(dir, "/")] {
+			if file.Name == name {
+				json.NewEncoder(w).Encode(file)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"message": "Not Found"}`))
+		return
+	}
+
+	if f.pageSize > 0 {
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		if page < 1 {
+			page = 1
+		}
+		start, end := (page-1)*f.pageSize, page*f.pageSize
+		if end < len(files) {
+			w.Header().Set("Link", fmt.Sprintf(`<http://%s%s?page=%d>; rel="next"`, r.Host, r.URL.Path, page+1))
+		} else {
+			end = len(files)
+		}
+		files = files[start:end]
+	}
+	json.NewEncoder(w).Encode(files)
+}
+
+// newFakeClient returns a client of o/r, with opts, served by handler
+func newFakeClient(t *testing.T, handler http.Handler, opts Options) *Client {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	opts.APIURL, opts.Owner, opts.Repo = server.URL, "o", "r"
+	return NewClient(opts)
+}
+
+// dir and file make listing entries
+func dir(name string) File {
+	return File{Name: name, Type: "dir"}
+}
+
+func file(name string, size int64) File {
+	return File{Name: name, Type: "file", Size: size}
+}
+
+func TestListDirectoryPagination(t *testing.T) {
+	tests := []struct {
+		name     string
+		entries  int
+		pageSize int
+		requests int32
+	}{
+		{name: "one page", entries: 3, pageSize: 5, requests: 1},
+		{name: "two pages", entries: 4, pageSize: 2, requests: 2},
+		{name: "partial last page", entries: 5, pageSize: 2, requests: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var files []File
+			var want []string
+			for i := 0; i < tt.entries; i++ {
+				name := fmt.Sprintf("file%d.txt", i)
+				files = append(files, file(name, 1))
+				want = append(want, name)
+			}
+			fake := &fakeContents{listings: map[string][]File{"": files}, pageSize: tt.pageSize}
+			client := newFakeClient(t, fake, Options{})
+
+			root, err := client.Fetch(context.Background(), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := root.Paths(); !reflect.DeepEqual(got, want) {
+				t.Errorf("paths = %v, want %v", got, want)
+			}
+			if got := fake.requests.Load(); got != tt.requests {
+				t.Errorf("%d requests, want %d", got, tt.requests)
+			}
+		})
+	}
+}
+
+// nestedListings is a tree four directories deep
+var nestedListings = map[string][]File{
+	"":        {dir("a"), file("top.txt", 1)},
+	"a":       {dir("b"), file("a.txt", 1)},
+	"a/b":     {dir("c")},
+	"a/b/c":   {dir("d"), file("c.txt", 1)},
+	"a/b/c/d": {file("d.txt", 1)},
+}
+
+func TestFetchMaxDepth(t *testing.T) {
+	tests := []struct {
+		name     string
+		maxDepth int
+		want     []string
+		requests int32
+	}{
+		{
+			name:     "depth 1",
+			maxDepth: 1,
+			want:     []string{"a/", "top.txt"},
+			requests: 1,
+		},
+		{
+			name:     "depth 3",
+			maxDepth: 3,
+			want:     []string{"a/", "a/b/", "a/b/c/", "a/a.txt", "top.txt"},
+			requests: 3,
+		},
+		{
+			name:     "unlimited as 0",
+			maxDepth: 0,
+			want:     []string{"a/", "a/b/", "a/b/c/", "a/b/c/d/", "a/b/c/d/d.txt", "a/b/c/c.txt", "a/a.txt", "top.txt"},
+			requests: 5,
+		},
+		{
+			name:     "unlimited as -1",
+			maxDepth: -1,
+			want:     []string{"a/", "a/b/", "a/b/c/", "a/b/c/d/", "a/b/c/d/d.txt", "a/b/c/c.txt", "a/a.txt", "top.txt"},
+			requests: 5,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeContents{listings: nestedListings}
+			client := newFakeClient(t, fake, Options{MaxDepth: tt.maxDepth})
+
+			root, err := client.Fetch(context.Background(), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := root.Paths(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("paths = %v, want %v", got, tt.want)
+			}
+			if got := fake.requests.Load(); got != tt.requests {
+				t.Errorf("%d requests, want %d", got, tt.requests)
+			}
+		})
+	}
+}
+
+func TestFetchExclude(t *testing.T) {
+	listings := map[string][]File{
+		"":                      {dir("node_modules"), dir("src"), file("app.log", 1), file("package.json", 1)},
+		"node_modules":          {dir("left-pad")},
+		"node_modules/left-pad": {file("index.js", 1)},
+		"src":                   {dir("node_modules"), file("debug.log", 1), file("main.js", 1)},
+		"src/node_modules":      {file("x.js", 1)},
+	}
+
+	tests := []struct {
+		name     string
+		exclude  []string
+		want     []string
+		requests int32
+	}{
+		{
+			name:     "nothing",
+			want:     []string{"node_modules/", "node_modules/left-pad/", "node_modules/left-pad/index.js", "src/", "src/node_modules/", "src/node_modules/x.js", "src/debug.log", "src/main.js", "app.log", "package.json"},
+			requests: 5,
+		},
+		{
+			name:     "a directory anywhere, with what is below it",
+			exclude:  []string{"node_modules"},
+			want:     []string{"src/", "src/debug.log", "src/main.js", "app.log", "package.json"},
+			requests: 2,
+		},
+		{
+			name:     "node_modules and logs",
+			exclude:  []string{"node_modules", "*.log"},
+			want:     []string{"src/", "src/main.js", "package.json"},
+			requests: 2,
+		},
+		{
+			name:     "a full path",
+			exclude:  []string{"src/node_modules"},
+			want:     []string{"node_modules/", "node_modules/left-pad/", "node_modules/left-pad/index.js", "src/", "src/debug.log", "src/main.js", "app.log", "package.json"},
+			requests: 4,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeContents{listings: listings}
+			client := newFakeClient(t, fake, Options{Exclude: tt.exclude})
+
+			root, err := client.Fetch(context.Background(), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := root.Paths(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("paths = %v, want %v", got, tt.want)
+			}
+			if got := fake.requests.Load(); got != tt.requests {
+				t.Errorf("%d requests, want %d", got, tt.requests)
+			}
+		})
+	}
+}
+
+// wideListings is a tree of width directories, each holding width files
+func wideListings(width int) map[string][]File {
+	listings := map[string][]File{}
+	for i := 0; i < width; i++ {
+		name := fmt.Sprintf("dir%d", i)
+		listings[""] = append(listings[""], dir(name))
+		for j := 0; j < width; j++ {
+			listings[name] = append(listings[name], file(fmt.Sprintf("file%d.txt", j), 1))
+		}
+	}
+	return listings
+}
+
+// slow delays each response by latency, as a distant server would
+func slow(latency time.Duration, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(latency)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func TestFetchConcurrencyKeepsOrder(t *testing.T) {
+	listings := wideListings(8)
+	var want []string
+	for _, concurrency := range []int{1, 2, 8} {
+		t.Run(fmt.Sprintf("concurrency %d", concurrency), func(t *testing.T) {
+			handler := slow(time.Millisecond, &fakeContents{listings: listings})
+			client := newFakeClient(t, handler, Options{Concurrency: concurrency})
+
+			root, err := client.Fetch(context.Background(), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := root.Paths()
+			if want == nil {
+				want = got
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("paths = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func BenchmarkFetch(b *testing.B) {
+	listings := wideListings(16)
+	for _, concurrency := range []int{1, 8} {
+		b.Run(fmt.Sprintf("concurrency %d", concurrency), func(b *testing.B) {
+			server := httptest.NewServer(slow(5*time.Millisecond, &fakeContents{listings: listings}))
+			defer server.Close()
+
+			for i := 0; i < b.N; i++ {
+				client := NewClient(Options{APIURL: server.URL, Owner: "o", Repo: "r", Concurrency: concurrency})
+				_, err := client.Fetch(context.Background(), "")
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestFetchDirsOnly(t *testing.T) {
+	listings := map[string][]File{
+		"":         {dir("cmd"), file("go.mod", 1), dir("pkg"), file("README.md", 1)},
+		"cmd":      {file("main.go", 1)},
+		"pkg":      {dir("api"), dir("util"), file("doc.go", 1)},
+		"pkg/api":  {file("api.go", 1)},
+		"pkg/util": {},
+	}
+
+	tests := []struct {
+		name     string
+		dirsOnly bool
+		want     string
+	}{
+		{
+			name: "files and directories",
+			want: `├── cmd
+│   └── main.go
+├── go.mod
+├── pkg
+│   ├── api
+│   │   └── api.go
+│   ├── util
+│   └── doc.go
+└── README.md
+`,
+		},
+		{
+			name:     "directories only",
+			dirsOnly: true,
+			want: `├── cmd
+└── pkg
+    ├── api
+    └── util
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newFakeClient(t, &fakeContents{listings: listings}, Options{DirsOnly: tt.dirsOnly})
+
+			root, err := client.Fetch(context.Background(), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var buf bytes.Buffer
+			err = root.Render(&buf, RenderOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if buf.String() != tt.want {
+				t.Errorf("rendered\n%s\nwant\n%s", buf.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestFetchIncludeExt(t *testing.T) {
+	listings := map[string][]File{
+		"":         {dir("assets"), dir("docs"), dir("src"), file("README.md", 1), file("go.mod", 1)},
+		"assets":   {},
+		"docs":     {dir("img"), file("guide.MD", 1)},
+		"docs/img": {file("logo.png", 1)},
+		"src":      {dir("lib"), file("main.go", 1)},
+		"src/lib":  {file("lib.go", 1), file("lib_test.go", 1)},
+	}
+
+	tests := []struct {
+		name string
+		exts []string
+		want []string
+	}{
+		{
+			name: "none",
+			want: []string{"assets/", "docs/", "docs/img/", "docs/img/logo.png", "docs/guide.MD", "src/", "src/lib/", "src/lib/lib.go", "src/lib/lib_test.go", "src/main.go", "README.md", "go.mod"},
+		},
+		{
+			name: "one",
+			exts: []string{"go"},
+			want: []string{"src/", "src/lib/", "src/lib/lib.go", "src/lib/lib_test.go", "src/main.go"},
+		},
+		{
+			name: "several, with dots and any case",
+			exts: []string{".md", "PNG"},
+			want: []string{"docs/", "docs/img/", "docs/img/logo.png", "docs/guide.MD", "README.md"},
+		},
+		{
+			name: "matching nothing",
+			exts: []string{"rs"},
+			want: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		for _, traversal := range []string{"dfs", "bfs"} {
+			t.Run(tt.name+", "+traversal, func(t *testing.T) {
+				client := newFakeClient(t, &fakeContents{listings: listings}, Options{IncludeExt: tt.exts, Traversal: traversal})
+
+				root, err := client.Fetch(context.Background(), "")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := root.Paths(); !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("paths = %v, want %v", got, tt.want)
+				}
+			})
+		}
+	}
+}
+
+func TestFetchSingleFile(t *testing.T) {
+	listings := map[string][]File{
+		"":    {dir("src"), file("README.md", 2048)},
+		"src": {file("main.go", 10)},
+	}
+
+	tests := []struct {
+		name string
+		path string
+		opts RenderOptions
+		want string
+	}{
+		{name: "top-level file", path: "README.md", want: "└── README.md\n"},
+		{name: "nested file", path: "src/main.go", want: "└── main.go\n"},
+		{name: "with its size", path: "README.md", opts: RenderOptions{Sizes: true}, want: "└── README.md (2.0 KB)\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeContents{listings: listings}
+			client := newFakeClient(t, fake, Options{})
+
+			root, err := client.Fetch(context.Background(), tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var buf bytes.Buffer
+			err = root.Render(&buf, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if buf.String() != tt.want {
+				t.Errorf("rendered %q, want %q", buf.String(), tt.want)
+			}
+			if got := fake.requests.Load(); got != 1 {
+				t.Errorf("%d requests, want 1", got)
+			}
+		})
+	}
+}
+
+func TestCheckRepository(t *testing.T) {
+	tests := []struct {
+		owner, repo string
+		wantErr     bool
+	}{
+		{owner: "golang", repo: "go"},
+		{owner: "some-org", repo: "my_repo.js"},
+		{owner: "", repo: "go", wantErr: true},
+		{owner: "golang", repo: "", wantErr: true},
+		{owner: "golang/go", repo: "go", wantErr: true},
+		{owner: "golang", repo: "go/src", wantErr: true},
+		{owner: "golang", repo: "my repo", wantErr: true},
+		{owner: "golang", repo: "..", wantErr: true},
+		{owner: "gölang", repo: "go", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		err := CheckRepository(tt.owner, tt.repo)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("CheckRepository(%q, %q) = %v, want an error: %v", tt.owner, tt.repo, err, tt.wantErr)
+		}
+	}
+}
+
+func TestCleanPath(t *testing.T) {
+	tests := []struct {
+		path    string
+		want    string
+		wantErr bool
+	}{
+		{path: "", want: ""},
+		{path: "/", want: ""},
+		{path: "/docs/", want: "docs"},
+		{path: "//docs//guide", want: "docs/guide"},
+		{path: "my docs/read me.md", want: "my docs/read me.md"},
+		{path: "/données/ünïcode.txt", want: "données/ünïcode.txt"},
+		{path: "docs/../secrets", wantErr: true},
+		{path: "./docs", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := CleanPath(tt.path)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("CleanPath(%q) = %q, %v; want %q, an error: %v", tt.path, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestFetchEscapesPaths(t *testing.T) {
+	listings := map[string][]File{
+		"":        {dir("my docs"), dir("données")},
+		"my docs": {file("read me.md", 1)},
+		"données": {file("ünïcode.txt", 1), file("100%.txt", 1)},
+	}
+
+	tests := []struct {
+		name string
+		path string
+		sent []string // the escaped paths requested
+		want []string
+	}{
+		{
+			name: "spaces",
+			path: "my docs",
+			sent: []string{"/repos/o/r/contents/my%20docs"},
+			want: []string{"read me.md"},
+		},
+		{
+			name: "leading and trailing slashes",
+			path: "/my docs/",
+			sent: []string{"/repos/o/r/contents/my%20docs"},
+			want: []string{"read me.md"},
+		},
+		{
+			name: "unicode",
+			path: "données",
+			sent: []string{"/repos/o/r/contents/donn%C3%A9es"},
+			want: []string{"ünïcode.txt", "100%.txt"},
+		},
+		{
+			name: "a file with a percent sign",
+			path: "données/100%.txt",
+			sent: []string{"/repos/o/r/contents/donn%C3%A9es/100%25.txt"},
+			want: []string{"100%.txt"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var sent []string
+			fake := &fakeContents{listings: listings}
+			client := newFakeClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				sent = append(sent, r.URL.EscapedPath())
+				mu.Unlock()
+				fake.ServeHTTP(w, r)
+			}), Options{})
+
+			root, err := client.Fetch(context.Background(), tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(sent, tt.sent) {
+				t.Errorf("requested %v, want %v", sent, tt.sent)
+			}
+			if got := root.Paths(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("paths = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFetchRejectsInvalidNames(t *testing.T) {
+	tests := []struct {
+		name        string
+		owner, repo string
+		path        string
+	}{
+		{name: "empty owner", owner: "", repo: "r"},
+		{name: "slash in the repository", owner: "o", repo: "r/x"},
+		{name: "space in the owner", owner: "o o", repo: "r"},
+		{name: "parent segment in the path", owner: "o", repo: "r", path: "docs/../.."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeContents{listings: map[string][]File{"": {}}}
+			server := httptest.NewServer(fake)
+			defer server.Close()
+			client := NewClient(Options{APIURL: server.URL, Owner: tt.owner, Repo: tt.repo})
+
+			_, err := client.Fetch(context.Background(), tt.path)
+			if err == nil {
+				t.Fatal("no error")
+			}
+			if got := fake.requests.Load(); got != 0 {
+				t.Errorf("%d requests, want none before the names are checked", got)
+			}
+		})
+	}
+}
+
+// endless lists a directory d, with a tree SHA of its own, inside every
+// directory it is asked for
+type endless struct {
+	requests atomic.Int32
+}
+
+func (e *endless) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.requests.Add(1)
+	json.NewEncoder(w).Encode([]File{{Name: "d", Type: "dir", SHA: r.URL.Path}})
+}
+
+func TestFetchCycles(t *testing.T) {
+	// a lists itself, under its own name and tree SHA
+	echoing := map[string][]File{
+		"":  {{Name: "a", Type: "dir", SHA: "sha-a"}, file("top.txt", 1)},
+		"a": {{Name: "a", Type: "dir", SHA: "sha-a"}, file("a.txt", 1)},
+	}
+
+	tests := []struct {
+		name         string
+		endless      bool
+		maxPathDepth int
+		want         []string
+		warning      string
+		requests     int32
+	}{
+		{
+			name:     "a directory listing itself",
+			want:     []string{"a/", "a/a/", "a/a.txt", "top.txt"},
+			warning:  "a/a repeats a directory above it, skipping",
+			requests: 2,
+		},
+		{
+			name:         "endless nesting",
+			endless:      true,
+			maxPathDepth: 3,
+			want:         []string{"d/", "d/d/", "d/d/d/"},
+			warning:      "d/d/d is nested deeper than the 3 level limit, skipping",
+			requests:     3,
+		},
+	}
+
+	for _, tt := range tests {
+		for _, traversal := range []string{"dfs", "bfs"} {
+			t.Run(tt.name+", "+traversal, func(t *testing.T) {
+				var warnings []string
+				var mu sync.Mutex
+				opts := Options{MaxPathDepth: tt.maxPathDepth, Traversal: traversal, Warnf: func(format string, args ...interface{}) {
+					mu.Lock()
+					warnings = append(warnings, fmt.Sprintf(format, args...))
+					mu.Unlock()
+				}}
+
+				var handler http.Handler
+				var requests func() int32
+				if tt.endless {
+					fake := &endless{}
+					handler, requests = fake, fake.requests.Load
+				} else {
+					fake := &fakeContents{listings: echoing}
+					handler, requests = fake, fake.requests.Load
+				}
+				client := newFakeClient(t, handler, opts)
+
+				root, err := client.Fetch(context.Background(), "")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := root.Paths(); !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("paths = %v, want %v", got, tt.want)
+				}
+				if want := []string{tt.warning}; !reflect.DeepEqual(warnings, want) {
+					t.Errorf("warnings = %q, want %q", warnings, want)
+				}
+				if got := requests(); got != tt.requests {
+					t.Errorf("%d requests, want %d", got, tt.requests)
+				}
+			})
+		}
+	}
+}
+
+func TestFetchRecursiveAPISingleFile(t *testing.T) {
+	listings := map[string][]File{
+		"":    {dir("src"), file("README.md", 1)},
+		"src": {file("main.go", 1)},
+	}
+
+	tests := []struct {
+		name       string
+		path       string
+		treeStatus int // answered by the trees API
+		want       []string
+		status     int // of the *APIError expected, if any
+	}{
+		{name: "file, trees API answers 404", path: "README.md", treeStatus: http.StatusNotFound, want: []string{"README.md"}},
+		{name: "nested file, trees API answers 422", path: "src/main.go", treeStatus: http.StatusUnprocessableEntity, want: []string{"main.go"}},
+		{name: "missing path", path: "nope", treeStatus: http.StatusNotFound, status: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeContents{listings: listings}
+			var treeRequests atomic.Int32
+			client := newFakeClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if strings.HasPrefix(r.URL.Path, "/repos/o/r/git/trees/") {
+					treeRequests.Add(1)
+					w.WriteHeader(tt.treeStatus)
+					w.Write([]byte(`{"message": "Not Found"}`))
+					return
+				}
+				fake.ServeHTTP(w, r)
+			}), Options{RecursiveAPI: true})
+
+			root, err := client.Fetch(context.Background(), tt.path)
+			if tt.status != 0 {
+				var apiErr *APIError
+				if !errors.As(err, &apiErr) || apiErr.StatusCode != tt.status {
+					t.Fatalf("err = %v, want a %d *APIError", err, tt.status)
+				}
+			} else {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := root.Paths(); !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("paths = %v, want %v", got, tt.want)
+				}
+			}
+			if treeRequests.Load() != 1 || fake.requests.Load() != 1 {
+				t.Errorf("%d trees and %d contents requests, want 1 of each", treeRequests.Load(), fake.requests.Load())
+			}
+		})
+	}
+}
+
+// inFlight counts the requests next is serving at once, keeping the most
+type inFlight struct {
+	next    http.Handler
+	mu      sync.Mutex
+	current int
+	most    int
+}
+
+func (h *inFlight) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	h.current++
+	if h.current > h.most {
+		h.most = h.current
+	}
+	h.mu.Unlock()
+
+	h.next.ServeHTTP(w, r)
+
+	h.mu.Lock()
+	h.current--
+	h.mu.Unlock()
+}
+
+func TestFetchParallelUntil(t *testing.T) {
+	// Four top-level directories of four directories each, of four files
+	listings := map[string][]File{}
+	for i := 0; i < 4; i++ {
+		top := fmt.Sprintf("d%d", i)
+		listings[""] = append(listings[""], dir(top))
+		for j := 0; j < 4; j++ {
+			sub := fmt.Sprintf("d%d", j)
+			listings[top] = append(listings[top], dir(sub))
+			for k := 0; k < 4; k++ {
+				listings[top+"/"+sub] = append(listings[top+"/"+sub], file(fmt.Sprintf("f%d.txt", k), 1))
+			}
+		}
+	}
+
+	tests := []struct {
+		name          string
+		parallelUntil int
+		traversal     string
+		atMost        int // requests in flight
+		atLeast       int
+	}{
+		{name: "every level", parallelUntil: 0, atLeast: 5},
+		{name: "top level only", parallelUntil: 1, atMost: 4},
+		{name: "top level only, breadth first", parallelUntil: 1, traversal: "bfs", atMost: 4},
+		{name: "two levels", parallelUntil: 2, atLeast: 5},
+	}
+
+	var want []string
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := &inFlight{next: slow(10*time.Millisecond, &fakeContents{listings: listings})}
+			client := newFakeClient(t, handler, Options{Concurrency: 64, ParallelUntil: tt.parallelUntil, Traversal: tt.traversal})
+
+			root, err := client.Fetch(context.Background(), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.atMost > 0 && handler.most > tt.atMost {
+				t.Errorf("%d requests in flight, want at most %d", handler.most, tt.atMost)
+			}
+			if handler.most < tt.atLeast {
+				t.Errorf("%d requests in flight, want at least %d", handler.most, tt.atLeast)
+			}
+
+			// The tree is the same however it was walked
+			if want == nil {
+				want = root.Paths()
+			}
+			if got := root.Paths(); !reflect.DeepEqual(got, want) {
+				t.Errorf("paths = %v, want %v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package ghtree
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+)
+
+// gistFile is one file of a gist API response
+type gistFile struct {
+	Filename string `json:"filename"`
+	Size     int64  `json:"size"`
+	RawURL   string `json:"raw_url"`
+}
+
+// fetchGist lists the files of Options.Gist, as of the revision in
+// Options.Ref if one is given. Gists have no directories, so the one
+// listing is that of the root.
+func (c *Client) fetchGist(ctx context.Context) (treeListings, error) {
+	gistURL := c.apiURL("gists/%s", url.PathEscape(c.opts.Gist))
+	if c.opts.Ref != "" {
+		gistURL += "/" + url.PathEscape(c.opts.Ref)
+	}
+
+	body, err := c.get(ctx, gistURL)
+	if err != nil {
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+			if c.opts.Ref != "" {
+				return nil, fmt.Errorf("gist %s or its revision %s not found: %w", c.opts.Gist, c.opts.Ref, err)
+			}
+			return nil, fmt.Errorf("gist %s not found: %w", c.opts.Gist, err)
+		}
+		return nil, err
+	}
+
+	var gist struct {
+		HTMLURL string              `json:"html_url"`
+		Files   map[string]gistFile `json:"files"`
+	}
+	err = json.Unmarshal(body, &gist)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse gist %s: %w", c.opts.Gist, err)
+	}
+
+	files := make([]File, 0, len(gist.Files))
+	for _, f := range gist.Files {
+		files = append(files, File{Name: f.Filename, Type: "file", Size: f.Size, DownloadURL: f.RawURL, HTMLURL: gist.HTMLURL})
+	}
+
+	// The files come as a JSON object, so give them a stable order
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+	return treeListings{"": files}, nil
+}
@@ -0,0 +1,114 @@
+package ghtree
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// graphNode is one vertex of a tree drawn as a graph
+type graphNode struct {
+	id     string
+	label  string
+	kind   string
+	parent string
+}
+
+// graphNodes flattens the trees below roots into vertices, depth first,
+// under a top vertex labeled title. A root below the repository root gets
+// a vertex of its own for its path.
+func graphNodes(title string, roots []*Node) []graphNode {
+	nodes := []graphNode{{id: "n0", label: title, kind: "dir"}}
+	add := func(label, kind, parent string) string {
+		id := fmt.Sprintf("n%d", len(nodes))
+		nodes = append(nodes, graphNode{id: id, label: label, kind: kind, parent: parent})
+		return id
+	}
+
+	var walk func(entries []*Node, parent string)
+	walk = func(entries []*Node, parent string) {
+		for _, entry := range entries {
+			label := entry.Name
+			if entry.Type == "dir" {
+				label += "/"
+			}
+			id := add(label, entry.Type, parent)
+			walk(entry.Children, id)
+			if entry.Omitted > 0 {
+				add(fmt.Sprintf("… and %d more", entry.Omitted), "omitted", id)
+			}
+		}
+	}
+	for _, root := range roots {
+		parent := "n0"
+		if root.Type == "dir" && root.Path != "" {
+			parent = add(root.Path+"/", "dir", "n0")
+		}
+		walk(root.TopLevel(), parent)
+		if root.Type == "dir" && root.Omitted > 0 {
+			add(fmt.Sprintf("… and %d more", root.Omitted), "omitted", parent)
+		}
+	}
+	return nodes
+}
+
+// dotEscaper quotes labels for Graphviz
+var dotEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// dotShapes draws directories as folders and stubs with dashed outlines
+var dotShapes = map[string]string{
+	"dir":     `shape=folder`,
+	"omitted": `shape=box, style=dashed`,
+}
+
+// RenderDOT writes the entries below each root as a Graphviz digraph, with
+// a top vertex labeled title, for rendering with dot(1).
+func RenderDOT(w io.Writer, title string, roots ...*Node) error {
+	var b strings.Builder
+	b.WriteString("digraph tree {\n\trankdir=LR;\n\tnode [shape=box, fontname=\"monospace\"];\n")
+	for _, node := range graphNodes(title, roots) {
+		attrs := `label="` + dotEscaper.Replace(node.label) + `"`
+		if shape, ok := dotShapes[node.kind]; ok {
+			attrs += ", " + shape
+		}
+		fmt.Fprintf(&b, "\t%s [%s];\n", node.id, attrs)
+		if node.parent != "" {
+			fmt.Fprintf(&b, "\t%s -> %s;\n", node.parent, node.id)
+		}
+	}
+	b.WriteString("}\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// mermaidEscaper replaces the characters Mermaid labels can't hold with
+// entity codes
+var mermaidEscaper = strings.NewReplacer(`"`, "#quot;", "<", "#lt;", ">", "#gt;")
+
+// RenderMermaid writes the entries below each root as a Mermaid flowchart,
+// with a top vertex labeled title, for embedding in Markdown.
+func RenderMermaid(w io.Writer, title string, roots ...*Node) error {
+	var b strings.Builder
+	b.WriteString("graph LR\n")
+	for _, node := range graphNodes(title, roots) {
+		// Directories are boxes, other entries rounded, and stubs flags
+		label := `"` + mermaidEscaper.Replace(node.label) + `"`
+		switch node.kind {
+		case "dir":
+			label = "[" + label + "]"
+		case "omitted":
+			label = ">" + label + "]"
+		default:
+			label = "(" + label + ")"
+		}
+		if node.parent == "" {
+			fmt.Fprintf(&b, "    %s%s\n", node.id, label)
+		} else {
+			fmt.Fprintf(&b, "    %s --> %s%s\n", node.parent, node.id, label)
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
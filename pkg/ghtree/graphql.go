@@ -0,0 +1,217 @@
+package ghtree
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// graphQLLevels is how many directory levels one GraphQL query lists.
+// Deeper nesting makes queries GitHub is slow to answer, or refuses.
+const graphQLLevels = 3
+
+// graphQLEntry is one entry of a tree in a GraphQL response
+type graphQLEntry struct {
+	Name      string         `json:"name"`
+	Type      string         `json:"type"`
+	Mode      int64          `json:"mode"`
+	OID       string         `json:"oid"`
+	Object    *graphQLObject `json:"object"`
+	Submodule *struct {
+		GitURL string `json:"gitUrl"`
+	} `json:"submodule"`
+}
+
+// graphQLObject is a blob or tree; Entries is nil for trees at the bottom
+// of a query, whose entries weren't selected
+type graphQLObject struct {
+	TypeName string          `json:"__typename"`
+	ByteSize int64           `json:"byteSize"`
+	Entries  *[]graphQLEntry `json:"entries"`
+}
+
+// file converts the entry to the form directory listings use
+func (e graphQLEntry) file() File {
+	// GraphQL reports the git file mode as a decimal number
+	entry := gitTreeEntry{Path: e.Name, Mode: strconv.FormatInt(e.Mode, 8), Type: e.Type, SHA: e.OID}
+	if e.Object != nil {
+		entry.Size = e.Object.ByteSize
+	}
+	f := entry.file()
+	if e.Submodule != nil {
+		f.SubmoduleGitURL = e.Submodule.GitURL
+	}
+	return f
+}
+
+// graphQLLister lists directories through the GraphQL API. Each query
+// returns several levels at once, and the levels below the one asked for
+// are kept to answer later listings without a request.
+type graphQLLister struct {
+	c    *Client
+	root string
+
+	mu       sync.Mutex
+	listings treeListings
+}
+
+func (c *Client) newGraphQLLister(path string) (*graphQLLister, error) {
+	if c.opts.Token == "" && c.opts.App == nil {
+		return nil, errors.New("the GraphQL API requires an access token")
+	}
+	return &graphQLLister{c: c, root: strings.Trim(path, "/"), listings: treeListings{}}, nil
+}
+
+func (g *graphQLLister) list(ctx context.Context, path string) ([]File, error) {
+	key := strings.Trim(path, "/")
+	g.mu.Lock()
+	files, ok := g.listings[key]
+	g.mu.Unlock()
+	if ok {
+		g.c.debugf("listed %q: %d entries (from an earlier query)", key, len(files))
+		return files, nil
+	}
+
+	// Never ask for levels past the depth limit, unless overrides move it
+	levels := graphQLLevels
+	if g.c.opts.MaxDepth > 0 && len(g.c.opts.DepthOverrides) == 0 {
+		level := 1
+		if rel := strings.Trim(strings.TrimPrefix(key, g.root), "/"); rel != "" {
+			level += strings.Count(rel, "/") + 1
+		}
+		if remaining := g.c.opts.MaxDepth - level + 1; remaining < levels {
+			levels = remaining
+		}
+	}
+
+	tree, err := g.c.queryTree(ctx, key, levels)
+	if err != nil {
+		return nil, err
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.store(key, *tree.Entries)
+	files = g.listings[key]
+	g.c.debugf("listed %q: %d entries, %d levels in one query", key, len(files), levels)
+	return files, nil
+}
+
+// store records the listing of path and of every directory below it whose
+// entries the query returned
+func (g *graphQLLister) store(path string, entries []graphQLEntry) {
+	files := make([]File, 0, len(entries))
+	for _, entry := range entries {
+		files = append(files, entry.file())
+		if entry.Object != nil && entry.Object.Entries != nil {
+			g.store(strings.Trim(path+"/"+entry.Name, "/"), *entry.Object.Entries)
+		}
+	}
+	g.listings[path] = files
+}
+
+// queryTree fetches the tree at path with levels of directories below it
+func (c *Client) queryTree(ctx context.Context, path string, levels int) (*graphQLObject, error) {
+	// object(expression:) takes the same <ref>:<path> as git
+	ref := c.opts.Ref
+	if ref == "" {
+		ref = "HEAD"
+	}
+	request, err := json.Marshal(map[string]interface{}{
+		"query": graphQLTreeQuery(levels),
+		"variables": map[string]string{
+			"owner":      c.opts.Owner,
+			"name":       c.opts.Repo,
+			"expression": ref + ":" + path,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	url := c.graphQLURL()
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(request))
+	if err != nil {
+		return nil, fmt.Errorf("invalid request URL %s: %w", url, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, body, err := c.doRequest(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, c.describeListingError(newAPIError(url, resp, body), path)
+	}
+
+	var result struct {
+		Data struct {
+			Repository *struct {
+				Object *graphQLObject `json:"object"`
+			} `json:"repository"`
+		} `json:"data"`
+		Errors []struct {
+			Type    string `json:"type"`
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	err = json.Unmarshal(body, &result)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the GraphQL listing of %q: %w", path, err)
+	}
+
+	// A missing repository or path comes back as null with a NOT_FOUND
+	// error, which reads best as the 404 the REST API would give
+	repository := result.Data.Repository
+	if repository == nil || repository.Object == nil {
+		for _, e := range result.Errors {
+			if e.Type != "NOT_FOUND" {
+				return nil, fmt.Errorf("GraphQL query for %q failed: %s", path, e.Message)
+			}
+		}
+		notFound := &APIError{URL: url, StatusCode: http.StatusNotFound, Status: "404 Not Found"}
+		return nil, c.describeListingError(notFound, path)
+	}
+
+	object := repository.Object
+	switch {
+	case object.TypeName == "Blob":
+		name := path[strings.LastIndex(path, "/")+1:]
+		return nil, &notDirectoryError{file: File{Name: name, Type: "file", Size: object.ByteSize}}
+	case object.Entries == nil:
+		return nil, fmt.Errorf("%q is not a directory", path)
+	}
+	return object, nil
+}
+
+// graphQLURL returns the GraphQL endpoint beside the REST API root, which
+// GitHub Enterprise serves at /api/graphql rather than /api/v3/graphql
+func (c *Client) graphQLURL() string {
+	root := strings.TrimRight(c.opts.APIURL, "/")
+	if strings.HasSuffix(root, "/api/v3") {
+		return strings.TrimSuffix(root, "/v3") + "/graphql"
+	}
+	return root + "/graphql"
+}
+
+// graphQLTreeQuery selects the entries of a tree and of the trees below it,
+// levels deep
+func graphQLTreeQuery(levels int) string {
+	entries := ""
+	for i := 0; i < levels; i++ {
+		subtrees := ""
+		if entries != "" {
+			subtrees = " ... on Tree { " + entries + " }"
+		}
+		entries = "entries { name type mode oid submodule { gitUrl } object { __typename ... on Blob { byteSize }" + subtrees + " } }"
+	}
+	return "query($owner: String!, $name: String!, $expression: String!) { " +
+		"repository(owner: $owner, name: $name) { object(expression: $expression) { " +
+		"__typename ... on Blob { byteSize } ... on Tree { " + entries + " } } } }"
+}
@@ -0,0 +1,85 @@
+package ghtree
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"strings"
+)
+
+// htmlHead opens a standalone page; the title is filled in twice
+const htmlHead = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>%[1]s</title>
+<style>
+body { font-family: ui-monospace, SFMono-Regular, Menlo, Consolas, monospace; }
+ul { list-style: none; padding-left: 1.25em; }
+summary { cursor: pointer; }
+a { text-decoration: none; }
+a:hover { text-decoration: underline; }
+</style>
+</head>
+<body>
+<h1>%[1]s</h1>
+`
+
+const htmlFoot = `</body>
+</html>
+`
+
+// RenderHTMLPage writes a standalone HTML page listing the entries below
+// each root, with directories in collapsible <details> elements and names
+// linked to their pages on GitHub. Several roots each get a heading.
+func RenderHTMLPage(w io.Writer, title string, roots ...*Node) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, htmlHead, html.EscapeString(title))
+	for _, root := range roots {
+		if len(roots) > 1 {
+			fmt.Fprintf(&b, "<h2>%s</h2>\n", html.EscapeString(root.Name))
+		}
+		b.WriteString("<ul>\n")
+		renderHTML(&b, root.TopLevel())
+		if root.Type == "dir" && root.Omitted > 0 {
+			fmt.Fprintf(&b, "<li>… and %d more</li>\n", root.Omitted)
+		}
+		b.WriteString("</ul>\n")
+	}
+	b.WriteString(htmlFoot)
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func renderHTML(b *strings.Builder, nodes []*Node) {
+	for _, node := range nodes {
+		label := html.EscapeString(node.Name)
+		if node.Type == "dir" {
+			label += "/"
+		}
+		if node.HTMLURL != "" {
+			label = `<a href="` + html.EscapeString(node.HTMLURL) + `">` + label + "</a>"
+		}
+		switch {
+		case node.Type == "symlink" && node.Target != "":
+			label += " → " + html.EscapeString(node.Target)
+		case node.Type == "submodule" && node.Commit != "":
+			label += " @ " + html.EscapeString(shortSHA(node.Commit))
+		}
+
+		// Directories past the depth limit were never listed, so there is
+		// nothing to fold away
+		if node.Children == nil {
+			fmt.Fprintf(b, "<li>%s</li>\n", label)
+			continue
+		}
+
+		fmt.Fprintf(b, "<li><details><summary>%s</summary>\n<ul>\n", label)
+		renderHTML(b, node.Children)
+		if node.Omitted > 0 {
+			fmt.Fprintf(b, "<li>… and %d more</li>\n", node.Omitted)
+		}
+		b.WriteString("</ul>\n</details></li>\n")
+	}
+}
@@ -0,0 +1,146 @@
+package ghtree
+
+import (
+	pathpkg "path"
+	"strings"
+)
+
+// NerdIcons draws entries with Nerd Font glyphs, which need a patched font
+// in the terminal. Keys are as in RenderOptions.Icons.
+var NerdIcons = map[string]string{
+	"dir":        "",
+	"file":       "",
+	"symlink":    "",
+	"submodule":  "",
+	"executable": "",
+
+	"dockerfile":     "",
+	".gitignore":     "",
+	".gitattributes": "",
+	".gitmodules":    "",
+
+	".go":    "",
+	".md":    "",
+	".js":    "",
+	".mjs":   "",
+	".ts":    "",
+	".tsx":   "",
+	".py":    "",
+	".rs":    "",
+	".java":  "",
+	".rb":    "",
+	".php":   "",
+	".swift": "",
+	".lua":   "",
+	".c":     "",
+	".h":     "",
+	".cpp":   "",
+	".hpp":   "",
+	".sh":    "",
+	".html":  "",
+	".css":   "",
+	".json":  "",
+	".yml":   "",
+	".yaml":  "",
+	".toml":  "",
+	".ini":   "",
+	".lock":  "",
+	".txt":   "",
+	".pdf":   "",
+	".png":   "",
+	".jpg":   "",
+	".jpeg":  "",
+	".gif":   "",
+	".svg":   "",
+	".webp":  "",
+	".ico":   "",
+	".zip":   "",
+	".tar":   "",
+	".gz":    "",
+	".tgz":   "",
+	".xz":    "",
+}
+
+// EmojiIcons draws entries with emoji, which most terminals show without a
+// special font. Keys are as in RenderOptions.Icons.
+var EmojiIcons = map[string]string{
+	"dir":        "📁",
+	"file":       "📄",
+	"symlink":    "🔗",
+	"submodule":  "📌",
+	"executable": "💻",
+
+	"dockerfile": "🐳",
+
+	".go":   "🐹",
+	".md":   "📝",
+	".py":   "🐍",
+	".rs":   "🦀",
+	".js":   "📜",
+	".mjs":  "📜",
+	".ts":   "📜",
+	".tsx":  "📜",
+	".rb":   "💎",
+	".c":    "🔩",
+	".h":    "🔩",
+	".cpp":  "🔩",
+	".hpp":  "🔩",
+	".sh":   "💻",
+	".html": "🌐",
+	".css":  "🎨",
+	".json": "🔧",
+	".yml":  "🔧",
+	".yaml": "🔧",
+	".toml": "🔧",
+	".ini":  "🔧",
+	".lock": "🔒",
+	".pdf":  "📕",
+	".png":  "📷",
+	".jpg":  "📷",
+	".jpeg": "📷",
+	".gif":  "📷",
+	".svg":  "📷",
+	".webp": "📷",
+	".ico":  "📷",
+	".zip":  "📦",
+	".tar":  "📦",
+	".gz":   "📦",
+	".tgz":  "📦",
+	".xz":   "📦",
+}
+
+// icon returns the Icons entry for node followed by a space, or "" without
+// Icons. Plain files fall back to the "file" icon.
+func (r *treeRenderer) icon(node *Node) string {
+	if r.opts.Icons == nil {
+		return ""
+	}
+
+	keys := []string{node.Type}
+	if node.Type == "file" {
+		keys = []string{strings.ToLower(node.Name), strings.ToLower(pathpkg.Ext(node.Name))}
+		if node.Executable {
+			keys = append(keys, "executable")
+		}
+		keys = append(keys, "file")
+	}
+	for _, key := range keys {
+		if icon, ok := r.opts.Icons[key]; ok && icon != "" {
+			return icon + " "
+		}
+	}
+	return ""
+}
+
+// textWidth counts the terminal columns of s, taking the emoji of
+// EmojiIcons to fill two
+func textWidth(s string) int {
+	width := 0
+	for _, c := range s {
+		width++
+		if c >= 0x1F300 && c <= 0x1FAFF {
+			width++
+		}
+	}
+	return width
+}
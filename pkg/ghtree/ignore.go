@@ -0,0 +1,144 @@
+package ghtree
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// IgnoreRules holds patterns in .gitignore syntax, matched against
+// repository paths: "#" starts a comment, "!" re-includes what an earlier
+// pattern excluded, a trailing "/" matches only directories, and a pattern
+// with a slash anywhere else is anchored to the repository root. "*" and
+// "?" stay within one path segment, and "**" spans any number of them. The
+// zero value holds no rules.
+type IgnoreRules struct {
+	rules []ignoreRule
+}
+
+type ignoreRule struct {
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// Add reads rules in .gitignore syntax from src, after the rules already
+// there; later rules take precedence. A malformed pattern adds nothing.
+func (r *IgnoreRules) Add(src io.Reader) error {
+	var parsed []ignoreRule
+	scanner := bufio.NewScanner(src)
+	for line := 1; scanner.Scan(); line++ {
+		pattern := strings.TrimRight(scanner.Text(), " \t\r")
+		if pattern == "" || strings.HasPrefix(pattern, "#") {
+			continue
+		}
+
+		rule := ignoreRule{}
+		if strings.HasPrefix(pattern, "!") {
+			rule.negate = true
+			pattern = pattern[1:]
+		} else if strings.HasPrefix(pattern, `\`) {
+			// An escaped "#" or "!" is literal
+			pattern = pattern[1:]
+		}
+		if strings.Trim(pattern, "/") == "" {
+			continue
+		}
+
+		var err error
+		rule.re, rule.dirOnly, err = compileGitPattern(pattern)
+		if err != nil {
+			return fmt.Errorf("line %d: invalid pattern %q", line, scanner.Text())
+		}
+		parsed = append(parsed, rule)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	r.rules = append(r.rules, parsed...)
+	return nil
+}
+
+// compileGitPattern turns a .gitignore-style pattern into a regular
+// expression over repository paths. It also reports whether the pattern
+// only matches directories, as a trailing slash says.
+func compileGitPattern(pattern string) (*regexp.Regexp, bool, error) {
+	dirOnly := strings.HasSuffix(pattern, "/")
+	pattern = strings.TrimRight(pattern, "/")
+
+	anchored := strings.Contains(pattern, "/")
+	expr := globRegexp(strings.TrimPrefix(pattern, "/"))
+	if !anchored {
+		expr = "(?:.*/)?" + expr
+	}
+	re, err := regexp.Compile("^" + expr + "$")
+	return re, dirOnly, err
+}
+
+// globRegexp translates one gitignore glob into a regular expression
+func globRegexp(pattern string) string {
+	var b strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; {
+		case strings.HasPrefix(pattern[i:], "**/"):
+			b.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		case c == '\\' && i+1 < len(pattern):
+			i++
+			b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String()
+}
+
+// Match reports whether the rules leave out the entry at path, a
+// repository path without surrounding slashes. As with git, nothing below
+// a directory they leave out can be included again.
+func (r *IgnoreRules) Match(path string, isDir bool) bool {
+	if r == nil {
+		return false
+	}
+	for i := 0; i < len(path); i++ {
+		if path[i] == '/' && r.matchEntry(path[:i], true) {
+			return true
+		}
+	}
+	return r.matchEntry(path, isDir)
+}
+
+// matchEntry applies the rules to one entry, ignoring its parents
+func (r *IgnoreRules) matchEntry(path string, isDir bool) bool {
+	ignored := false
+	for _, rule := range r.rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		if rule.re.MatchString(path) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
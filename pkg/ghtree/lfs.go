@@ -0,0 +1,30 @@
+package ghtree
+
+import (
+	"bufio"
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+// MaxLFSPointerSize bounds the size of a Git LFS pointer file; a larger
+// file holds real content.
+const MaxLFSPointerSize = 1024
+
+// ParseLFSPointer reports whether data is a Git LFS pointer file, and if so
+// the size of the object it stands for.
+func ParseLFSPointer(data []byte) (int64, bool) {
+	if len(data) > MaxLFSPointerSize || !bytes.HasPrefix(data, []byte("version https://git-lfs.github.com/spec/")) {
+		return 0, false
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		key, value, _ := strings.Cut(scanner.Text(), " ")
+		if key == "size" {
+			size, err := strconv.ParseInt(value, 10, 64)
+			return size, err == nil && size >= 0
+		}
+	}
+	return 0, false
+}
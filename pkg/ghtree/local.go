@@ -0,0 +1,79 @@
+package ghtree
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LocalProvider lists directories of the local filesystem below root, so a
+// checkout can be drawn and compared with its remote without any requests.
+// Owner, Repo and Ref are ignored, and the .git directory is left out like
+// it is on the forges. Entries have no SHA.
+func LocalProvider(root string) Provider {
+	return localProvider{root: root}
+}
+
+type localProvider struct {
+	root string
+}
+
+func (l localProvider) List(ctx context.Context, c *Client, path string) ([]File, error) {
+	dir := filepath.Join(l.root, filepath.FromSlash(strings.Trim(path, "/")))
+	info, err := os.Lstat(dir)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("%s does not exist", dir)
+		}
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, &notDirectoryError{file: localFile(dir, info)}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]File, 0, len(entries))
+	for _, entry := range entries {
+		if entry.Name() == ".git" {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			// Entries can vanish between listing and stat
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+		files = append(files, localFile(filepath.Join(dir, entry.Name()), info))
+	}
+	return files, nil
+}
+
+// localFile describes the file at path, which info was read from without
+// following symlinks
+func localFile(path string, info fs.FileInfo) File {
+	f := File{Name: info.Name(), Type: "file", Size: info.Size()}
+	switch {
+	case info.IsDir():
+		f.Type = "dir"
+		f.Size = 0
+	case info.Mode()&fs.ModeSymlink != 0:
+		f.Type = "symlink"
+		f.Size = 0
+		f.Target, _ = os.Readlink(path)
+	case info.Mode().IsRegular():
+		f.Executable = info.Mode()&0111 != 0
+	default:
+		// Devices, sockets and pipes have nothing a repository could hold
+		f.Type = "other"
+	}
+	return f
+}
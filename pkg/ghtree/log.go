@@ -0,0 +1,12 @@
+package ghtree
+
+// LogEntry is a line of a Client's log, as Options.Log receives it. Level
+// is "warning", "debug" or "trace", Message is the line the matching
+// Options function would get, and Fields holds the details behind it, such
+// as the "url", "status" and "ms" of a response or the "path" and
+// "entries" of a listing. Fields is nil for lines without details.
+type LogEntry struct {
+	Level   string
+	Message string
+	Fields  map[string]interface{}
+}
@@ -0,0 +1,71 @@
+package ghtree
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// markdownEscaper escapes the characters that would turn a name into
+// Markdown formatting
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`, "`", "\\`", "*", `\*`, "_", `\_`,
+	"[", `\[`, "]", `\]`, "(", `\(`, ")", `\)`, "#", `\#`,
+)
+
+// RenderMarkdown writes the entries below n to w as a nested Markdown list.
+// Names link to their pages on GitHub when the API reported one, and
+// directory names end in a slash.
+func (n *Node) RenderMarkdown(w io.Writer) error {
+	return n.renderMarkdown(w, nil)
+}
+
+// renderMarkdown writes the entries below n as RenderMarkdown does, with
+// the text annotate returns after each name
+func (n *Node) renderMarkdown(w io.Writer, annotate func(node *Node) string) error {
+	err := renderMarkdown(w, n.TopLevel(), "", annotate)
+	if err == nil && n.Type == "dir" && n.Omitted > 0 {
+		_, err = fmt.Fprintf(w, "- … and %d more\n", n.Omitted)
+	}
+	return err
+}
+
+// RenderMarkdownItem writes n itself as a list item with its entries
+// nested below it, for documents that list several trees.
+func (n *Node) RenderMarkdownItem(w io.Writer) error {
+	return renderMarkdown(w, []*Node{n}, "", nil)
+}
+
+func renderMarkdown(w io.Writer, nodes []*Node, indent string, annotate func(node *Node) string) error {
+	for _, node := range nodes {
+		label := markdownEscaper.Replace(node.Name)
+		if node.Type == "dir" {
+			label += "/"
+		}
+		if node.HTMLURL != "" {
+			label = "[" + label + "](" + node.HTMLURL + ")"
+		}
+		if annotate != nil {
+			if note := annotate(node); note != "" {
+				label += " " + markdownEscaper.Replace(note)
+			}
+		}
+
+		_, err := fmt.Fprintf(w, "%s- %s\n", indent, label)
+		if err != nil {
+			return err
+		}
+
+		err = renderMarkdown(w, node.Children, indent+"  ", annotate)
+		if err != nil {
+			return err
+		}
+		if node.Omitted > 0 {
+			_, err = fmt.Fprintf(w, "%s  - … and %d more\n", indent, node.Omitted)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
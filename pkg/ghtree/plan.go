@@ -0,0 +1,85 @@
+package ghtree
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Plan returns the URLs of the GET requests Fetch would send to walk the
+// tree rooted at path, in path order, without walking it. It lists the
+// whole tree with one git trees API request and walks that instead, so the
+// depth limit, exclusions and entry cap shape the plan as they would the
+// walk. Listings the cache could answer are planned all the same.
+func (c *Client) Plan(ctx context.Context, path string) ([]string, error) {
+	if _, onGitHub := c.opts.Provider.(githubProvider); c.opts.Provider != nil && !onGitHub {
+		return nil, errors.New("only walks on GitHub can be planned")
+	}
+	if c.opts.Gist != "" || c.opts.GraphQL {
+		return nil, errors.New("gist and GraphQL walks can't be planned")
+	}
+	err := CheckRepository(c.opts.Owner, c.opts.Repo)
+	if err != nil {
+		return nil, err
+	}
+	if c.opts.RecursiveAPI {
+		return []string{c.gitTreeURL(c.opts.Ref, path, true)}, nil
+	}
+
+	listings, err := c.fetchGitTree(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	if listings == nil {
+		return nil, errors.New("the tree is too large to plan from a single request")
+	}
+
+	// Walk the listings with a client that sends nothing and reports
+	// nothing, noting the requests the real walk would send instead
+	opts := c.opts
+	opts.Listing, opts.Entry = nil, nil
+	if opts.Sort == "commit-date" {
+		opts.Sort = "none"
+	}
+	planner := NewClient(opts)
+
+	type request struct{ path, url string }
+	var mu sync.Mutex
+	var requests []request
+	plan := func(path, url string) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests = append(requests, request{strings.Trim(path, "/"), url})
+	}
+	w := &walk{list: func(ctx context.Context, dir string) ([]File, error) {
+		files, err := listings.list(ctx, dir)
+		plan(dir, planner.contentsURL(dir))
+		if len(files) >= contentsLimit {
+			plan(dir, planner.gitTreeURL(opts.Ref, dir, false))
+		}
+		if c.opts.Sort == "commit-date" {
+			for _, f := range planner.excludeFiles(dir, files) {
+				plan(dir+"/"+f.Name, planner.lastCommitURL(dir+"/"+f.Name))
+			}
+		}
+		return files, err
+	}, visited: map[string]bool{}}
+	_, _, _, err = planner.fetchFilesAndFolders(ctx, w, path, 1, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(requests, func(i, j int) bool {
+		if requests[i].path != requests[j].path {
+			return requests[i].path < requests[j].path
+		}
+		return requests[i].url < requests[j].url
+	})
+	urls := make([]string, len(requests))
+	for i, r := range requests {
+		urls[i] = r.url
+	}
+	return urls, nil
+}
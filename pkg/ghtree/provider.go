@@ -0,0 +1,208 @@
+package ghtree
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	pathpkg "path"
+	"strings"
+	"sync"
+)
+
+// Provider lists the directories of a repository on one code forge. The
+// client passes itself to List, so the built-in providers share its
+// concurrency bound, rate limit, retries and token; GitHubProvider,
+// GitLabProvider and BitbucketProvider return them.
+type Provider interface {
+	// List returns the entries of the directory at path in the repository
+	// named by the client's Options, as of Options.Ref.
+	List(ctx context.Context, c *Client, path string) ([]File, error)
+}
+
+// GitHubProvider lists directories through the GitHub contents API, as a
+// client without a Provider does. The git trees and GraphQL APIs, gists
+// and the commit-date sort are only available with it.
+func GitHubProvider() Provider {
+	return githubProvider{}
+}
+
+type githubProvider struct{}
+
+func (githubProvider) List(ctx context.Context, c *Client, path string) ([]File, error) {
+	return c.listDirectory(ctx, path)
+}
+
+// GitLabProvider lists directories through the GitLab repository tree API,
+// at an APIURL like https://gitlab.com/api/v4. Owner may hold subgroups,
+// as in "group/subgroup". GitLab doesn't report file sizes in listings.
+func GitLabProvider() Provider {
+	return gitlabProvider{}
+}
+
+type gitlabProvider struct{}
+
+// gitlabEntry is one entry of a GitLab repository tree response
+type gitlabEntry struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Type string `json:"type"`
+	Mode string `json:"mode"`
+}
+
+func (gitlabProvider) List(ctx context.Context, c *Client, path string) ([]File, error) {
+	dir := strings.Trim(path, "/")
+	project := url.PathEscape(c.opts.Owner + "/" + c.opts.Repo)
+	query := url.Values{"per_page": {"100"}}
+	if dir != "" {
+		query.Set("path", dir)
+	}
+	if c.opts.Ref != "" {
+		query.Set("ref", c.opts.Ref)
+	}
+
+	// GitLab pages with Link headers, like GitHub
+	files := []File{}
+	for listingURL := c.apiURL("projects/%s/repository/tree?%s", project, query.Encode()); listingURL != ""; {
+		body, next, err := c.getPage(ctx, listingURL)
+		if err != nil {
+			return nil, c.describeListingError(err, path)
+		}
+
+		var page []gitlabEntry
+		err = json.Unmarshal(body, &page)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse the listing of %q: %w", dir, err)
+		}
+		for _, entry := range page {
+			// The entries carry git tree types and modes
+			f := gitTreeEntry{Path: entry.Name, Mode: entry.Mode, Type: entry.Type, SHA: entry.ID}.file()
+			if f.Type == "file" {
+				raw := url.Values{}
+				if c.opts.Ref != "" {
+					raw.Set("ref", c.opts.Ref)
+				}
+				f.DownloadURL = c.apiURL("projects/%s/repository/files/%s/raw?%s", project, url.PathEscape(strings.Trim(dir+"/"+entry.Name, "/")), raw.Encode())
+			}
+			files = append(files, f)
+		}
+		listingURL = next
+	}
+	return files, nil
+}
+
+// BitbucketProvider lists directories through the Bitbucket Cloud source
+// API, at an APIURL like https://api.bitbucket.org/2.0. Owner is the
+// workspace. Bitbucket reports no object IDs, so entries have no SHA.
+func BitbucketProvider() Provider {
+	return &bitbucketProvider{}
+}
+
+type bitbucketProvider struct {
+	// The main branch, looked up once when no ref is given
+	mu         sync.Mutex
+	mainBranch string
+}
+
+// bitbucketEntry is one entry of a Bitbucket source listing
+type bitbucketEntry struct {
+	Type       string   `json:"type"`
+	Path       string   `json:"path"`
+	Size       int64    `json:"size"`
+	Attributes []string `json:"attributes"`
+	Links      struct {
+		Self struct {
+			Href string `json:"href"`
+		} `json:"self"`
+	} `json:"links"`
+}
+
+// file converts the entry to the form directory listings use
+func (e bitbucketEntry) file() File {
+	f := File{Name: pathpkg.Base(e.Path), Type: "file", Size: e.Size}
+	if e.Type == "commit_directory" {
+		f.Type = "dir"
+		f.Size = 0
+		return f
+	}
+	for _, attribute := range e.Attributes {
+		switch attribute {
+		case "link":
+			f.Type = "symlink"
+		case "subrepository":
+			f.Type = "submodule"
+		case "executable":
+			f.Executable = true
+		}
+	}
+	if f.Type == "file" {
+		f.DownloadURL = e.Links.Self.Href
+	}
+	return f
+}
+
+func (b *bitbucketProvider) List(ctx context.Context, c *Client, path string) ([]File, error) {
+	// The source API needs a commit or branch to list
+	ref := c.opts.Ref
+	if ref == "" {
+		var err error
+		ref, err = b.defaultBranch(ctx, c)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	dir := strings.Trim(path, "/")
+	escaped := (&url.URL{Path: dir}).EscapedPath()
+	if escaped != "" {
+		escaped += "/"
+	}
+
+	// Bitbucket pages with a next URL in the body
+	files := []File{}
+	for listingURL := c.apiURL("repositories/%s/%s/src/%s/%s?pagelen=100", c.opts.Owner, c.opts.Repo, url.PathEscape(ref), escaped); listingURL != ""; {
+		body, err := c.get(ctx, listingURL)
+		if err != nil {
+			return nil, c.describeListingError(err, path)
+		}
+
+		// A file path answers with the file's contents instead of a listing
+		var page struct {
+			Values *[]bitbucketEntry `json:"values"`
+			Next   string            `json:"next"`
+		}
+		if json.Unmarshal(body, &page) != nil || page.Values == nil {
+			return nil, &notDirectoryError{file: File{Name: pathpkg.Base(dir), Type: "file", Size: int64(len(body))}}
+		}
+		for _, entry := range *page.Values {
+			files = append(files, entry.file())
+		}
+		listingURL = page.Next
+	}
+	return files, nil
+}
+
+// defaultBranch returns the repository's main branch
+func (b *bitbucketProvider) defaultBranch(ctx context.Context, c *Client) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.mainBranch != "" {
+		return b.mainBranch, nil
+	}
+
+	body, err := c.get(ctx, c.apiURL("repositories/%s/%s", c.opts.Owner, c.opts.Repo))
+	if err != nil {
+		return "", c.describeListingError(err, "")
+	}
+	var repository struct {
+		MainBranch *struct {
+			Name string `json:"name"`
+		} `json:"mainbranch"`
+	}
+	err = json.Unmarshal(body, &repository)
+	if err != nil || repository.MainBranch == nil || repository.MainBranch.Name == "" {
+		return "", fmt.Errorf("%s/%s has no main branch; pass a ref", c.opts.Owner, c.opts.Repo)
+	}
+	b.mainBranch = repository.MainBranch.Name
+	return b.mainBranch, nil
+}
@@ -0,0 +1,53 @@
+package ghtree
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket holding a single token, so requests are
+// spaced evenly at the configured rate no matter how many callers share it.
+type rateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+	now      func() time.Time
+	sleep    func(context.Context, time.Duration) error
+}
+
+func newRateLimiter(rps float64) *rateLimiter {
+	// A non-positive rate disables limiting
+	if rps <= 0 {
+		return nil
+	}
+
+	return &rateLimiter{
+		interval: time.Duration(float64(time.Second) / rps),
+		now:      time.Now,
+		sleep:    sleep,
+	}
+}
+
+// Wait blocks until the caller may send its request, or ctx is done
+func (l *rateLimiter) Wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+
+	// Reserve the next free slot while holding the lock
+	l.mu.Lock()
+	now := l.now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	wait := l.next.Sub(now)
+	l.next = l.next.Add(l.interval)
+	l.mu.Unlock()
+
+	// Sleep outside the lock so other callers can reserve later slots
+	if wait <= 0 {
+		return nil
+	}
+	return l.sleep(ctx, wait)
+}
@@ -0,0 +1,130 @@
+package ghtree
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// fakeClock stands in for the time a rateLimiter sees, recording the waits
+// it asks for and moving on by them
+type fakeClock struct {
+	now   time.Time
+	waits []time.Duration
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Sleep(ctx context.Context, d time.Duration) error {
+	if ctx.Err() != nil {
+		return stopped(ctx)
+	}
+	c.waits = append(c.waits, d)
+	c.now = c.now.Add(d)
+	return nil
+}
+
+func TestRateLimiterPacing(t *testing.T) {
+	tests := []struct {
+		name  string
+		rps   float64
+		gaps  []time.Duration // time passing before each call
+		waits []time.Duration
+	}{
+		{
+			name:  "back to back",
+			rps:   2,
+			gaps:  []time.Duration{0, 0, 0, 0},
+			waits: []time.Duration{500 * time.Millisecond, 500 * time.Millisecond, 500 * time.Millisecond},
+		},
+		{
+			name:  "spaced out already",
+			rps:   2,
+			gaps:  []time.Duration{0, time.Second, time.Second},
+			waits: nil,
+		},
+		{
+			name:  "partly spaced out",
+			rps:   4,
+			gaps:  []time.Duration{0, 100 * time.Millisecond, 0},
+			waits: []time.Duration{150 * time.Millisecond, 250 * time.Millisecond},
+		},
+		{
+			name:  "idle time is not saved up",
+			rps:   1,
+			gaps:  []time.Duration{0, 10 * time.Second, 0},
+			waits: []time.Duration{time.Second},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+			limiter := newRateLimiter(tt.rps)
+			limiter.now, limiter.sleep = clock.Now, clock.Sleep
+
+			for i, gap := range tt.gaps {
+				clock.now = clock.now.Add(gap)
+				err := limiter.Wait(context.Background())
+				if err != nil {
+					t.Fatalf("call %d: %v", i, err)
+				}
+			}
+			if len(clock.waits) != len(tt.waits) {
+				t.Fatalf("waits = %v, want %v", clock.waits, tt.waits)
+			}
+			for i := range tt.waits {
+				if clock.waits[i] != tt.waits[i] {
+					t.Errorf("waits = %v, want %v", clock.waits, tt.waits)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimiterCancelled(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	limiter := newRateLimiter(1)
+	limiter.now, limiter.sleep = clock.Now, clock.Sleep
+
+	ctx, cancel := context.WithCancel(context.Background())
+	err := limiter.Wait(ctx)
+	if err != nil {
+		t.Fatalf("first call: %v", err)
+	}
+	cancel()
+	err = limiter.Wait(ctx)
+	if err == nil || err.Error() != "interrupted" {
+		t.Errorf("Wait after cancel = %v, want interrupted", err)
+	}
+}
+
+func TestRateLimiterDisabled(t *testing.T) {
+	limiter := newRateLimiter(0)
+	if limiter != nil {
+		t.Fatalf("newRateLimiter(0) = %v, want nil", limiter)
+	}
+	err := limiter.Wait(context.Background())
+	if err != nil {
+		t.Errorf("Wait on a nil limiter = %v", err)
+	}
+}
+
+func TestAcquireCancelledWhileSlotsAreTaken(t *testing.T) {
+	client := NewClient(Options{Owner: "o", Repo: "r", Concurrency: 1})
+	err := client.acquire(context.Background())
+	if err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+	defer client.release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err = client.acquire(ctx)
+	if err == nil || err.Error() != "deadline exceeded" {
+		t.Errorf("acquire with every slot taken = %v, want deadline exceeded", err)
+	}
+}
@@ -0,0 +1,458 @@
+package ghtree
+
+import (
+	"fmt"
+	"io"
+	pathpkg "path"
+	"strings"
+	"time"
+)
+
+// RenderOptions controls how Render and the Renderers draw a tree. Options a
+// format has no use for are ignored.
+type RenderOptions struct {
+	// ASCII draws connectors with plain ASCII instead of box-drawing characters.
+	ASCII bool
+
+	// Sizes appends each file's human-readable size to its name, and the
+	// total of the files below a directory when all of it was listed.
+	Sizes bool
+
+	// Width limits each line to that many characters; zero means no limit.
+	Width int
+
+	// Color highlights entries by type with ANSI escapes.
+	Color bool
+
+	// Palette overrides the colors used with Color. Keys are entry types
+	// ("dir", "symlink", "submodule", "executable") or file extensions with
+	// their dot (".go"); values are SGR parameters such as "01;34", as in
+	// LS_COLORS.
+	Palette map[string]string
+
+	// Truncate cuts names that exceed Width with an ellipsis instead of
+	// wrapping them onto continuation lines.
+	Truncate bool
+
+	// Annotate, if set, returns text to show after an entry's name, such
+	// as its owners; empty adds nothing.
+	Annotate func(node *Node) string
+
+	// Icons, if set, prefixes entries with an icon looked up by the file's
+	// lowercase name, then its extension with the dot, then "executable" for
+	// executable files and "file" for the rest; other entries by their type.
+	// NerdIcons and EmojiIcons are ready to use.
+	Icons map[string]string
+
+	// Link, if set, returns the URL an entry's name links to with an OSC 8
+	// escape, which terminals that support it make clickable; empty links
+	// nowhere.
+	Link func(node *Node) string
+
+	// Previews holds lines to draw indented below files, keyed by the
+	// file's Path, such as the first lines of its contents.
+	Previews map[string][]string
+
+	// Title heads the formats that have a title: HTML pages, DOT graphs
+	// and Mermaid charts. Empty uses the root's name, and leaves several
+	// trees drawn together untitled.
+	Title string
+}
+
+// charset holds the glyphs used to draw tree connectors
+type charset struct {
+	branch   string
+	last     string
+	pipe     string
+	blank    string
+	ellipsis string
+}
+
+var unicodeCharset = charset{branch: "├── ", last: "└── ", pipe: "│   ", blank: "    ", ellipsis: "…"}
+
+var asciiCharset = charset{branch: "|-- ", last: "`-- ", pipe: "|   ", blank: "    ", ellipsis: "..."}
+
+// typeColors maps entry types to the SGR parameters used when Color is set;
+// plain files stay uncolored
+var typeColors = map[string]string{
+	"dir":        "1;34",
+	"symlink":    "36",
+	"submodule":  "35",
+	"executable": "1;32",
+}
+
+// changeMarks and changeColors flag the entries of a Diff tree
+var changeMarks = map[string]string{
+	"added":    "+ ",
+	"removed":  "- ",
+	"modified": "~ ",
+}
+
+var changeColors = map[string]string{
+	"added":    "32",
+	"removed":  "31",
+	"modified": "33",
+}
+
+const colorReset = "\x1b[0m"
+
+// treeRenderer draws nodes as an indented tree using its charset, so the
+// traversal never needs to know which glyphs are in use.
+type treeRenderer struct {
+	w     io.Writer
+	opts  RenderOptions
+	chars charset
+	err   error
+
+	// streaming leaves out directory totals, which need the whole subtree
+	streaming bool
+}
+
+// Render writes the children of n to w as an indented tree, one entry per
+// line. The root itself is not printed unless it is a single file.
+func (n *Node) Render(w io.Writer, opts RenderOptions) error {
+	r := newTreeRenderer(w, opts)
+	r.render(n.TopLevel(), "", n.Type == "dir" && n.Omitted > 0)
+	if n.Type == "dir" {
+		r.renderOmitted(n.Omitted, "")
+	}
+	return r.err
+}
+
+// newTreeRenderer returns a renderer writing to w with the charset opts
+// picks
+func newTreeRenderer(w io.Writer, opts RenderOptions) *treeRenderer {
+	r := &treeRenderer{w: w, opts: opts, chars: unicodeCharset}
+	if opts.ASCII {
+		r.chars = asciiCharset
+	}
+	return r
+}
+
+// TopLevel returns the entries Render starts from: the children of a
+// directory, or a lone non-directory root itself.
+func (n *Node) TopLevel() []*Node {
+	if n.Type != "dir" {
+		return []*Node{n}
+	}
+	return n.Children
+}
+
+// render draws nodes; more says a line for omitted entries follows them
+func (r *treeRenderer) render(nodes []*Node, indent string, more bool) {
+	for i, node := range nodes {
+		isLast := i == len(nodes)-1 && !more
+		r.renderEntry(node, indent, isLast)
+
+		// Directories, and followed submodules, are drawn below their line
+		if node.Type == "dir" || node.Children != nil {
+			r.render(node.Children, indent+r.indentPrefix(isLast), node.Omitted > 0)
+			r.renderOmitted(node.Omitted, indent+r.indentPrefix(isLast))
+		}
+	}
+}
+
+// renderEntry draws the line of one entry, and a file's preview below it
+func (r *treeRenderer) renderEntry(node *Node, indent string, isLast bool) {
+	if node.Type == "dir" {
+		name := changeMarks[node.Change] + node.Name
+		if r.opts.Sizes && !r.streaming {
+			if total, complete := subtreeSize(node); complete {
+				name += " (" + HumanizeBytes(total) + ")"
+			}
+		}
+		switch {
+		case node.Collapsed && node.CollapsedEntries == 1:
+			name += " (+1 item)"
+		case node.Collapsed && node.CollapsedEntries > 0:
+			name += fmt.Sprintf(" (+%d items)", node.CollapsedEntries)
+		case node.Collapsed:
+			name += " " + r.chars.ellipsis
+		}
+		name = r.annotate(node, name)
+		icon := r.icon(node)
+		r.printEntry(indent, r.dirPrefix(isLast)+icon, r.indentPrefix(isLast)+strings.Repeat(" ", textWidth(icon)), name, r.style(node), r.link(node))
+		return
+	}
+
+	name := changeMarks[node.Change] + node.Name
+	switch {
+	case node.Type == "symlink" && node.Target != "":
+		name += " -> " + node.Target
+	case node.Type == "submodule":
+		if node.Commit != "" {
+			name += " @ " + shortSHA(node.Commit)
+		}
+		if node.SubmoduleURL != "" {
+			name += " (" + node.SubmoduleURL + ")"
+		}
+	case node.LFS:
+		name += " [LFS]"
+		if r.opts.Sizes {
+			name += " (" + HumanizeBytes(node.Size) + ")"
+		}
+	case r.opts.Sizes:
+		name += " (" + HumanizeBytes(node.Size) + ")"
+	}
+	name = r.annotate(node, name)
+	icon := r.icon(node)
+	r.printEntry(indent, r.filePrefix(isLast)+icon, r.indentPrefix(isLast)+strings.Repeat(" ", textWidth(icon)), name, r.style(node), r.link(node))
+	r.renderPreview(node, indent+r.indentPrefix(isLast))
+}
+
+// annotate appends the Annotate text of an entry to its name
+func (r *treeRenderer) annotate(node *Node, name string) string {
+	if r.opts.Annotate == nil {
+		return name
+	}
+	if note := r.opts.Annotate(node); note != "" {
+		name += "  " + note
+	}
+	return name
+}
+
+// renderPreview draws the preview lines of a file below its entry, cut to
+// the width limit if there is one
+func (r *treeRenderer) renderPreview(node *Node, indent string) {
+	for _, line := range r.opts.Previews[node.Path] {
+		line = indent + "  " + line
+		if runes := []rune(line); r.opts.Width > 0 && len(runes) > r.opts.Width {
+			line = r.shorten(runes, r.opts.Width)
+		}
+		r.printf("%s\n", line)
+	}
+}
+
+// subtreeSize adds up the sizes of the files below dir. It also reports
+// whether the total is complete, which it isn't when some directory below
+// was cut off by the depth limit or cut short by MaxEntries.
+func subtreeSize(dir *Node) (int64, bool) {
+	if dir.Children == nil || dir.Omitted > 0 {
+		return 0, false
+	}
+
+	var total int64
+	for _, child := range dir.Children {
+		if child.Type != "dir" {
+			total += child.Size
+			continue
+		}
+		size, complete := subtreeSize(child)
+		if !complete {
+			return 0, false
+		}
+		total += size
+	}
+	return total, true
+}
+
+// renderOmitted stands in for the omitted entries past a directory's
+// MaxEntries
+func (r *treeRenderer) renderOmitted(omitted int, indent string) {
+	if omitted > 0 {
+		r.printf("%s%s%s and %d more\n", indent, r.chars.last, r.chars.ellipsis, omitted)
+	}
+}
+
+func (r *treeRenderer) filePrefix(isLast bool) string {
+	if isLast {
+		return r.chars.last
+	}
+	return r.chars.branch
+}
+
+func (r *treeRenderer) dirPrefix(isLast bool) string {
+	if isLast {
+		return r.chars.last
+	}
+	return r.chars.branch
+}
+
+func (r *treeRenderer) indentPrefix(isLast bool) string {
+	if isLast {
+		return r.chars.blank
+	}
+	return r.chars.pipe
+}
+
+func (r *treeRenderer) printEntry(indent, prefix, continuation, name, style, link string) {
+	// Print the whole name when no width limit applies
+	width := r.opts.Width
+	used := textWidth(indent + prefix)
+	runes := []rune(name)
+	if width <= 0 || used+len(runes) <= width {
+		r.printf("%s%s%s\n", indent, prefix, r.hyperlink(link, r.paint(style, name)))
+		return
+	}
+
+	// Always leave room for at least one character of the name
+	avail := width - used
+	if avail < 1 {
+		avail = 1
+	}
+
+	if r.opts.Truncate {
+		r.printf("%s%s%s\n", indent, prefix, r.hyperlink(link, r.paint(style, r.shorten(runes, avail))))
+		return
+	}
+
+	// Wrap the name onto continuation lines aligned under its first character
+	r.printf("%s%s%s\n", indent, prefix, r.hyperlink(link, r.paint(style, string(runes[:avail]))))
+	for runes = runes[avail:]; len(runes) > 0; {
+		n := avail
+		if n > len(runes) {
+			n = len(runes)
+		}
+		r.printf("%s%s%s\n", indent, continuation, r.hyperlink(link, r.paint(style, string(runes[:n]))))
+		runes = runes[n:]
+	}
+}
+
+// shorten cuts text to width characters, ending with the ellipsis of the
+// charset
+func (r *treeRenderer) shorten(text []rune, width int) string {
+	keep := width - len([]rune(r.chars.ellipsis))
+	if keep < 0 {
+		keep = 0
+	}
+	return string(text[:keep]) + r.chars.ellipsis
+}
+
+// style picks the SGR parameters for an entry: by its change in a Diff
+// tree, by type, then for plain files by the executable bit and extension. Empty means uncolored.
+func (r *treeRenderer) style(node *Node) string {
+	if !r.opts.Color {
+		return ""
+	}
+	if style, ok := changeColors[node.Change]; ok {
+		return style
+	}
+
+	key := node.Type
+	if node.Type == "file" {
+		if !node.Executable {
+			return r.opts.Palette[strings.ToLower(pathpkg.Ext(node.Name))]
+		}
+		key = "executable"
+	}
+	if style, ok := r.opts.Palette[key]; ok {
+		return style
+	}
+	return typeColors[key]
+}
+
+// paint wraps text in an entry's color. Only names are painted, after any
+// wrapping, so escapes never count toward the width.
+func (r *treeRenderer) paint(style, text string) string {
+	if style == "" {
+		return text
+	}
+	return "\x1b[" + style + "m" + text + colorReset
+}
+
+// link returns the URL of an entry, if Link gives one
+func (r *treeRenderer) link(node *Node) string {
+	if r.opts.Link == nil {
+		return ""
+	}
+	return r.opts.Link(node)
+}
+
+// hyperlink makes text a link to url with OSC 8 escapes, which like colors
+// never count toward the width. Every line of a wrapped name is a link of
+// its own.
+func (r *treeRenderer) hyperlink(url, text string) string {
+	if url == "" {
+		return text
+	}
+	return "\x1b]8;;" + url + "\x1b\\" + text + "\x1b]8;;\x1b\\"
+}
+
+// printf writes to the output, remembering the first write error
+func (r *treeRenderer) printf(format string, args ...interface{}) {
+	if r.err != nil {
+		return
+	}
+	_, r.err = fmt.Fprintf(r.w, format, args...)
+}
+
+// Paths lists every entry below n, one path per entry relative to n, in
+// the order Render draws them. Directory paths end in a slash. A lone
+// non-directory root lists just its name. Followed submodules count as
+// directories.
+func (n *Node) Paths() []string {
+	var paths []string
+	var walk func(nodes []*Node, prefix string)
+	walk = func(nodes []*Node, prefix string) {
+		for _, node := range nodes {
+			if node.Type == "dir" || node.Children != nil {
+				paths = append(paths, prefix+node.Name+"/")
+				walk(node.Children, prefix+node.Name+"/")
+			} else {
+				paths = append(paths, prefix+node.Name)
+			}
+		}
+	}
+	walk(n.TopLevel(), "")
+	return paths
+}
+
+// shortSHA abbreviates a commit SHA the way git does
+func shortSHA(sha string) string {
+	if len(sha) > 7 {
+		return sha[:7]
+	}
+	return sha
+}
+
+// HumanizeBytes formats a byte count with a binary unit, such as "1.5 KB".
+func HumanizeBytes(n int64) string {
+	if n < 1024 {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	// Scale down by 1024 until the value fits the unit, once rounded to
+	// the one decimal shown, so that 1023.96 KB reads 1.0 MB
+	value := float64(n)
+	for _, unit := range []string{"KB", "MB", "GB", "TB"} {
+		value /= 1024
+		if value < 1023.95 || unit == "TB" {
+			return fmt.Sprintf("%.1f %s", value, unit)
+		}
+	}
+	return ""
+}
+
+// HumanizeAge says how long before now t was, the way GitHub's file
+// listings do, such as "3 days ago" or "last month". Times after now, from
+// a skewed clock, are "just now".
+func HumanizeAge(t, now time.Time) string {
+	d := now.Sub(t)
+	days := int(d / (24 * time.Hour))
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		return plural(int(d/time.Minute), "minute", "minutes") + " ago"
+	case d < 24*time.Hour:
+		return plural(int(d/time.Hour), "hour", "hours") + " ago"
+	case days == 1:
+		return "yesterday"
+	case days < 30:
+		return plural(days, "day", "days") + " ago"
+	case days < 60:
+		return "last month"
+	case days < 365:
+		return plural(days/30, "month", "months") + " ago"
+	case days < 730:
+		return "last year"
+	}
+	return plural(days/365, "year", "years") + " ago"
+}
+
+func plural(n int, one, many string) string {
+	if n == 1 {
+		return "1 " + one
+	}
+	return fmt.Sprintf("%d %s", n, many)
+}
@@ -0,0 +1,143 @@
+package ghtree
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestHumanizeBytes(t *testing.T) {
+	tests := []struct {
+		n    int64
+		want string
+	}{
+		{0, "0 B"},
+		{1, "1 B"},
+		{1023, "1023 B"},
+		{1024, "1.0 KB"},
+		{1536, "1.5 KB"},
+		{1048524, "1023.9 KB"},
+		{1024*1024 - 1, "1.0 MB"},
+		{1024 * 1024, "1.0 MB"},
+		{1024*1024*1024 - 1, "1.0 GB"},
+		{1024 * 1024 * 1024, "1.0 GB"},
+		{5 * 1024 * 1024 * 1024 / 2, "2.5 GB"},
+		{1024 * 1024 * 1024 * 1024, "1.0 TB"},
+		{2048 * 1024 * 1024 * 1024 * 1024, "2048.0 TB"},
+	}
+
+	for _, tt := range tests {
+		if got := HumanizeBytes(tt.n); got != tt.want {
+			t.Errorf("HumanizeBytes(%d) = %q, want %q", tt.n, got, tt.want)
+		}
+	}
+}
+
+// sampleTree holds a directory with a file, a last directory with a long
+// name, and a nested last file, so that every connector shows up
+var sampleTree = &Node{Name: "repo", Type: "dir", Children: []*Node{
+	{Name: "cmd", Path: "cmd", Type: "dir", Children: []*Node{
+		{Name: "main.go", Path: "cmd/main.go", Type: "file", Size: 10},
+	}},
+	{Name: "README.md", Path: "README.md", Type: "file", Size: 5},
+	{Name: "internal-long-name", Path: "internal-long-name", Type: "dir", Children: []*Node{
+		{Name: "a.go", Path: "internal-long-name/a.go", Type: "file", Size: 1},
+		{Name: "b.go", Path: "internal-long-name/b.go", Type: "file", Size: 1},
+	}},
+}}
+
+func TestRenderCharsets(t *testing.T) {
+	tests := []struct {
+		name string
+		opts RenderOptions
+		want string
+	}{
+		{
+			name: "unicode",
+			want: `├── cmd
+│   └── main.go
+├── README.md
+└── internal-long-name
+    ├── a.go
+    └── b.go
+`,
+		},
+		{
+			name: "ascii",
+			opts: RenderOptions{ASCII: true},
+			want: "|-- cmd\n" +
+				"|   `-- main.go\n" +
+				"|-- README.md\n" +
+				"`-- internal-long-name\n" +
+				"    |-- a.go\n" +
+				"    `-- b.go\n",
+		},
+		{
+			name: "unicode, truncated",
+			opts: RenderOptions{Width: 14, Truncate: true},
+			want: `├── cmd
+│   └── main.…
+├── README.md
+└── internal-…
+    ├── a.go
+    └── b.go
+`,
+		},
+		{
+			name: "ascii, truncated",
+			opts: RenderOptions{ASCII: true, Width: 14, Truncate: true},
+			want: "|-- cmd\n" +
+				"|   `-- mai...\n" +
+				"|-- README.md\n" +
+				"`-- interna...\n" +
+				"    |-- a.go\n" +
+				"    `-- b.go\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			err := sampleTree.Render(&buf, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if buf.String() != tt.want {
+				t.Errorf("rendered\n%s\nwant\n%s", buf.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestHumanizeAge(t *testing.T) {
+	now := time.Date(2024, time.March, 15, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		ago  time.Duration
+		want string
+	}{
+		{-time.Hour, "just now"},
+		{0, "just now"},
+		{59 * time.Second, "just now"},
+		{time.Minute, "1 minute ago"},
+		{59 * time.Minute, "59 minutes ago"},
+		{time.Hour, "1 hour ago"},
+		{23 * time.Hour, "23 hours ago"},
+		{24 * time.Hour, "yesterday"},
+		{47 * time.Hour, "yesterday"},
+		{48 * time.Hour, "2 days ago"},
+		{29 * 24 * time.Hour, "29 days ago"},
+		{30 * 24 * time.Hour, "last month"},
+		{59 * 24 * time.Hour, "last month"},
+		{60 * 24 * time.Hour, "2 months ago"},
+		{364 * 24 * time.Hour, "12 months ago"},
+		{365 * 24 * time.Hour, "last year"},
+		{730 * 24 * time.Hour, "2 years ago"},
+		{3653 * 24 * time.Hour, "10 years ago"},
+	}
+
+	for _, tt := range tests {
+		if got := HumanizeAge(now.Add(-tt.ago), now); got != tt.want {
+			t.Errorf("HumanizeAge(now - %s) = %q, want %q", tt.ago, got, tt.want)
+		}
+	}
+}
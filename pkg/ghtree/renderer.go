@@ -0,0 +1,191 @@
+package ghtree
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+// Renderer writes a fetched tree to w in one output format. Implement it
+// and register it with RegisterRenderer to add formats of your own; the
+// built-in ones are registered as "text", "json", "yaml", "paths",
+// "markdown", "html", "dot" and "mermaid".
+type Renderer interface {
+	Render(w io.Writer, root *Node, opts RenderOptions) error
+}
+
+// RendererFunc adapts an ordinary function to the Renderer interface.
+type RendererFunc func(w io.Writer, root *Node, opts RenderOptions) error
+
+// Render calls f(w, root, opts).
+func (f RendererFunc) Render(w io.Writer, root *Node, opts RenderOptions) error {
+	return f(w, root, opts)
+}
+
+// TreesRenderer is a Renderer that can also lay several trees out together,
+// such as the repositories of a batch in one page or one JSON array,
+// rather than one after another. RenderTrees uses it when it can.
+type TreesRenderer interface {
+	Renderer
+	RenderTrees(w io.Writer, roots []*Node, opts RenderOptions) error
+}
+
+// TreesRendererFunc adapts a function drawing several trees to the
+// TreesRenderer interface.
+type TreesRendererFunc func(w io.Writer, roots []*Node, opts RenderOptions) error
+
+// Render calls f with root alone.
+func (f TreesRendererFunc) Render(w io.Writer, root *Node, opts RenderOptions) error {
+	return f(w, []*Node{root}, opts)
+}
+
+// RenderTrees calls f(w, roots, opts).
+func (f TreesRendererFunc) RenderTrees(w io.Writer, roots []*Node, opts RenderOptions) error {
+	return f(w, roots, opts)
+}
+
+// RenderTrees writes roots to w with r: together if r is a TreesRenderer,
+// and one after another otherwise.
+func RenderTrees(w io.Writer, r Renderer, roots []*Node, opts RenderOptions) error {
+	if r, ok := r.(TreesRenderer); ok {
+		return r.RenderTrees(w, roots, opts)
+	}
+	for _, root := range roots {
+		err := r.Render(w, root, opts)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var (
+	renderersMu sync.RWMutex
+	renderers   = map[string]Renderer{
+		"text":     TextRenderer(),
+		"json":     JSONRenderer(),
+		"yaml":     YAMLRenderer(),
+		"paths":    PathsRenderer(),
+		"markdown": MarkdownRenderer(),
+		"html":     HTMLRenderer(),
+		"dot":      DOTRenderer(),
+		"mermaid":  MermaidRenderer(),
+	}
+)
+
+// RegisterRenderer makes r available under name, replacing any renderer
+// registered under it before, built-in ones included. It is safe for
+// concurrent use, and is typically called from an init function. It panics
+// if name is empty or r is nil.
+func RegisterRenderer(name string, r Renderer) {
+	if name == "" || r == nil {
+		panic("ghtree: RegisterRenderer needs a name and a renderer")
+	}
+	renderersMu.Lock()
+	defer renderersMu.Unlock()
+	renderers[name] = r
+}
+
+// LookupRenderer returns the renderer registered under name.
+func LookupRenderer(name string) (Renderer, bool) {
+	renderersMu.RLock()
+	defer renderersMu.RUnlock()
+	r, ok := renderers[name]
+	return r, ok
+}
+
+// RendererNames returns the names of the registered renderers, sorted.
+func RendererNames() []string {
+	renderersMu.RLock()
+	defer renderersMu.RUnlock()
+	names := make([]string, 0, len(renderers))
+	for name := range renderers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// title is the title opts give the page or chart of roots: the root's
+// name when there is one and no Title
+func (opts RenderOptions) title(roots []*Node) string {
+	if opts.Title == "" && len(roots) == 1 {
+		return roots[0].Name
+	}
+	return opts.Title
+}
+
+// TextRenderer draws the tree with box-drawing connectors, as Node.Render does.
+func TextRenderer() Renderer {
+	return RendererFunc(func(w io.Writer, root *Node, opts RenderOptions) error {
+		return root.Render(w, opts)
+	})
+}
+
+// JSONRenderer writes the tree as one indented JSON object, and several
+// trees as an array of them.
+func JSONRenderer() Renderer {
+	return TreesRendererFunc(func(w io.Writer, roots []*Node, opts RenderOptions) error {
+		var v interface{} = roots
+		if len(roots) == 1 {
+			v = roots[0]
+		}
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(v)
+	})
+}
+
+// YAMLRenderer writes the tree as YAML, as RenderYAML does.
+func YAMLRenderer() Renderer {
+	return TreesRendererFunc(func(w io.Writer, roots []*Node, opts RenderOptions) error {
+		return RenderYAML(w, roots...)
+	})
+}
+
+// PathsRenderer writes one path per line, as listed by Node.Paths.
+func PathsRenderer() Renderer {
+	return RendererFunc(func(w io.Writer, root *Node, opts RenderOptions) error {
+		for _, path := range root.Paths() {
+			_, err := fmt.Fprintln(w, path)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// MarkdownRenderer writes the tree as a nested Markdown list, as
+// Node.RenderMarkdown does, with the Annotate text after each name.
+func MarkdownRenderer() Renderer {
+	return RendererFunc(func(w io.Writer, root *Node, opts RenderOptions) error {
+		return root.renderMarkdown(w, opts.Annotate)
+	})
+}
+
+// HTMLRenderer writes the tree as a standalone HTML page headed by
+// RenderOptions.Title, as RenderHTMLPage does; several trees share a page.
+func HTMLRenderer() Renderer {
+	return TreesRendererFunc(func(w io.Writer, roots []*Node, opts RenderOptions) error {
+		return RenderHTMLPage(w, opts.title(roots), roots...)
+	})
+}
+
+// DOTRenderer writes the tree as a Graphviz digraph, as RenderDOT does;
+// several trees share a graph.
+func DOTRenderer() Renderer {
+	return TreesRendererFunc(func(w io.Writer, roots []*Node, opts RenderOptions) error {
+		return RenderDOT(w, opts.title(roots), roots...)
+	})
+}
+
+// MermaidRenderer writes the tree as a Mermaid flowchart, as RenderMermaid
+// does; several trees share a chart.
+func MermaidRenderer() Renderer {
+	return TreesRendererFunc(func(w io.Writer, roots []*Node, opts RenderOptions) error {
+		return RenderMermaid(w, opts.title(roots), roots...)
+	})
+}
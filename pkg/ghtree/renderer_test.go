@@ -0,0 +1,205 @@
+package ghtree
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestRegisterRenderer(t *testing.T) {
+	root := &Node{Name: "repo", Type: "dir", Children: []*Node{
+		{Name: "src", Path: "src", Type: "dir", Children: []*Node{
+			{Name: "main.go", Path: "src/main.go", Type: "file", Size: 10},
+		}},
+		{Name: "README.md", Path: "README.md", Type: "file", Size: 5},
+	}}
+
+	// Counts the entries below the root, under the title it is given
+	RegisterRenderer("test-count", RendererFunc(func(w io.Writer, root *Node, opts RenderOptions) error {
+		_, err := fmt.Fprintf(w, "%s: %d entries\n", opts.title([]*Node{root}), len(root.Paths()))
+		return err
+	}))
+
+	tests := []struct {
+		name  string
+		title string
+		want  string
+	}{
+		{name: "root name as title", want: "repo: 3 entries\n"},
+		{name: "given title", title: "o/r@main", want: "o/r@main: 3 entries\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			renderer, ok := LookupRenderer("test-count")
+			if !ok {
+				t.Fatal("the registered renderer can't be looked up")
+			}
+			var buf bytes.Buffer
+			err := renderer.Render(&buf, root, RenderOptions{Title: tt.title})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if buf.String() != tt.want {
+				t.Errorf("rendered %q, want %q", buf.String(), tt.want)
+			}
+		})
+	}
+
+	found := false
+	for _, name := range RendererNames() {
+		found = found || name == "test-count"
+	}
+	if !found {
+		t.Errorf("RendererNames() = %v, missing test-count", RendererNames())
+	}
+}
+
+func TestBuiltinRenderers(t *testing.T) {
+	root := &Node{Name: "repo", Type: "dir", Children: []*Node{
+		{Name: "src", Path: "src", Type: "dir", Children: []*Node{
+			{Name: "main.go", Path: "src/main.go", Type: "file"},
+		}},
+	}}
+
+	tests := []struct {
+		format string
+		want   string // a part of the output
+	}{
+		{"text", "└── main.go"},
+		{"json", `"path": "src/main.go"`},
+		{"yaml", "main.go"},
+		{"paths", "src/\nsrc/main.go\n"},
+		{"markdown", "main.go"},
+		{"html", "<title>repo</title>"},
+		{"dot", "digraph"},
+		{"mermaid", "graph LR"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			renderer, ok := LookupRenderer(tt.format)
+			if !ok {
+				t.Fatalf("no renderer registered for %s", tt.format)
+			}
+			var buf bytes.Buffer
+			err := renderer.Render(&buf, root, RenderOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(buf.String(), tt.want) {
+				t.Errorf("output lacks %q:\n%s", tt.want, buf.String())
+			}
+		})
+	}
+}
+
+func TestRegisterRendererPanics(t *testing.T) {
+	tests := []struct {
+		name     string
+		renderer Renderer
+	}{
+		{"", TextRenderer()},
+		{"nil", nil},
+	}
+	for _, tt := range tests {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("RegisterRenderer(%q, %v) didn't panic", tt.name, tt.renderer)
+				}
+			}()
+			RegisterRenderer(tt.name, tt.renderer)
+		}()
+	}
+}
+
+func TestMarkdownAnnotations(t *testing.T) {
+	root := &Node{Name: "repo", Type: "dir", Children: []*Node{
+		{Name: "src", Path: "src", Type: "dir", Children: []*Node{
+			{Name: "main.go", Path: "src/main.go", Type: "file", HTMLURL: "https://github.com/o/r/blob/main/src/main.go"},
+		}},
+		{Name: "README.md", Path: "README.md", Type: "file"},
+	}}
+	annotate := func(node *Node) string {
+		if node.Path == "src/main.go" {
+			return "[broken: 404 Not Found]"
+		}
+		return ""
+	}
+
+	var buf bytes.Buffer
+	err := MarkdownRenderer().Render(&buf, root, RenderOptions{Annotate: annotate})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "- src/\n" +
+		"  - [main.go](https://github.com/o/r/blob/main/src/main.go) \\[broken: 404 Not Found\\]\n" +
+		"- README.md\n"
+	if buf.String() != want {
+		t.Errorf("rendered\n%s\nwant\n%s", buf.String(), want)
+	}
+}
+
+func TestRenderTrees(t *testing.T) {
+	roots := []*Node{
+		{Name: "o/a", Type: "dir", Children: []*Node{{Name: "a.go", Path: "a.go", Type: "file"}}},
+		{Name: "o/b", Type: "dir", Children: []*Node{{Name: "b.go", Path: "b.go", Type: "file"}}},
+	}
+
+	tests := []struct {
+		name     string
+		renderer Renderer
+		want     string
+	}{
+		{
+			name:     "one tree after another",
+			renderer: PathsRenderer(),
+			want:     "a.go\nb.go\n",
+		},
+		{
+			name: "trees together",
+			renderer: TreesRendererFunc(func(w io.Writer, roots []*Node, opts RenderOptions) error {
+				_, err := fmt.Fprintf(w, "%s: %d trees\n", opts.title(roots), len(roots))
+				return err
+			}),
+			want: "org: 2 trees\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			err := RenderTrees(&buf, tt.renderer, roots, RenderOptions{Title: "org"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if buf.String() != tt.want {
+				t.Errorf("rendered %q, want %q", buf.String(), tt.want)
+			}
+		})
+	}
+
+	// The built-in formats hold every tree in one document
+	documents := []struct {
+		format string
+		start  string // of the document, only once
+	}{
+		{"html", "<!DOCTYPE html>"},
+		{"dot", "digraph tree {"},
+		{"mermaid", "graph LR"},
+		{"json", "[\n  {"},
+		{"yaml", "- name: o/a"},
+	}
+	for _, tt := range documents {
+		renderer, _ := LookupRenderer(tt.format)
+		var buf bytes.Buffer
+		err := RenderTrees(&buf, renderer, roots, RenderOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := buf.String()
+		if !strings.HasPrefix(got, tt.start) || strings.Count(got, tt.start) != 1 || !strings.Contains(got, "b.go") {
+			t.Errorf("%s drew the trees apart:\n%s", tt.format, got)
+		}
+	}
+}
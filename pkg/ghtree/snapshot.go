@@ -0,0 +1,66 @@
+package ghtree
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Snapshot records every entry below a path of a repository at one point
+// in time, so the tree can later be compared with it. It encodes to JSON.
+type Snapshot struct {
+	Owner   string          `json:"owner"`
+	Repo    string          `json:"repo"`
+	Ref     string          `json:"ref,omitempty"`
+	Path    string          `json:"path"`
+	Taken   time.Time       `json:"taken"`
+	Entries []SnapshotEntry `json:"entries"`
+}
+
+// SnapshotEntry is one entry of a Snapshot, with its path relative to the
+// snapshot's. Mode and Type are git's: "100644", "100755", "120000",
+// "040000" or "160000", and "blob", "tree" or "commit".
+type SnapshotEntry struct {
+	Path string `json:"path"`
+	Mode string `json:"mode"`
+	Type string `json:"type"`
+	SHA  string `json:"sha"`
+	Size int64  `json:"size,omitempty"`
+}
+
+// Snapshot records everything below path as of Options.Ref, with one git
+// trees API request. Exclude and Include don't apply; they filter the
+// comparison instead.
+func (c *Client) Snapshot(ctx context.Context, path string) (*Snapshot, error) {
+	root := strings.Trim(path, "/")
+	entries, err := c.treeAt(ctx, c.opts.Ref, root)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Snapshot{Owner: c.opts.Owner, Repo: c.opts.Repo, Ref: c.opts.Ref, Path: root, Taken: time.Now().UTC(), Entries: []SnapshotEntry{}}
+	for _, entry := range entries {
+		s.Entries = append(s.Entries, SnapshotEntry(entry))
+	}
+	sort.Slice(s.Entries, func(i, j int) bool { return s.Entries[i].Path < s.Entries[j].Path })
+	return s, nil
+}
+
+// DiffSnapshot compares the snapshot's path as of Options.Ref with the
+// snapshot, and returns a tree of the differences as Diff does: entries
+// added since the snapshot are "added", entries gone "removed", and files
+// whose content, mode or type changed "modified".
+func (c *Client) DiffSnapshot(ctx context.Context, s *Snapshot) (*Node, error) {
+	root := strings.Trim(s.Path, "/")
+	current, err := c.treeAt(ctx, c.opts.Ref, root)
+	if err != nil {
+		return nil, err
+	}
+
+	before := make(map[string]gitTreeEntry, len(s.Entries))
+	for _, entry := range s.Entries {
+		before[entry.Path] = gitTreeEntry(entry)
+	}
+	return c.diffTrees(root, before, current, true), nil
+}
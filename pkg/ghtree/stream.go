@@ -0,0 +1,136 @@
+package ghtree
+
+import (
+	"io"
+	"strings"
+	"sync"
+)
+
+// TreeStream draws a tree the way Render does while Fetch is still walking
+// it, each line as soon as the directories above it are listed, so that a
+// large tree neither waits for the whole walk nor is held twice in memory
+// on its way out. Set its Listed method as Options.Listed, then Close it
+// with what Fetch returned.
+//
+// Lines are drawn from the listings alone, so options that need to know
+// what lies below an entry don't mix with streaming: the include filters
+// and size bounds, which prune directories left empty, and FollowSubmodules.
+// Directory totals of RenderOptions.Sizes are left out.
+type TreeStream struct {
+	r    *treeRenderer
+	root string
+
+	mu       sync.Mutex
+	listed   *sync.Cond
+	listings map[string]streamListing
+	closed   bool
+
+	start    sync.Once
+	started  bool
+	finished chan struct{}
+}
+
+// streamListing is a listed directory as TreeStream draws it
+type streamListing struct {
+	entries []*Node
+	omitted int
+
+	// walked says which entries are directories the walk goes on to list
+	walked []bool
+}
+
+// NewTreeStream returns a TreeStream writing to w the tree Fetch walks from
+// path.
+func NewTreeStream(w io.Writer, opts RenderOptions, path string) *TreeStream {
+	s := &TreeStream{
+		r:        newTreeRenderer(w, opts),
+		root:     strings.Trim(path, "/"),
+		listings: map[string]streamListing{},
+		finished: make(chan struct{}),
+	}
+	s.r.streaming = true
+	s.listed = sync.NewCond(&s.mu)
+	return s
+}
+
+// Listed takes the entries of a directory as the walk lists them; it is
+// meant for Options.Listed.
+func (s *TreeStream) Listed(path string, entries []*Node, omitted int) error {
+	// Note which directories the walk continues into now, before it does
+	listing := streamListing{entries: append([]*Node(nil), entries...), omitted: omitted, walked: make([]bool, len(entries))}
+	for i, node := range entries {
+		listing.walked[i] = node.Type == "dir" && !node.Collapsed && node.Children == nil
+	}
+
+	s.mu.Lock()
+	s.listings[strings.Trim(path, "/")] = listing
+	s.listed.Broadcast()
+	s.mu.Unlock()
+
+	s.start.Do(func() {
+		s.started = true
+		go s.draw()
+	})
+	return nil
+}
+
+// Close waits for the tree to be drawn and reports any error writing it.
+// Directories the walk never listed, because it ended early or skipped
+// them, are drawn without entries. A root that was never listed, such as
+// a single file, is drawn whole; root may be nil when Fetch failed.
+func (s *TreeStream) Close(root *Node) error {
+	s.mu.Lock()
+	s.closed = true
+	s.listed.Broadcast()
+	s.mu.Unlock()
+
+	s.start.Do(func() {})
+	if s.started {
+		<-s.finished
+		return s.r.err
+	}
+	if root != nil {
+		return root.Render(s.r.w, s.r.opts)
+	}
+	return nil
+}
+
+// draw writes the tree in order, waiting for each directory's listing
+func (s *TreeStream) draw() {
+	defer close(s.finished)
+	listing, ok := s.wait(s.root)
+	if ok {
+		s.drawListing(listing, "")
+	}
+}
+
+func (s *TreeStream) drawListing(listing streamListing, indent string) {
+	for i, node := range listing.entries {
+		isLast := i == len(listing.entries)-1 && listing.omitted == 0
+		s.r.renderEntry(node, indent, isLast)
+		if !listing.walked[i] {
+			continue
+		}
+		if below, ok := s.wait(node.Path); ok {
+			s.drawListing(below, indent+s.r.indentPrefix(isLast))
+		}
+	}
+	s.r.renderOmitted(listing.omitted, indent)
+}
+
+// wait returns the listing of path once it arrives, or reports false if
+// the stream is closed without it
+func (s *TreeStream) wait(path string) (streamListing, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for {
+		if listing, ok := s.listings[path]; ok {
+			delete(s.listings, path)
+			return listing, true
+		}
+		if s.closed {
+			return streamListing{}, false
+		}
+		s.listed.Wait()
+	}
+}
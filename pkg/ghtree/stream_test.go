@@ -0,0 +1,157 @@
+package ghtree
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// streamListings has directories of several sizes, one of them empty
+var streamListings = map[string][]File{
+	"":      {dir("a"), dir("empty"), dir("src"), file("top.txt", 1)},
+	"a":     {dir("b"), file("a1.txt", 1), file("a2.txt", 1), file("a3.txt", 1)},
+	"a/b":   {dir("c"), file("b.txt", 1)},
+	"a/b/c": {file("c.txt", 1)},
+	"empty": {},
+	"src":   {file("main.go", 1)},
+}
+
+func TestTreeStreamMatchesRender(t *testing.T) {
+	tests := []struct {
+		name  string
+		path  string
+		opts  Options
+		ropts RenderOptions
+	}{
+		{name: "whole tree"},
+		{name: "one request at a time", opts: Options{Concurrency: 1}},
+		{name: "depth limit", opts: Options{MaxDepth: 2}},
+		{name: "entry limit", opts: Options{MaxEntries: 2}},
+		{name: "directories first, reversed", opts: Options{DirsFirst: true, Reverse: true}},
+		{name: "directories only", opts: Options{DirsOnly: true}},
+		{name: "subdirectory", path: "a"},
+		{name: "single file", path: "top.txt"},
+		{name: "ascii", ropts: RenderOptions{ASCII: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := slow(time.Millisecond, &fakeContents{listings: streamListings})
+			root, err := newFakeClient(t, handler, tt.opts).Fetch(context.Background(), tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var want bytes.Buffer
+			err = root.Render(&want, tt.ropts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got bytes.Buffer
+			stream := NewTreeStream(&got, tt.ropts, tt.path)
+			opts := tt.opts
+			opts.Listed = stream.Listed
+			root, err = newFakeClient(t, handler, opts).Fetch(context.Background(), tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = stream.Close(root)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.String() != want.String() {
+				t.Errorf("streamed\n%s\nrendered\n%s", got.String(), want.String())
+			}
+		})
+	}
+}
+
+func TestTreeStreamLeavesOutDirectoryTotals(t *testing.T) {
+	var got bytes.Buffer
+	stream := NewTreeStream(&got, RenderOptions{Sizes: true}, "")
+	client := newFakeClient(t, &fakeContents{listings: map[string][]File{"": {dir("src")}, "src": {file("main.go", 2048)}}}, Options{Listed: stream.Listed})
+
+	root, err := client.Fetch(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = stream.Close(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "└── src\n    └── main.go (2.0 KB)\n"; got.String() != want {
+		t.Errorf("streamed %q, want %q", got.String(), want)
+	}
+}
+
+// syncBuffer is a bytes.Buffer safe to read while a stream writes to it
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestTreeStreamDrawsBeforeTheWalkEnds(t *testing.T) {
+	// The listing of src is held back until the lines above it are out
+	release := make(chan struct{})
+	fake := &fakeContents{listings: map[string][]File{
+		"":     {dir("docs"), dir("src")},
+		"docs": {file("guide.md", 1)},
+		"src":  {file("main.go", 1)},
+	}}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/src") {
+			<-release
+		}
+		fake.ServeHTTP(w, r)
+	})
+
+	out := &syncBuffer{}
+	stream := NewTreeStream(out, RenderOptions{}, "")
+	client := newFakeClient(t, handler, Options{Listed: stream.Listed})
+
+	done := make(chan struct{})
+	var root *Node
+	var err error
+	go func() {
+		defer close(done)
+		root, err = client.Fetch(context.Background(), "")
+	}()
+
+	want := "├── docs\n│   └── guide.md\n└── src\n"
+	deadline := time.Now().Add(5 * time.Second)
+	for out.String() != want {
+		if time.Now().After(deadline) {
+			close(release)
+			t.Fatalf("drew %q while src was being listed, want %q", out.String(), want)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	<-done
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = stream.Close(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want += "    └── main.go\n"; out.String() != want {
+		t.Errorf("streamed %q, want %q", out.String(), want)
+	}
+}
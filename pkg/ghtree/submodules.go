@@ -0,0 +1,237 @@
+package ghtree
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net/url"
+	pathpkg "path"
+	"strings"
+	"sync"
+)
+
+// followSubmodules lists the submodules among nodes and their listed
+// directories, found level levels below the start of the walk, as of the
+// commits they are pinned to. Submodules elsewhere than the client's GitHub
+// host, or whose repositories can't be read, stay unlisted with a warning.
+func (c *Client) followSubmodules(ctx context.Context, nodes []*Node, level int) error {
+	type pinned struct {
+		node  *Node
+		level int
+	}
+	var submodules []pinned
+	var collect func(nodes []*Node, level int)
+	collect = func(nodes []*Node, level int) {
+		for _, node := range nodes {
+			switch node.Type {
+			case "dir":
+				collect(node.Children, level+1)
+			case "submodule":
+				if c.withinDepth(node.Path, level+1) {
+					submodules = append(submodules, pinned{node, level})
+				}
+			}
+		}
+	}
+	collect(nodes, level)
+
+	// Trees API listings don't carry submodule URLs, but .gitmodules does
+	var urls map[string]string
+	for _, s := range submodules {
+		if s.node.SubmoduleURL == "" {
+			urls = c.gitmodules(ctx)
+			break
+		}
+	}
+
+	for _, s := range submodules {
+		if ctx.Err() != nil {
+			return nil
+		}
+		submoduleURL := s.node.SubmoduleURL
+		if submoduleURL == "" {
+			submoduleURL = urls[s.node.Path]
+		}
+		err := c.followSubmodule(ctx, s.node, submoduleURL, s.level)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// followSubmodule lists the submodule node, level levels below the start
+// of the walk, from the repository at submoduleURL, within what is left of
+// the depth limit there. Only errors from Entry and Listing are returned.
+func (c *Client) followSubmodule(ctx context.Context, node *Node, submoduleURL string, level int) error {
+	switch {
+	case node.Commit == "":
+		c.warnf("not following submodule %s: its commit is unknown", node.Path)
+		return nil
+	case submoduleURL == "":
+		c.warnf("not following submodule %s: .gitmodules has no URL for it", node.Path)
+		return nil
+	}
+	owner, repo, ok := c.submoduleRepository(submoduleURL)
+	if !ok {
+		c.warnf("not following submodule %s: %q is not a repository on this GitHub host", node.Path, submoduleURL)
+		return nil
+	}
+	enclosing := append(c.enclosing[:len(c.enclosing):len(c.enclosing)], c.opts.Owner+"/"+c.opts.Repo)
+	for _, r := range enclosing {
+		if strings.EqualFold(r, owner+"/"+repo) {
+			c.warnf("not following submodule %s: it leads back to %s", node.Path, r)
+			return nil
+		}
+	}
+
+	// The submodule counts as a directory of this walk: paths and depths
+	// within it carry on from where it sits
+	prefix := node.Path + "/"
+	var callbackMu sync.Mutex
+	var callbackErr error
+	fail := func(err error) error {
+		callbackMu.Lock()
+		defer callbackMu.Unlock()
+		if err != nil && callbackErr == nil {
+			callbackErr = err
+		}
+		return err
+	}
+	opts := c.opts
+	opts.Owner, opts.Repo, opts.Ref = owner, repo, node.Commit
+	opts.DisplayName = ""
+	opts.DepthOverrides = nil
+	if limit, onTheWay := c.depthLimit(node.Path); limit > 0 && !onTheWay {
+		opts.MaxDepth = limit - level
+	} else {
+		opts.MaxDepth = 0
+	}
+	if c.opts.Listing != nil {
+		opts.Listing = func(path string, files []File) error {
+			return fail(c.opts.Listing(strings.TrimSuffix(prefix+path, "/"), files))
+		}
+	}
+	if c.opts.Entry != nil {
+		opts.Entry = func(n *Node, depth int) error {
+			entry := *n
+			entry.Path = prefix + n.Path
+			return fail(c.opts.Entry(&entry, depth+level))
+		}
+	}
+	sub := NewClient(opts)
+	sub.enclosing = enclosing
+	sub.slots, sub.limiter = c.slots, c.limiter
+
+	c.debugf("following submodule %s into %s/%s at %s", node.Path, owner, repo, shortSHA(node.Commit))
+	tree, err := sub.Fetch(ctx, "")
+	c.addStats(sub.Stats())
+	if callbackErr != nil {
+		return callbackErr
+	}
+	if err != nil && tree == nil {
+		if ctx.Err() == nil {
+			c.warnf("not following submodule %s: %v", node.Path, err)
+		}
+		return nil
+	}
+
+	var prefixPaths func(nodes []*Node)
+	prefixPaths = func(nodes []*Node) {
+		for _, n := range nodes {
+			n.Path = prefix + n.Path
+			prefixPaths(n.Children)
+		}
+	}
+	prefixPaths(tree.Children)
+	node.Children, node.Omitted, node.Hidden = tree.Children, tree.Omitted, tree.Hidden
+	return nil
+}
+
+// submoduleRepository reads the owner and name of the repository a
+// submodule URL names, as git would resolve it: relative URLs are taken
+// from the client's own repository. URLs off the client's GitHub host
+// name no repository it can list.
+func (c *Client) submoduleRepository(submoduleURL string) (owner, repo string, ok bool) {
+	var host, path string
+	switch {
+	case strings.HasPrefix(submoduleURL, "./") || strings.HasPrefix(submoduleURL, "../"):
+		host, path = c.webHost(), pathpkg.Join("/"+c.opts.Owner+"/"+c.opts.Repo, submoduleURL)
+	case strings.Contains(submoduleURL, "://"):
+		u, err := url.Parse(submoduleURL)
+		if err != nil {
+			return "", "", false
+		}
+		host, path = u.Hostname(), u.Path
+	default:
+		// The scp-like form, as in git@github.com:owner/repo.git
+		userHost, rest, found := strings.Cut(submoduleURL, ":")
+		if !found {
+			return "", "", false
+		}
+		host, path = userHost[strings.LastIndex(userHost, "@")+1:], rest
+	}
+	if !strings.EqualFold(host, c.webHost()) {
+		return "", "", false
+	}
+
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	if len(segments) != 2 {
+		return "", "", false
+	}
+	owner, repo = segments[0], strings.TrimSuffix(segments[1], ".git")
+	if CheckRepository(owner, repo) != nil {
+		return "", "", false
+	}
+	return owner, repo, true
+}
+
+// webHost is the host GitHub serves the client's repositories from
+func (c *Client) webHost() string {
+	u, err := url.Parse(c.opts.APIURL)
+	if err != nil || u.Host == "api.github.com" {
+		return "github.com"
+	}
+	return u.Hostname()
+}
+
+// gitmodules maps the paths of the repository's submodules to their URLs,
+// as its .gitmodules file records them. A missing or unreadable file maps
+// nothing.
+func (c *Client) gitmodules(ctx context.Context) map[string]string {
+	content, err := c.ReadFile(ctx, ".gitmodules")
+	if err != nil {
+		c.debugf("can't read .gitmodules: %v", err)
+		return nil
+	}
+
+	// Each [submodule "name"] section has a path and a url
+	urls := map[string]string{}
+	var path, submoduleURL string
+	flush := func() {
+		if path != "" && submoduleURL != "" {
+			urls[strings.Trim(path, "/")] = submoduleURL
+		}
+		path, submoduleURL = "", ""
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") {
+			flush()
+			continue
+		}
+		key, value, found := strings.Cut(line, "=")
+		if !found {
+			continue
+		}
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "path":
+			path = strings.TrimSpace(value)
+		case "url":
+			submoduleURL = strings.TrimSpace(value)
+		}
+	}
+	flush()
+	return urls
+}
@@ -0,0 +1,121 @@
+package ghtree
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// TokenInfo describes the token a Client authenticates with.
+type TokenInfo struct {
+	// Kind is "classic", "fine-grained", "oauth", "app-user" or "app" as
+	// told by the token's prefix, "unknown" for a token without a known
+	// one, or "" when requests are anonymous.
+	Kind string
+
+	// Login is the user the token acts for; it is empty for anonymous
+	// requests and App installations, which act for no user.
+	Login string
+
+	// Scopes lists the scopes of a classic or OAuth token; it is nil for
+	// the other kinds, whose access is granted per repository instead.
+	Scopes []string
+
+	// Expires is when the token expires, or zero if GitHub didn't say.
+	Expires time.Time
+}
+
+// HasScope reports whether the token was granted scope. Kinds without
+// scopes report false.
+func (t *TokenInfo) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// tokenKinds maps the prefixes GitHub gives its tokens to their kind
+var tokenKinds = []struct{ prefix, kind string }{
+	{"ghp_", "classic"},
+	{"github_pat_", "fine-grained"},
+	{"gho_", "oauth"},
+	{"ghu_", "app-user"},
+	{"ghs_", "app"},
+}
+
+// TokenKind tells the kind of token by its prefix, as in TokenInfo.Kind.
+func TokenKind(token string) string {
+	if token == "" {
+		return ""
+	}
+	for _, k := range tokenKinds {
+		if strings.HasPrefix(token, k.prefix) {
+			return k.kind
+		}
+	}
+	return "unknown"
+}
+
+// TokenInfo asks GitHub about the client's token. It fetches the user the
+// token acts for, or the rate limit for tokens that act for none, so the
+// client's Stats hold the token's rate-limit budget afterwards. A token
+// GitHub turns down comes back as an *APIError with status 401.
+func (c *Client) TokenInfo(ctx context.Context) (*TokenInfo, error) {
+	token, err := c.token(ctx)
+	if err != nil {
+		return nil, err
+	}
+	info := &TokenInfo{Kind: TokenKind(token)}
+	if c.opts.App != nil {
+		info.Kind = "app"
+	}
+
+	// Installations can't read /user, and anonymous callers have no user;
+	// the rate limit answers either without spending any of it
+	infoURL := c.apiURL("user")
+	if info.Kind == "" || info.Kind == "app" {
+		infoURL = c.apiURL("rate_limit")
+	}
+	resp, body, err := c.do(ctx, infoURL)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(infoURL, resp, body)
+	}
+
+	if infoURL == c.apiURL("user") {
+		var user struct {
+			Login string `json:"login"`
+		}
+		err = json.Unmarshal(body, &user)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse user info: %w", err)
+		}
+		info.Login = user.Login
+	}
+	if info.Kind != "" {
+		info.Scopes = oauthScopes(resp)
+	}
+
+	// Tokens from before GitHub's prefixes that report scopes are classic
+	if info.Kind == "unknown" && info.Scopes != nil {
+		info.Kind = "classic"
+	}
+
+	// GitHub writes the expiry as "2006-01-02 15:04:05 UTC" or with an
+	// offset, depending on the token
+	expires := resp.Header.Get("GitHub-Authentication-Token-Expiration")
+	for _, layout := range []string{"2006-01-02 15:04:05 MST", "2006-01-02 15:04:05 -0700"} {
+		if t, err := time.Parse(layout, expires); err == nil {
+			info.Expires = t
+			break
+		}
+	}
+	return info, nil
+}
@@ -0,0 +1,175 @@
+package ghtree
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	pathpkg "path"
+	"strings"
+)
+
+// treeListings holds every directory listing of a tree, keyed by the
+// directory's repository path without surrounding slashes
+type treeListings map[string][]File
+
+func (t treeListings) list(ctx context.Context, path string) ([]File, error) {
+	// Directories without entries never show up as a parent
+	files, ok := t[strings.Trim(path, "/")]
+	if !ok {
+		return []File{}, nil
+	}
+	return files, nil
+}
+
+// count returns how many entries the directory at path holds
+func (t treeListings) count(path string) int {
+	return len(t[strings.Trim(path, "/")])
+}
+
+// gitTreeEntry is one entry of a git trees API response
+type gitTreeEntry struct {
+	Path string `json:"path"`
+	Mode string `json:"mode"`
+	Type string `json:"type"`
+	SHA  string `json:"sha"`
+	Size int64  `json:"size"`
+}
+
+// file converts the entry to the form directory listings use
+func (e gitTreeEntry) file() File {
+	f := File{Name: pathpkg.Base(e.Path), SHA: e.SHA, Size: e.Size}
+	switch {
+	case e.Type == "tree":
+		f.Type = "dir"
+	case e.Type == "commit":
+		f.Type = "submodule"
+	case e.Mode == "120000":
+		f.Type = "symlink"
+	default:
+		f.Type = "file"
+		f.Executable = e.Mode == "100755"
+	}
+	return f
+}
+
+// getGitTree requests the git tree at path as of ref, with everything below
+// it when recursive is set. It also reports whether GitHub truncated the
+// response.
+func (c *Client) getGitTree(ctx context.Context, ref, path string, recursive bool) ([]gitTreeEntry, bool, error) {
+	root := strings.Trim(path, "/")
+	body, err := c.get(ctx, c.gitTreeURL(ref, path, recursive))
+	if err != nil {
+		return nil, false, c.describeListingError(err, path)
+	}
+
+	var tree struct {
+		SHA       string         `json:"sha"`
+		Tree      []gitTreeEntry `json:"tree"`
+		Truncated bool           `json:"truncated"`
+	}
+	err = json.Unmarshal(body, &tree)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to parse the tree of %q: %w", root, err)
+	}
+
+	// A whole tree can be walked again offline
+	if recursive && root == "" && !tree.Truncated {
+		c.storeTree(ref, tree.SHA, tree.Tree)
+	}
+	return tree.Tree, tree.Truncated, nil
+}
+
+// gitTreeURL is the git trees API URL for path as of ref
+func (c *Client) gitTreeURL(ref, path string, recursive bool) string {
+	// The trees API takes any tree-ish, so <ref>:<path> names a subtree
+	root := strings.Trim(path, "/")
+	treeish := ref
+	if treeish == "" {
+		treeish = "HEAD"
+	}
+	if root != "" {
+		treeish += ":" + root
+	}
+	escaped := (&url.URL{Path: treeish}).EscapedPath()
+	treeURL := c.apiURL("repos/%s/%s/git/trees/%s", c.opts.Owner, c.opts.Repo, escaped)
+	if recursive {
+		treeURL += "?recursive=1"
+	}
+	return treeURL
+}
+
+// fetchGitTree lists everything under path with one recursive git trees API
+// request. It returns nil listings when GitHub truncates the response, so
+// the caller can fall back to listing directories one by one.
+func (c *Client) fetchGitTree(ctx context.Context, path string) (treeListings, error) {
+	root := strings.Trim(path, "/")
+	entries, truncated, err := c.getGitTree(ctx, c.opts.Ref, path, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if truncated {
+		c.warnf("the tree of %q is too large for a single request; listing directories one by one instead", root)
+		return nil, nil
+	}
+	c.debugf("listed %q: %d entries in one tree", root, len(entries))
+	return groupTreeEntries(root, entries), nil
+}
+
+// groupTreeEntries regroups the flat, depth-first entries of a recursive
+// tree of root by parent directory
+func groupTreeEntries(root string, entries []gitTreeEntry) treeListings {
+	listings := treeListings{}
+	for _, entry := range entries {
+		dir := pathpkg.Dir(entry.Path)
+		if dir == "." {
+			dir = ""
+		}
+		key := strings.Trim(root+"/"+dir, "/")
+		listings[key] = append(listings[key], entry.file())
+	}
+	return listings
+}
+
+// collapsedCounts fetches the recursive tree of path to count what lies
+// in the directories the depth limit collapses, or returns nil if GitHub
+// truncates it
+func (c *Client) collapsedCounts(ctx context.Context, path string) (treeListings, error) {
+	entries, truncated, err := c.getGitTree(ctx, c.opts.Ref, path, true)
+	if err != nil {
+		return nil, err
+	}
+	if truncated {
+		c.debugf("the tree of %q is too large to count collapsed directories from", strings.Trim(path, "/"))
+		return nil, nil
+	}
+	return groupTreeEntries(strings.Trim(path, "/"), entries), nil
+}
+
+// completeListing fills in a contents API listing cut off at its limit with
+// the rest of the directory's entries from the git trees API. Entries the
+// contents API did return keep their URLs and symlink targets.
+func (c *Client) completeListing(ctx context.Context, path string, files []File) ([]File, error) {
+	entries, truncated, err := c.getGitTree(ctx, c.opts.Ref, path, false)
+	if err != nil {
+		return nil, err
+	}
+	if truncated {
+		c.warnf("%q has more entries than the git trees API returns; the listing is incomplete", strings.Trim(path, "/"))
+	}
+
+	listed := make(map[string]File, len(files))
+	for _, f := range files {
+		listed[f.Name] = f
+	}
+	complete := make([]File, 0, len(entries))
+	for _, entry := range entries {
+		f, ok := listed[pathpkg.Base(entry.Path)]
+		if !ok {
+			f = entry.file()
+		}
+		complete = append(complete, f)
+	}
+	return complete, nil
+}
@@ -0,0 +1,139 @@
+package ghtree
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// storedTree is the whole tree of a repository kept in Options.TreeDir
+type storedTree struct {
+	SHA     string         `json:"sha"`
+	Stored  time.Time      `json:"stored"`
+	Entries []gitTreeEntry `json:"entries"`
+}
+
+// treeStorePath names the directory holding the stored trees of the
+// client's repository
+func (c *Client) treeStorePath() string {
+	key := c.opts.APIURL + "\x00" + c.opts.Owner + "\x00" + c.opts.Repo
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(c.opts.TreeDir, hex.EncodeToString(sum[:]))
+}
+
+// treeRefPath names the file recording the SHA of the tree stored for ref
+func (c *Client) treeRefPath(ref string) string {
+	sum := sha256.Sum256([]byte(ref))
+	return filepath.Join(c.treeStorePath(), "ref-"+hex.EncodeToString(sum[:8]))
+}
+
+// storeTree keeps the whole tree sha of the repository as of ref, and
+// drops the trees no ref records anymore. Like the listing cache, a store
+// that can't be written only costs requests later, so failures are
+// reported as warnings.
+func (c *Client) storeTree(ref, sha string, entries []gitTreeEntry) {
+	if c.opts.TreeDir == "" || c.opts.Offline || sha == "" {
+		return
+	}
+
+	dir := c.treeStorePath()
+	data, err := json.Marshal(storedTree{SHA: sha, Stored: time.Now(), Entries: entries})
+	if err == nil {
+		err = os.MkdirAll(dir, 0700)
+	}
+	if err == nil {
+		err = os.WriteFile(filepath.Join(dir, sha+".json"), data, 0600)
+	}
+	if err == nil {
+		err = os.WriteFile(c.treeRefPath(ref), []byte(sha), 0600)
+	}
+	if err != nil {
+		c.warnf("failed to store the tree of %s/%s: %v", c.opts.Owner, c.opts.Repo, err)
+		return
+	}
+	c.debugf("stored tree %s of %s/%s for offline walks", shortSHA(sha), c.opts.Owner, c.opts.Repo)
+
+	// Trees are only reached through their refs
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	recorded := map[string]bool{}
+	for _, f := range files {
+		if strings.HasPrefix(f.Name(), "ref-") {
+			if data, err := os.ReadFile(filepath.Join(dir, f.Name())); err == nil {
+				recorded[string(data)+".json"] = true
+			}
+		}
+	}
+	for _, f := range files {
+		if strings.HasSuffix(f.Name(), ".json") && !recorded[f.Name()] {
+			os.Remove(filepath.Join(dir, f.Name()))
+		}
+	}
+}
+
+// storedListings lists the directories of a stored tree
+type storedListings struct {
+	listings treeListings
+	entries  map[string]gitTreeEntry
+	name     string
+}
+
+func (s *storedListings) list(ctx context.Context, path string) ([]File, error) {
+	path = strings.Trim(path, "/")
+	if files, ok := s.listings[path]; ok || path == "" {
+		return files, nil
+	}
+	entry, ok := s.entries[path]
+	switch {
+	case !ok:
+		return nil, fmt.Errorf("%q is not in the stored tree of %s", path, s.name)
+	case entry.Type != "tree":
+		return nil, &notDirectoryError{file: entry.file()}
+	}
+	return []File{}, nil
+}
+
+// loadStoredTree reads the tree stored for the client's repository and
+// ref, for Options.Offline
+func (c *Client) loadStoredTree() (*storedListings, error) {
+	name := c.opts.Owner + "/" + c.opts.Repo
+	if c.opts.Ref != "" {
+		name += "@" + c.opts.Ref
+	}
+	if c.opts.TreeDir == "" {
+		return nil, errors.New("offline walks need a directory of stored trees")
+	}
+
+	sha, err := os.ReadFile(c.treeRefPath(c.opts.Ref))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("no tree of %s is stored; walk all of it online first", name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the stored tree of %s: %w", name, err)
+	}
+	data, err := os.ReadFile(filepath.Join(c.treeStorePath(), string(sha)+".json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the stored tree of %s: %w", name, err)
+	}
+	var tree storedTree
+	err = json.Unmarshal(data, &tree)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the stored tree of %s: %w", name, err)
+	}
+	c.debugf("walking tree %s of %s, stored %s", shortSHA(tree.SHA), name, tree.Stored.Format(time.RFC3339))
+
+	entries := make(map[string]gitTreeEntry, len(tree.Entries))
+	for _, entry := range tree.Entries {
+		entries[entry.Path] = entry
+	}
+	return &storedListings{listings: groupTreeEntries("", tree.Entries), entries: entries, name: name}, nil
+}
@@ -0,0 +1,199 @@
+package ghtree
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// yamlField is one key of a YAML mapping; fields keep the order JSON
+// encoding gave them
+type yamlField struct {
+	key   string
+	value interface{}
+}
+
+// RenderYAML writes roots as YAML with the same structure and field names
+// as the JSON output: one mapping for a single root, a sequence of them for
+// several.
+func RenderYAML(w io.Writer, roots ...*Node) error {
+	var v interface{} = roots
+	if len(roots) == 1 {
+		v = roots[0]
+	}
+
+	// Going through JSON keeps the field names and omitted fields in step
+	// with the JSON output
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	value, err := decodeOrdered(decoder)
+	if err != nil {
+		return err
+	}
+
+	var b bytes.Buffer
+	switch value.(type) {
+	case []yamlField, []interface{}:
+		writeYAML(&b, value, "")
+	default:
+		b.WriteString(yamlScalar(value) + "\n")
+	}
+	_, err = w.Write(b.Bytes())
+	return err
+}
+
+// decodeOrdered reads the next JSON value, decoding objects as []yamlField
+// so their keys stay in order
+func decodeOrdered(decoder *json.Decoder) (interface{}, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch token {
+	case json.Delim('{'):
+		fields := []yamlField{}
+		for decoder.More() {
+			key, err := decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := decodeOrdered(decoder)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, yamlField{key.(string), value})
+		}
+		_, err = decoder.Token()
+		return fields, err
+	case json.Delim('['):
+		items := []interface{}{}
+		for decoder.More() {
+			item, err := decodeOrdered(decoder)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		_, err = decoder.Token()
+		return items, err
+	}
+	return token, nil
+}
+
+// writeYAML writes a mapping or sequence in block style, each line
+// starting with indent
+func writeYAML(b *bytes.Buffer, value interface{}, indent string) {
+	switch value := value.(type) {
+	case []yamlField:
+		for _, field := range value {
+			b.WriteString(indent + yamlScalar(field.key) + ":")
+			writeYAMLValue(b, field.value, indent+"  ")
+		}
+	case []interface{}:
+		for _, item := range value {
+			// A nested mapping or sequence starts on the dash's line
+			switch item.(type) {
+			case []yamlField, []interface{}:
+				if isEmptyYAML(item) {
+					b.WriteString(indent + "-")
+					writeYAMLValue(b, item, indent+"  ")
+					continue
+				}
+				var nested bytes.Buffer
+				writeYAML(&nested, item, indent+"  ")
+				b.WriteString(indent + "- ")
+				b.Write(nested.Bytes()[len(indent)+2:])
+			default:
+				b.WriteString(indent + "- " + yamlScalar(item) + "\n")
+			}
+		}
+	}
+}
+
+// writeYAMLValue finishes the line of a key or dash with value, which is
+// written on the following lines when it is a non-empty collection
+func writeYAMLValue(b *bytes.Buffer, value interface{}, indent string) {
+	switch value.(type) {
+	case []yamlField, []interface{}:
+		if isEmptyYAML(value) {
+			if _, ok := value.([]yamlField); ok {
+				b.WriteString(" {}\n")
+			} else {
+				b.WriteString(" []\n")
+			}
+			return
+		}
+		b.WriteString("\n")
+		writeYAML(b, value, indent)
+	default:
+		b.WriteString(" " + yamlScalar(value) + "\n")
+	}
+}
+
+func isEmptyYAML(value interface{}) bool {
+	switch value := value.(type) {
+	case []yamlField:
+		return len(value) == 0
+	case []interface{}:
+		return len(value) == 0
+	}
+	return false
+}
+
+// yamlPlain matches strings that can go unquoted, and yamlTimestamp the
+// ones among them a YAML 1.1 parser would read as a date
+var (
+	yamlPlain     = regexp.MustCompile(`^[A-Za-z0-9_./][A-Za-z0-9_./+@() -]*$`)
+	yamlTimestamp = regexp.MustCompile(`^[0-9]{4}-[0-9]{1,2}-[0-9]{1,2}`)
+)
+
+// yamlReserved holds the plain words YAML reads as booleans, nulls or
+// special floats rather than strings
+var yamlReserved = map[string]bool{
+	"y": true, "yes": true, "n": true, "no": true, "true": true, "false": true,
+	"on": true, "off": true, "null": true, "~": true,
+	".inf": true, "-.inf": true, "+.inf": true, ".nan": true,
+}
+
+// yamlScalar formats a decoded JSON scalar, quoting strings that would
+// otherwise read as something else
+func yamlScalar(value interface{}) string {
+	switch value := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return strconv.FormatBool(value)
+	case json.Number:
+		return value.String()
+	case string:
+		if yamlNeedsQuotes(value) {
+			// A JSON string is also a valid double-quoted YAML scalar
+			quoted, _ := json.Marshal(value)
+			return string(quoted)
+		}
+		return value
+	}
+	return ""
+}
+
+func yamlNeedsQuotes(s string) bool {
+	if !yamlPlain.MatchString(s) || strings.HasSuffix(s, " ") || yamlReserved[strings.ToLower(s)] {
+		return true
+	}
+	if yamlTimestamp.MatchString(s) {
+		return true
+	}
+	if _, err := strconv.ParseFloat(s, 64); err == nil {
+		return true
+	}
+	_, err := strconv.ParseInt(s, 0, 64)
+	return err == nil
+}
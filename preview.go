@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/sbdtu5498/github-tree/pkg/ghtree"
+)
+
+// previewLimit is how much of each file --preview shows: a number of lines,
+// or of bytes
+type previewLimit struct {
+	n     int
+	bytes bool
+}
+
+// parsePreview reads --preview: N lines, or N bytes with a "b" suffix
+func parsePreview(s string) (previewLimit, error) {
+	limit := previewLimit{}
+	digits := s
+	if trimmed := strings.TrimRight(s, "bB"); trimmed != s {
+		digits, limit.bytes = trimmed, true
+	}
+	n, err := strconv.Atoi(digits)
+	if err != nil || n < 1 {
+		return previewLimit{}, fmt.Errorf("invalid --preview %q; use a number of lines, or bytes with a b suffix as in 512b", s)
+	}
+	limit.n = n
+	return limit, nil
+}
+
+// previewReadLimit caps how much of a file is read for a line preview, so a
+// file without newlines can't make one line of any length
+const previewReadLimit = 64 << 10
+
+// errPreviewFull stops a download once the preview has what it needs
+var errPreviewFull = errors.New("preview complete")
+
+// previewBuffer collects the start of a file, failing writes past max
+type previewBuffer struct {
+	buf   bytes.Buffer
+	max   int
+	lines int
+}
+
+func (b *previewBuffer) Write(p []byte) (int, error) {
+	room := b.max - b.buf.Len()
+	full := len(p) >= room
+	if full {
+		p = p[:room]
+	}
+	if b.lines > 0 && bytes.Count(b.buf.Bytes(), []byte("\n"))+bytes.Count(p, []byte("\n")) >= b.lines {
+		full = true
+	}
+	n, _ := b.buf.Write(p)
+	if full {
+		return n, errPreviewFull
+	}
+	return n, nil
+}
+
+// fetchPreviews reads the start of every file below roots, concurrently,
+// and returns the lines to show for each text file, keyed by path. Files
+// that look binary get none, and files that can't be read are warned
+// about. With --local the files are read from disk.
+func fetchPreviews(ctx context.Context, client *ghtree.Client, roots []*ghtree.Node, limit previewLimit) map[string][]string {
+	var files []*ghtree.Node
+	var collect func(nodes []*ghtree.Node)
+	collect = func(nodes []*ghtree.Node) {
+		for _, node := range nodes {
+			if node.Type == "file" {
+				files = append(files, node)
+			}
+			collect(node.Children)
+		}
+	}
+	for _, root := range roots {
+		collect(root.TopLevel())
+	}
+
+	previews := map[string][]string{}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, node := range files {
+		wg.Add(1)
+		go func(node *ghtree.Node) {
+			defer wg.Done()
+			buf := &previewBuffer{max: previewReadLimit}
+			if limit.bytes {
+				buf.max = limit.n
+			} else {
+				buf.lines = limit.n
+			}
+
+			var err error
+			if localFlag != "" {
+				err = readPreview(filepath.Join(localFlag, filepath.FromSlash(node.Path)), buf)
+			} else {
+				_, err = client.Download(ctx, node, buf)
+			}
+			if err != nil && !errors.Is(err, errPreviewFull) {
+				if ctx.Err() == nil {
+					warnf("failed to preview %s: %v", node.Path, err)
+				}
+				return
+			}
+
+			lines := previewLines(buf.buf.Bytes(), limit)
+			if lines != nil {
+				mu.Lock()
+				previews[node.Path] = lines
+				mu.Unlock()
+			}
+		}(node)
+	}
+	wg.Wait()
+	return previews
+}
+
+// readPreview copies the start of a local file into buf
+func readPreview(path string, buf *previewBuffer) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(buf, f)
+	return err
+}
+
+// previewLines splits the start of a file into the lines to show, or
+// returns nil for content that looks binary: a NUL byte, or invalid UTF-8
+// short of a character cut off at the end
+func previewLines(data []byte, limit previewLimit) []string {
+	if len(data) == 0 || bytes.IndexByte(data, 0) >= 0 {
+		return nil
+	}
+	for i := 0; i < utf8.UTFMax && !utf8.Valid(data); i++ {
+		data = data[:len(data)-1]
+	}
+	if !utf8.Valid(data) {
+		return nil
+	}
+
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if !limit.bytes && len(lines) > limit.n {
+		lines = lines[:limit.n]
+	}
+	for i, line := range lines {
+		lines[i] = strings.Map(printable, strings.ReplaceAll(strings.TrimSuffix(line, "\r"), "\t", "    "))
+	}
+	return lines
+}
+
+// printable keeps control characters in file contents, such as terminal
+// escapes, from reaching the output
+func printable(r rune) rune {
+	if unicode.IsControl(r) {
+		return '?'
+	}
+	return r
+}
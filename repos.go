@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	pathpkg "path"
+	"strings"
+	"sync"
+
+	"github.com/sbdtu5498/github-tree/pkg/ghtree"
+)
+
+// repoList holds the repositories given with --repo, which may be repeated
+// to draw several in one run. Each is a name, owned by --owner, or
+// owner/name; repoFlag holds the first.
+type repoList []string
+
+func (l *repoList) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(*l, ",")
+}
+
+func (l *repoList) Set(value string) error {
+	*l = append(*l, value)
+	repoFlag = (*l)[0]
+	return nil
+}
+
+// readRepositories returns the repositories of this run: those given with
+// --repo, then the lines of --repos-file. Blank lines and "#" comments are
+// skipped.
+func readRepositories() ([]string, error) {
+	repos := append([]string(nil), repoArgs...)
+	if reposFileFlag == "" {
+		return repos, nil
+	}
+
+	f, err := os.Open(reposFileFlag)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read repository list: %w", err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		if line = strings.TrimSpace(line); line != "" {
+			repos = append(repos, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read repository list: %w", err)
+	}
+	if len(repos) == 0 {
+		return nil, fmt.Errorf("%s lists no repositories", reposFileFlag)
+	}
+	return repos, nil
+}
+
+// splitRepository splits a --repo value into its owner, or owner if it
+// names none, and its name
+func splitRepository(value, owner string) (string, string) {
+	if o, name, ok := strings.Cut(value, "/"); ok {
+		return o, name
+	}
+	return owner, value
+}
+
+// checkRepositoryFilters validates the flags that pick among an owner's
+// repositories with --all-repos
+func checkRepositoryFilters() error {
+	if _, err := pathpkg.Match(repoFilterFlag, ""); err != nil {
+		return fmt.Errorf("invalid --repo-filter pattern %q: %w", repoFilterFlag, err)
+	}
+	switch visibilityFlag {
+	case "all", "public", "private", "internal":
+	default:
+		return fmt.Errorf("unknown --visibility %q; use all, public, private or internal", visibilityFlag)
+	}
+	switch archivedFlag {
+	case "exclude", "include", "only":
+	default:
+		return fmt.Errorf("unknown --archived %q; use exclude, include or only", archivedFlag)
+	}
+	return nil
+}
+
+// ownerRepositories lists the repositories of owner that --repo-filter,
+// --visibility and --archived keep, as owner/name
+func ownerRepositories(ctx context.Context, client *ghtree.Client, owner string) ([]string, error) {
+	all, err := client.ListRepositories(ctx, owner)
+	if err != nil {
+		return nil, err
+	}
+
+	var repos []string
+	for _, repo := range all {
+		if repoFilterFlag != "" {
+			if ok, _ := pathpkg.Match(repoFilterFlag, repo.Name); !ok {
+				continue
+			}
+		}
+		if visibilityFlag != "all" && repo.Visibility != visibilityFlag {
+			continue
+		}
+		if archivedFlag == "exclude" && repo.Archived || archivedFlag == "only" && !repo.Archived {
+			continue
+		}
+		repos = append(repos, owner+"/"+repo.Name)
+	}
+	if len(all) == 0 {
+		return nil, fmt.Errorf("%s has no repositories you can see", owner)
+	}
+	if len(repos) == 0 {
+		return nil, fmt.Errorf("none of the %s of %s match the filters", plural(len(all), "repository", "repositories"), owner)
+	}
+	debugf("drawing %s of %d", plural(len(repos), "repository", "repositories"), len(all))
+	return repos, nil
+}
+
+// singleRepositoryOnly names the first option given that works on one
+// repository at a time, or returns "" if there is none
+func singleRepositoryOnly() string {
+	for _, option := range []struct {
+		name string
+		set  bool
+	}{
+		{"--url", urlFlag != ""},
+		{"--gist", gistFlag != ""},
+		{"--local", localFlag != ""},
+		{"--base", baseFlag != ""},
+		{"--diff-repo", diffRepoFlag != ""},
+		{"--verify", verifyFlag},
+		{"--snapshot", snapshotFlag},
+		{"--since-snapshot", sinceSnapshotFlag != ""},
+		{"--watch", watchFlag},
+		{"--interactive", interactiveFlag},
+		{"--list-refs", listRefsFlag},
+		{"--list-repos", listReposFlag},
+		{"--preflight", preflightFlag},
+		{"--dry-run", dryRunFlag},
+		{"--download", downloadFlag != ""},
+		{"--archive", archiveFlag != ""},
+		{"--zip", zipFlag != ""},
+		{"--search", searchFlag != ""},
+		{"--du", duFlag},
+		{"--preview", previewFlag != ""},
+		{"--owners", ownersFlag},
+		{"--last-commit", lastCommitFlag},
+		{"--lfs", lfsFlag},
+		{"--hyperlinks", hyperlinksFlag},
+		{"--save", saveFlag},
+		{"--format jsonl", formatFlag == "jsonl"},
+		{"--traversal bfs", traversalFlag == "bfs"},
+		{"--format raw-urls", formatFlag == "raw-urls"},
+		{"--verify-urls", verifyURLsFlag},
+		{"--display-name", displayNameFlag != ""},
+	} {
+		if option.set {
+			return option.name
+		}
+	}
+	return ""
+}
+
+// fetchRepositories lists paths in each of repos, --parallel repositories at
+// a time, and returns a root for each, named owner/name, holding what was
+// listed there. client serves the first repository; the others get a client
+// of their own from opts. A repository that can't be listed is reported and
+// left out, and failed counts them.
+func fetchRepositories(ctx context.Context, client *ghtree.Client, opts ghtree.Options, repos []string, paths []string, tokenSource string) (roots []*ghtree.Node, failed int, err error) {
+	type result struct {
+		root *ghtree.Node
+		err  error
+	}
+	results := make([]result, len(repos))
+
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, parallelFlag)
+	for i, repo := range repos {
+		wg.Add(1)
+		go func(i int, repo string) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			c := client
+			if i > 0 {
+				repoOpts := opts
+				repoOpts.Owner, repoOpts.Repo = splitRepository(repo, opts.Owner)
+				if opts.Ignore != nil {
+					repoOpts.Ignore = &ghtree.IgnoreRules{}
+				}
+				c = ghtree.NewClient(repoOpts)
+				if repoOpts.Ignore != nil {
+					err := loadIgnoreRules(ctx, c, repoOpts.Ignore)
+					if err != nil {
+						results[i].err = err
+						return
+					}
+				}
+			}
+			results[i].root, results[i].err = fetchRepository(ctx, c, repo, opts.Owner, paths)
+		}(i, repo)
+	}
+	wg.Wait()
+
+	var incomplete *ghtree.IncompleteError
+	for i, result := range results {
+		if errors.As(result.err, &incomplete) {
+			roots = append(roots, result.root)
+			return roots, failed, incomplete
+		}
+		if result.err != nil {
+			if ctx.Err() != nil {
+				return nil, 0, result.err
+			}
+			writeLog("error", fmt.Sprintf("%s: %v", repos[i], describeError(result.err, tokenSource)), map[string]interface{}{"repo": repos[i]})
+			failed++
+			continue
+		}
+		roots = append(roots, result.root)
+	}
+	return roots, failed, nil
+}
+
+// fetchRepository lists paths in one repository under a root named after
+// it. A single path is drawn as the repository's contents; several are
+// drawn side by side, by their full paths.
+func fetchRepository(ctx context.Context, client *ghtree.Client, repo, owner string, paths []string) (*ghtree.Node, error) {
+	owner, name := splitRepository(repo, owner)
+	root := &ghtree.Node{Name: owner + "/" + name, Path: owner + "/" + name, Type: "dir", Children: []*ghtree.Node{}}
+	for _, path := range paths {
+		tree, err := client.Fetch(ctx, path)
+		if tree != nil {
+			if len(paths) == 1 && tree.Type == "dir" {
+				root.Children = tree.Children
+				root.Omitted, root.Hidden = tree.Omitted, tree.Hidden
+			} else {
+				if path != "" {
+					tree.Name = path
+				}
+				root.Children = append(root.Children, tree)
+			}
+		}
+		if err != nil {
+			return root, err
+		}
+	}
+	return root, nil
+}
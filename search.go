@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	pathpkg "path"
+	"regexp"
+	"strings"
+
+	"github.com/sbdtu5498/github-tree/pkg/ghtree"
+)
+
+// pathMatcher reports whether a repository path matches --search
+type pathMatcher func(path string) bool
+
+// parseSearch builds the matcher for --search: a regular expression
+// matched anywhere in the path with --regex, or else a glob matched against
+// the name, or against the whole path when it holds a slash
+func parseSearch(pattern string, isRegex bool) (pathMatcher, error) {
+	if isRegex {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --search pattern: %w", err)
+		}
+		return re.MatchString, nil
+	}
+
+	if _, err := pathpkg.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("invalid --search pattern %q: %w", pattern, err)
+	}
+	whole := strings.Contains(pattern, "/")
+	return func(path string) bool {
+		subject := path
+		if !whole {
+			subject = pathpkg.Base(path)
+		}
+		ok, _ := pathpkg.Match(pattern, subject)
+		return ok
+	}, nil
+}
+
+// checkEntryType validates --type
+func checkEntryType(t string) error {
+	switch t {
+	case "", "file", "dir", "symlink", "submodule":
+		return nil
+	}
+	return errors.New("--type must be file, dir, symlink or submodule")
+}
+
+// printSearch prints the repository path of every entry below roots that
+// matches, and has the --type if one was given, in tree order
+func printSearch(roots []*ghtree.Node, match pathMatcher) {
+	found := 0
+	var walk func(nodes []*ghtree.Node)
+	walk = func(nodes []*ghtree.Node) {
+		for _, node := range nodes {
+			if (typeFlag == "" || node.Type == typeFlag) && match(node.Path) {
+				fmt.Fprint(out, node.Path, pathEnd())
+				found++
+			}
+			walk(node.Children)
+		}
+	}
+	for _, root := range roots {
+		walk(root.TopLevel())
+	}
+	if found == 0 {
+		infof("no paths match %q", searchFlag)
+	}
+}
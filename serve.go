@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sbdtu5498/github-tree/pkg/ghtree"
+)
+
+// serve answers requests for trees on --listen until ctx ends:
+//
+//	GET /tree/{owner}/{repo}[/{path}]?ref=REF&depth=N&format=json|text
+//
+// Each request is walked by a client of its own made from opts, which
+// holds the flags of the command line. A request may bring its own token
+// as "Authorization: Bearer TOKEN"; otherwise the server's is used with
+// --share-token, and none without.
+func serve(ctx context.Context, opts ghtree.Options) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/tree/", func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		status := serveTree(w, r, opts)
+		debugf("%s %s: %d in %s", r.Method, r.URL, status, time.Since(start).Round(time.Millisecond))
+	})
+
+	server := &http.Server{Addr: listenFlag, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdown)
+	}()
+
+	infof("serving trees on %s", listenFlag)
+	err := server.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// serveTree answers one request for a tree and returns its status
+func serveTree(w http.ResponseWriter, r *http.Request, opts ghtree.Options) int {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		return serveError(w, &usageError{msg: "only GET is supported"}, http.StatusMethodNotAllowed)
+	}
+
+	// The path names the repository, then the path within it
+	segments := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/tree/"), "/", 3)
+	if len(segments) < 2 || segments[0] == "" || segments[1] == "" {
+		return serveError(w, &usageError{msg: "expected /tree/{owner}/{repo}[/{path}]"}, http.StatusNotFound)
+	}
+	opts.Owner, opts.Repo = segments[0], segments[1]
+	err := ghtree.CheckRepository(opts.Owner, opts.Repo)
+	if err != nil {
+		return serveError(w, &usageError{msg: err.Error()}, http.StatusBadRequest)
+	}
+	path := ""
+	if len(segments) == 3 {
+		path, err = ghtree.CleanPath(segments[2])
+		if err != nil {
+			return serveError(w, &usageError{msg: err.Error()}, http.StatusBadRequest)
+		}
+	}
+
+	query := r.URL.Query()
+	if ref := query.Get("ref"); ref != "" {
+		opts.Ref = ref
+	}
+	if depth := query.Get("depth"); depth != "" {
+		opts.MaxDepth, err = strconv.Atoi(depth)
+		if err != nil {
+			return serveError(w, &usageError{msg: "depth must be a number"}, http.StatusBadRequest)
+		}
+		opts.RecursiveAPI = opts.MaxDepth < 1 && !opts.GraphQL
+	}
+	format := query.Get("format")
+	switch format {
+	case "", "json":
+		format = "json"
+	case "text":
+	default:
+		return serveError(w, &usageError{msg: "format must be json or text"}, http.StatusBadRequest)
+	}
+
+	// A caller's own token replaces the server's, which is only lent out
+	// with --share-token. Cached listings are revalidated on every request
+	// made with another token, so GitHub checks that token's access.
+	token, ok := bearerToken(r)
+	if ok || !shareTokenFlag {
+		opts.Token, opts.App = token, nil
+		if opts.CacheDir != "" {
+			opts.CacheTTL = time.Nanosecond
+		}
+	}
+
+	if opts.Ignore != nil {
+		opts.Ignore = &ghtree.IgnoreRules{}
+	}
+	client := ghtree.NewClient(opts)
+	if opts.Ignore != nil {
+		err = loadIgnoreRules(r.Context(), client, opts.Ignore)
+		if err != nil {
+			return serveError(w, err, 0)
+		}
+	}
+	tree, err := client.Fetch(r.Context(), path)
+	if err != nil {
+		return serveError(w, err, 0)
+	}
+
+	if format == "text" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		err = tree.Render(w, ghtree.RenderOptions{ASCII: useASCII(), Sizes: sizesFlag})
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(tree)
+	}
+
+	// The status is sent by now, so a failed write can only be logged
+	if err != nil {
+		debugf("%s %s: failed to write the response: %v", r.Method, r.URL, err)
+	}
+	return http.StatusOK
+}
+
+// bearerToken returns the token of a request's Authorization header
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || (!strings.EqualFold(scheme, "bearer") && !strings.EqualFold(scheme, "token")) {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+// serveError answers with the JSON error object of --format json, under
+// status, or under the status matching err's class if status is zero
+func serveError(w http.ResponseWriter, err error, status int) int {
+	report := newErrorReport(err, exitCode(err))
+	if status == 0 {
+		switch report.Exit {
+		case exitUsage:
+			status = http.StatusBadRequest
+		case exitNotFound:
+			status = http.StatusNotFound
+		case exitAuth:
+			status = report.Status
+		case exitRateLimited:
+			status = http.StatusTooManyRequests
+			w.Header().Set("Retry-After", strconv.Itoa(report.RetryAfter))
+		case exitNetwork:
+			status = http.StatusBadGateway
+		default:
+			status = http.StatusInternalServerError
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{"error": report})
+	return status
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/sbdtu5498/github-tree/pkg/ghtree"
+)
+
+// languages maps file extensions to language names, after GitHub linguist's
+// most common entries
+var languages = map[string]string{
+	".c":     "C",
+	".h":     "C",
+	".cc":    "C++",
+	".cpp":   "C++",
+	".hpp":   "C++",
+	".cs":    "C#",
+	".css":   "CSS",
+	".dart":  "Dart",
+	".ex":    "Elixir",
+	".exs":   "Elixir",
+	".go":    "Go",
+	".hs":    "Haskell",
+	".html":  "HTML",
+	".htm":   "HTML",
+	".java":  "Java",
+	".js":    "JavaScript",
+	".cjs":   "JavaScript",
+	".mjs":   "JavaScript",
+	".jsx":   "JavaScript",
+	".json":  "JSON",
+	".kt":    "Kotlin",
+	".kts":   "Kotlin",
+	".lua":   "Lua",
+	".md":    "Markdown",
+	".m":     "Objective-C",
+	".php":   "PHP",
+	".pl":    "Perl",
+	".proto": "Protocol Buffer",
+	".py":    "Python",
+	".rb":    "Ruby",
+	".rs":    "Rust",
+	".scala": "Scala",
+	".scss":  "SCSS",
+	".sh":    "Shell",
+	".bash":  "Shell",
+	".zsh":   "Shell",
+	".sql":   "SQL",
+	".swift": "Swift",
+	".tf":    "HCL",
+	".toml":  "TOML",
+	".ts":    "TypeScript",
+	".tsx":   "TypeScript",
+	".vue":   "Vue",
+	".xml":   "XML",
+	".yaml":  "YAML",
+	".yml":   "YAML",
+}
+
+// languageFiles names the languages of files known by name alone
+var languageFiles = map[string]string{
+	"Dockerfile":     "Dockerfile",
+	"Makefile":       "Makefile",
+	"GNUmakefile":    "Makefile",
+	"CMakeLists.txt": "CMake",
+}
+
+// statGroup totals the files of one extension or language
+type statGroup struct {
+	Name  string `json:"name"`
+	Files int    `json:"files"`
+	Bytes int64  `json:"bytes"`
+}
+
+// statKey returns the group a file is counted in
+func statKey(name, groupBy string) string {
+	if groupBy == "language" {
+		if language, ok := languageFiles[name]; ok {
+			return language
+		}
+		if language, ok := languages[strings.ToLower(path.Ext(name))]; ok {
+			return language
+		}
+		return "Other"
+	}
+
+	ext := strings.ToLower(path.Ext(name))
+	if ext == "" || ext == name {
+		return "(none)"
+	}
+	return ext
+}
+
+// printStats tallies the files of roots by extension or language, largest
+// first, as a table or as JSON
+func printStats(roots []*ghtree.Node, groupBy string, asJSON bool) error {
+	groups := map[string]*statGroup{}
+	var files int
+	var size int64
+	var count func(nodes []*ghtree.Node)
+	count = func(nodes []*ghtree.Node) {
+		for _, node := range nodes {
+			switch node.Type {
+			case "dir":
+				count(node.Children)
+			case "file":
+				key := statKey(node.Name, groupBy)
+				if groups[key] == nil {
+					groups[key] = &statGroup{Name: key}
+				}
+				groups[key].Files++
+				groups[key].Bytes += node.Size
+				files++
+				size += node.Size
+			}
+		}
+	}
+	for _, root := range roots {
+		count(root.TopLevel())
+	}
+
+	sorted := make([]statGroup, 0, len(groups))
+	for _, group := range groups {
+		sorted = append(sorted, *group)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Bytes != sorted[j].Bytes {
+			return sorted[i].Bytes > sorted[j].Bytes
+		}
+		return sorted[i].Name < sorted[j].Name
+	})
+
+	if asJSON {
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(struct {
+			GroupBy string      `json:"groupBy"`
+			Groups  []statGroup `json:"groups"`
+			Files   int         `json:"files"`
+			Bytes   int64       `json:"bytes"`
+		}{groupBy, sorted, files, size})
+	}
+
+	heading := "EXTENSION"
+	if groupBy == "language" {
+		heading = "LANGUAGE"
+	}
+	w := tabwriter.NewWriter(out, 0, 0, 3, ' ', 0)
+	fmt.Fprintf(w, "%s\tFILES\tSIZE\tSHARE\n", heading)
+	for _, group := range sorted {
+		share := 0.0
+		if size > 0 {
+			share = float64(group.Bytes) / float64(size) * 100
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\t%.1f%%\n", group.Name, group.Files, ghtree.HumanizeBytes(group.Bytes), share)
+	}
+	fmt.Fprintf(w, "total\t%d\t%s\n", files, ghtree.HumanizeBytes(size))
+	return w.Flush()
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/sbdtu5498/github-tree/pkg/ghtree"
+)
+
+// treeignoreName is the file of .gitignore-style exclusions read from the
+// repository root and the current directory
+const treeignoreName = ".treeignore"
+
+// loadIgnoreRules adds the rules of the repository's .treeignore, then those
+// of the local one, so local rules take precedence. The local file is
+// --ignore-file, or .treeignore in the current directory if there is one.
+// With --local the repository is the directory given.
+func loadIgnoreRules(ctx context.Context, client *ghtree.Client, rules *ghtree.IgnoreRules) error {
+	var remote []byte
+	var err error
+	switch {
+	case localFlag != "":
+		remote, err = os.ReadFile(filepath.Join(localFlag, treeignoreName))
+		if errors.Is(err, os.ErrNotExist) {
+			err = nil
+		}
+	case providerFlag == "github" && gistFlag == "" && !offlineFlag:
+		remote, err = client.ReadFile(ctx, treeignoreName)
+		var apiErr *ghtree.APIError
+		if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+			err = nil
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read the repository's %s: %w", treeignoreName, err)
+	}
+	if remote != nil {
+		debugf("applying the repository's %s", treeignoreName)
+		err = rules.Add(bytes.NewReader(remote))
+		if err != nil {
+			return fmt.Errorf("the repository's %s: %w", treeignoreName, err)
+		}
+	}
+
+	local := ignoreFileFlag
+	if local == "" {
+		local = treeignoreName
+		if _, err := os.Stat(local); err != nil {
+			return nil
+		}
+	}
+	data, err := os.ReadFile(local)
+	if err != nil {
+		return fmt.Errorf("failed to read ignore file: %w", err)
+	}
+	debugf("applying %s", local)
+	err = rules.Add(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("%s: %w", local, err)
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// flagGroups orders the flags in --help. Flags missing from every group are
+// listed under "Other" so new ones never go undocumented.
+var flagGroups = []struct {
+	title string
+	names []string
+}{
+	{"Repository", []string{"owner", "repo", "repos-file", "all-repos", "repo-filter", "visibility", "archived", "path", "ref", "url", "provider", "gist", "local", "api-url", "token", "token-file", "app-id", "app-installation-id", "app-key-file"}},
+	{"Selection", []string{"maxDepth", "depth-override", "exclude", "ignore-file", "no-ignore", "include", "include-ext", "min-size", "max-size", "dirs-only", "max-entries", "max-path-depth", "follow-submodules", "sort", "traversal", "dirs-first", "reverse", "search", "regex", "type"}},
+	{"Output", []string{"format", "markdown-style", "verify-urls", "template", "preview", "owners", "last-commit", "relative-time", "absolute-time", "show-sha", "lfs", "hyperlinks", "display-name", "icons", "output-file", "ascii", "charset", "color", "no-color", "sizes", "count-collapsed", "width", "width-mode", "empty-marker", "no-summary", "depth-histogram", "json-stats", "group-by", "top", "no-trailing-newline", "null"}},
+	{"Actions", []string{"base", "head", "diff-repo", "verify", "contents", "snapshot", "since-snapshot", "stats", "du", "interactive", "list-refs", "list-repos", "preflight", "dry-run", "watch", "interval", "download", "overwrite", "archive", "zip"}},
+	{"Network", []string{"recursive-api", "graphql", "concurrency", "parallel-until", "parallel", "rps", "timeout", "deadline", "ca-cert", "insecure", "listen", "share-token", "retries", "retry-max-wait", "wait-on-rate-limit", "max-wait", "show-rate-limit", "cache-ttl", "no-cache", "offline"}},
+	{"Configuration", []string{"config", "profile", "save", "no-save", "no-persist"}},
+	{"Diagnostics", []string{"quiet", "verbose", "v", "vv", "log-format", "no-progress", "print-curl", "print-curl-unsafe", "version"}},
+}
+
+// flagChoices lists the accepted values of flags that take a fixed set, for
+// shell completion
+var flagChoices = map[string][]string{
+	"format":         {"text", "json", "jsonl", "yaml", "csv", "tsv", "paths", "markdown", "html", "dot", "mermaid", "raw-urls"},
+	"markdown-style": {"list", "code"},
+	"sort":           {"type", "name", "size", "ext", "commit-date", "none"},
+	"traversal":      {"dfs", "bfs"},
+	"color":          {"auto", "always", "never"},
+	"charset":        {"auto", "unicode", "ascii"},
+	"provider":       {"github", "gitlab", "bitbucket"},
+	"width-mode":     {"wrap", "truncate"},
+	"group-by":       {"ext", "language"},
+	"type":           {"file", "dir", "symlink", "submodule"},
+	"visibility":     {"all", "public", "private", "internal"},
+	"archived":       {"exclude", "include", "only"},
+	"icons":          {"nerd", "emoji", "none"},
+	"log-format":     {"text", "json"},
+}
+
+// fileFlags take a file or directory path, for shell completion
+var fileFlags = map[string]bool{
+	"output-file":    true,
+	"local":          true,
+	"ignore-file":    true,
+	"repos-file":     true,
+	"since-snapshot": true,
+	"zip":            true,
+	"archive":        true,
+	"download":       true,
+	"config":         true,
+	"token-file":     true,
+	"ca-cert":        true,
+	"app-key-file":   true,
+}
+
+// usage prints every flag once, with its aliases, grouped by purpose
+func usage() {
+	w := flag.CommandLine.Output()
+	fmt.Fprintln(w, "Usage: github-tree [command] [flags] [arguments]")
+
+	// Commands lead; each has its own --help
+	fmt.Fprintf(w, "\nCommands:\n")
+	tw := tabwriter.NewWriter(w, 0, 0, 3, ' ', 0)
+	for _, cmd := range commands {
+		fmt.Fprintf(tw, "  %s %s\t%s\n", cmd.name, cmd.args, cmd.summary)
+	}
+	tw.Flush()
+
+	listed := map[string]bool{}
+	for _, group := range flagGroups {
+		printFlagGroup(group.title, group.names)
+		for _, name := range group.names {
+			listed[name] = true
+		}
+	}
+
+	// Catch flags nobody has placed in a group yet
+	var other []string
+	for _, name := range longFlagNames() {
+		if !listed[name] {
+			other = append(other, name)
+		}
+	}
+	if len(other) > 0 {
+		printFlagGroup("Other", other)
+	}
+}
+
+// dashes returns how a flag is written before its name: one dash for short
+// flags, which includes -vv as a doubled -v, and two for the others
+func dashes(name string) string {
+	if len(name) == 1 || name == "vv" {
+		return "-"
+	}
+	return "--"
+}
+
+func printFlagGroup(title string, names []string) {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "\n%s:\n", title)
+
+	w := tabwriter.NewWriter(out, 0, 0, 3, ' ', 0)
+	for _, name := range names {
+		f := flag.Lookup(name)
+		if f == nil {
+			continue
+		}
+
+		// Short aliases lead, long ones follow the flag's own name
+		var names []string
+		for _, alias := range aliasesOf(name) {
+			if len(alias) == 1 {
+				names = append(names, "-"+alias)
+			}
+		}
+		names = append(names, dashes(name)+name)
+		for _, alias := range aliasesOf(name) {
+			if len(alias) > 1 {
+				names = append(names, "--"+alias)
+			}
+		}
+
+		valueName, description := flag.UnquoteUsage(f)
+		spec := strings.Join(names, ", ")
+		if valueName != "" {
+			spec += " " + valueName
+		}
+		if f.DefValue != "" && f.DefValue != "false" && f.DefValue != "0" && f.DefValue != "0s" {
+			description += fmt.Sprintf(" (default %s)", f.DefValue)
+		}
+		fmt.Fprintf(w, "  %s\t%s\n", spec, description)
+	}
+	w.Flush()
+}
+
+// aliasesOf returns the aliases of a flag in a stable order
+func aliasesOf(name string) []string {
+	var aliases []string
+	for alias, target := range flagAliases {
+		if target == name {
+			aliases = append(aliases, alias)
+		}
+	}
+	sort.Strings(aliases)
+	return aliases
+}
+
+// longFlagNames returns every flag that isn't an alias, sorted
+func longFlagNames() []string {
+	var names []string
+	flag.VisitAll(func(f *flag.Flag) {
+		if _, alias := flagAliases[f.Name]; !alias {
+			names = append(names, f.Name)
+		}
+	})
+	return names
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
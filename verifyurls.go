@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/sbdtu5498/github-tree/pkg/ghtree"
+)
+
+// rawURLRef is the ref raw URLs are pinned to: ref, or the default branch
+// when the default one was listed
+func rawURLRef(ctx context.Context, client *ghtree.Client, ref string) (string, error) {
+	if ref != "" {
+		return ref, nil
+	}
+	info, err := client.Repository(ctx)
+	if err != nil {
+		return "", err
+	}
+	return info.DefaultBranch, nil
+}
+
+// downloadURL is the URL that serves a file's contents: the one the listing
+// reported, or else the raw URL --format raw-urls prints
+func downloadURL(client *ghtree.Client, ref string, node *ghtree.Node) string {
+	if node.DownloadURL != "" && formatFlag != "raw-urls" {
+		return node.DownloadURL
+	}
+	return client.RawURL(ref, node.Path)
+}
+
+// checkDownloadURLs sends a HEAD request for the download URL of every
+// file below roots, concurrently; the client bounds the requests in flight.
+// It returns why each broken one failed, keyed by file.
+func checkDownloadURLs(ctx context.Context, client *ghtree.Client, ref string, roots []*ghtree.Node) (map[*ghtree.Node]string, error) {
+	ref, err := rawURLRef(ctx, client, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []*ghtree.Node
+	var collect func(nodes []*ghtree.Node)
+	collect = func(nodes []*ghtree.Node) {
+		for _, node := range nodes {
+			if node.Type == "file" {
+				files = append(files, node)
+			}
+			collect(node.Children)
+		}
+	}
+	for _, root := range roots {
+		collect(root.TopLevel())
+	}
+
+	broken := map[*ghtree.Node]string{}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, node := range files {
+		wg.Add(1)
+		go func(node *ghtree.Node) {
+			defer wg.Done()
+			err := client.CheckURL(ctx, downloadURL(client, ref, node))
+			if err == nil {
+				return
+			}
+
+			// A URL that answers names its status; others didn't answer
+			reason := "unreachable"
+			var apiErr *ghtree.APIError
+			if errors.As(err, &apiErr) {
+				reason = apiErr.Status
+			}
+			mu.Lock()
+			broken[node] = reason
+			mu.Unlock()
+		}(node)
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(broken) > 0 {
+		warnf("%s did not resolve without a token", plural(len(broken), "download URL", "download URLs"))
+	}
+	return broken, nil
+}
+
+// brokenURLAnnotation marks the files whose download URLs failed, with why
+func brokenURLAnnotation(broken map[*ghtree.Node]string) func(node *ghtree.Node) string {
+	return func(node *ghtree.Node) string {
+		reason, ok := broken[node]
+		if !ok {
+			return ""
+		}
+		return "[broken: " + reason + "]"
+	}
+}
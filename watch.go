@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sbdtu5498/github-tree/pkg/ghtree"
+)
+
+// treeChange is one structural change --watch reports
+type treeChange struct {
+	mark string
+	path string
+	from string
+}
+
+// watch fetches the trees of paths again every --interval and prints what
+// was added, removed or renamed since roots, or the previous fetch, until
+// ctx ends. A failed fetch is reported and retried at the next tick.
+func watch(ctx context.Context, client *ghtree.Client, roots []*ghtree.Node, paths []string) error {
+	previous := indexEntries(roots)
+	infof("watching for changes every %s; press Ctrl-C to stop", intervalFlag)
+
+	ticker := time.NewTicker(intervalFlag)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		current := make([]*ghtree.Node, 0, len(paths))
+		failed := false
+		for _, path := range paths {
+			fetched, err := client.Fetch(ctx, path)
+			if ctx.Err() != nil {
+				return nil
+			}
+			if err != nil {
+				warnf("failed to fetch %s: %v", displayPath(path), err)
+				failed = true
+				break
+			}
+			current = append(current, fetched)
+		}
+		if failed {
+			continue
+		}
+		if len(paths) > 1 {
+			current = []*ghtree.Node{joinPaths(current)}
+		}
+
+		entries := indexEntries(current)
+		stamp := time.Now().Format("15:04:05")
+		for _, change := range treeChanges(previous, entries) {
+			if change.from != "" {
+				fmt.Fprintf(out, "%s %s %s -> %s\n", stamp, change.mark, change.from, change.path)
+			} else {
+				fmt.Fprintf(out, "%s %s %s\n", stamp, change.mark, change.path)
+			}
+		}
+		previous = entries
+	}
+}
+
+// indexEntries maps the repository path of every entry below roots to the
+// entry; directories get a trailing slash
+func indexEntries(roots []*ghtree.Node) map[string]*ghtree.Node {
+	entries := map[string]*ghtree.Node{}
+	var walk func(nodes []*ghtree.Node)
+	walk = func(nodes []*ghtree.Node) {
+		for _, node := range nodes {
+			key := node.Path
+			if node.Type == "dir" {
+				key += "/"
+			}
+			entries[key] = node
+			walk(node.Children)
+		}
+	}
+	for _, root := range roots {
+		walk(root.TopLevel())
+	}
+	return entries
+}
+
+// treeChanges lists the entries added to and removed from before, in path
+// order. An entry removed and one added with the same type and SHA count
+// as a rename, and the entries below a renamed directory aren't repeated.
+func treeChanges(before, after map[string]*ghtree.Node) []treeChange {
+	var added, removed []string
+	for key := range after {
+		if before[key] == nil {
+			added = append(added, key)
+		}
+	}
+	for key := range before {
+		if after[key] == nil {
+			removed = append(removed, key)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+
+	// Pair renames by object ID; directories sort before their entries, so
+	// a renamed directory is paired before anything below it
+	renamedFrom := map[string]string{}
+	paired := map[string]bool{}
+	var renamedDirs [][2]string
+	for _, key := range added {
+		if under(key, renamedDirs, 1) {
+			continue
+		}
+		node := after[key]
+		if node.SHA == "" {
+			continue
+		}
+		for _, old := range removed {
+			if !paired[old] && before[old].SHA == node.SHA && before[old].Type == node.Type {
+				paired[old] = true
+				renamedFrom[key] = old
+				if node.Type == "dir" {
+					renamedDirs = append(renamedDirs, [2]string{old, key})
+				}
+				break
+			}
+		}
+	}
+
+	var changes []treeChange
+	for _, key := range added {
+		switch {
+		case renamedFrom[key] != "":
+			changes = append(changes, treeChange{mark: "R", path: key, from: renamedFrom[key]})
+		case !under(key, renamedDirs, 1):
+			changes = append(changes, treeChange{mark: "+", path: key})
+		}
+	}
+	for _, key := range removed {
+		if !paired[key] && !under(key, renamedDirs, 0) {
+			changes = append(changes, treeChange{mark: "-", path: key})
+		}
+	}
+	sort.SliceStable(changes, func(i, j int) bool { return changes[i].path < changes[j].path })
+	return changes
+}
+
+// under reports whether key lies below one of dirs, comparing against the
+// old (side 0) or new (side 1) directory path
+func under(key string, dirs [][2]string, side int) bool {
+	for _, dir := range dirs {
+		if key != dir[side] && strings.HasPrefix(key, dir[side]) {
+			return true
+		}
+	}
+	return false
+}